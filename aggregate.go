@@ -0,0 +1,509 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// Key prefixes
+	HouseAggKeyPrefix = "house:agg" // Prefix for aggregates derived from daily house data
+)
+
+// Aggregation periods
+const (
+	PeriodWeek    = "week"
+	PeriodMonth   = "month"
+	PeriodQuarter = "quarter"
+	PeriodYear    = "year"
+)
+
+var aggregatePeriods = []string{PeriodWeek, PeriodMonth, PeriodQuarter, PeriodYear}
+
+// aggregateMu serializes the recomputation of stored aggregates, so that an
+// older computation never overwrites a newer one
+var aggregateMu sync.Mutex
+
+// monthMismatchTolerance is the relative difference allowed between a derived
+// month and the month_data posted for it before the month is flagged
+const monthMismatchTolerance = 0.005
+
+// HouseAggregate rolls up the daily records of a region over a week, month,
+// quarter or year by summing every field.
+type HouseAggregate struct {
+	Region    string    `json:"region"`
+	Period    string    `json:"period"`
+	Bucket    string    `json:"bucket"` // 2025-W23, 2025-06, 2025-Q2 or 2025
+	From      string    `json:"from"`
+	To        string    `json:"to"`
+	Days      int       `json:"days"`           // Daily records rolled up
+	TotalDays int       `json:"days_in_period"` // Calendar (or working) days in the bucket
+	Data      DailyData `json:"data"`
+	UpdatedAt time.Time `json:"updated_at"`
+
+	// Set when only working days are rolled up; such aggregates are computed
+	// on request and not stored
+	WorkdaysOnly bool `json:"workdays_only,omitempty"`
+
+	Derived *DerivedMetrics `json:"derived,omitempty"` // Filled on read, never stored
+
+	// Month buckets only: the posted month_data and the fields that disagree
+	// with the derived values (derived - posted)
+	Posted      *MonthData         `json:"posted_month_data,omitempty"`
+	Mismatch    bool               `json:"mismatch,omitempty"`
+	Differences map[string]float64 `json:"differences,omitempty"`
+}
+
+// MonthHouse returns the aggregate in the monthly response format
+func (a HouseAggregate) MonthHouse() MonthHouseResp {
+	return MonthHouseResp{
+		Month: a.Bucket,
+		MonthData: MonthData{
+			TotalCount: a.Data.TotalCount,
+			TotalArea:  a.Data.TotalArea,
+			HouseCount: a.Data.HouseCount,
+			HouseArea:  a.Data.HouseArea,
+		},
+	}
+}
+
+// bucketFor returns the bucket of period containing t and its first and last day
+func bucketFor(period string, t time.Time) (string, time.Time, time.Time, error) {
+	t = time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	switch period {
+	case PeriodWeek:
+		// ISO weeks start on Monday
+		from := t.AddDate(0, 0, -((int(t.Weekday()) + 6) % 7))
+		year, week := t.ISOWeek()
+		return fmt.Sprintf("%d-W%02d", year, week), from, from.AddDate(0, 0, 6), nil
+	case PeriodMonth:
+		from := time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+		return from.Format(monthLayout), from, from.AddDate(0, 1, -1), nil
+	case PeriodQuarter:
+		q := (int(t.Month()) - 1) / 3
+		from := time.Date(t.Year(), time.Month(q*3+1), 1, 0, 0, 0, 0, time.UTC)
+		return fmt.Sprintf("%d-Q%d", t.Year(), q+1), from, from.AddDate(0, 3, -1), nil
+	case PeriodYear:
+		from := time.Date(t.Year(), 1, 1, 0, 0, 0, 0, time.UTC)
+		return fmt.Sprint(t.Year()), from, from.AddDate(1, 0, -1), nil
+	}
+	return "", t, t, fmt.Errorf("unknown period %q (must be week, month, quarter or year)", period)
+}
+
+// parseBucket returns the first day of a bucket name of period
+func parseBucket(period, bucket string) (time.Time, error) {
+	var t time.Time
+	var err error
+	switch period {
+	case PeriodWeek:
+		var year, week int
+		if _, err = fmt.Sscanf(bucket, "%d-W%d", &year, &week); err == nil {
+			// January 4th is always in week 1
+			jan4 := time.Date(year, 1, 4, 0, 0, 0, 0, time.UTC)
+			t = jan4.AddDate(0, 0, (week-1)*7)
+		}
+	case PeriodMonth:
+		t, err = time.Parse(monthLayout, bucket)
+	case PeriodQuarter:
+		var year, q int
+		if _, err = fmt.Sscanf(bucket, "%d-Q%d", &year, &q); err == nil && (q < 1 || q > 4) {
+			err = fmt.Errorf("quarter out of range")
+		}
+		t = time.Date(year, time.Month((q-1)*3+1), 1, 0, 0, 0, 0, time.UTC)
+	case PeriodYear:
+		t, err = time.Parse("2006", bucket)
+	default:
+		return t, fmt.Errorf("unknown period %q (must be week, month, quarter or year)", period)
+	}
+	if err != nil {
+		return t, fmt.Errorf("invalid %s bucket %q", period, bucket)
+	}
+	// Reject names that do not round-trip, e.g. 2025-W60
+	name, from, _, _ := bucketFor(period, t)
+	if name != bucket {
+		return t, fmt.Errorf("invalid %s bucket %q", period, bucket)
+	}
+	return from, nil
+}
+
+// Key format: house:agg:{region}:{period}:{bucket}
+func formatAggregateKey(region, period, bucket string) string {
+	return fmt.Sprintf("%s:%s:%s:%s", HouseAggKeyPrefix, region, period, bucket)
+}
+
+// isDayRecord reports whether day is a daily (not hourly) record key; only
+// those are rolled up, hourly records belong to other datasets
+func isDayRecord(day string) bool {
+	return len(day) == len(dayLayout)
+}
+
+// aggregateDailyData sums the fields of records
+func aggregateDailyData(records []DailyHouseResp) DailyData {
+	var sum DailyData
+	for _, r := range records {
+		d := r.DailyData
+		sum.TotalCount += d.TotalCount
+		sum.TotalArea += d.TotalArea
+		sum.HouseCount += d.HouseCount
+		sum.HouseArea += d.HouseArea
+		sum.HousePrice += d.HousePrice
+		sum.TotalPrice += d.TotalPrice
+	}
+	return sum
+}
+
+// monthDifferences returns the fields where derived differs from posted by
+// more than monthMismatchTolerance
+func monthDifferences(derived, posted MonthData) map[string]float64 {
+	diffs := make(map[string]float64)
+	postedMetrics := posted.Metrics()
+	for name, d := range derived.Metrics() {
+		p := postedMetrics[name]
+		if math.Abs(d-p) > monthMismatchTolerance*math.Max(math.Abs(p), 1) {
+			diffs[name] = d - p
+		}
+	}
+	return diffs
+}
+
+// computeAggregate rolls up the daily records of the bucket of period
+// containing t; with workdaysOnly only working days are rolled up
+func computeAggregate(ctx context.Context, region, period string, t time.Time, workdaysOnly bool) (HouseAggregate, error) {
+	bucket, from, to, err := bucketFor(period, t)
+	if err != nil {
+		return HouseAggregate{}, err
+	}
+	agg := HouseAggregate{
+		Region:       region,
+		Period:       period,
+		Bucket:       bucket,
+		From:         from.Format(dayLayout),
+		To:           to.Format(dayLayout),
+		TotalDays:    int(to.Sub(from).Hours()/24) + 1,
+		WorkdaysOnly: workdaysOnly,
+		UpdatedAt:    time.Now(),
+	}
+	if workdaysOnly {
+		agg.TotalDays = 0
+		for d := from; !d.After(to); d = d.AddDate(0, 0, 1) {
+			if holidayCalendar.IsWorkday(d) {
+				agg.TotalDays++
+			}
+		}
+	}
+
+	days, err := GetHouseDaysInRange(ctx, from, to.Add(24*time.Hour-time.Second), region)
+	if err != nil {
+		return agg, err
+	}
+	var rollup []string
+	for _, day := range days {
+		if isDayRecord(day) && (!workdaysOnly || isWorkdayRecord(day)) {
+			rollup = append(rollup, day)
+		}
+	}
+	records, err := getHouseRecords(ctx, region, rollup)
+	if err != nil {
+		return agg, err
+	}
+	agg.Days = len(records)
+	agg.Data = aggregateDailyData(records)
+
+	if period == PeriodMonth && !workdaysOnly {
+		if err := comparePostedMonth(ctx, &agg); err != nil {
+			return agg, err
+		}
+	}
+
+	return agg, nil
+}
+
+// comparePostedMonth sets the posted month data of a month aggregate and the
+// fields where the derived values disagree with it
+func comparePostedMonth(ctx context.Context, agg *HouseAggregate) error {
+	agg.Posted, agg.Mismatch, agg.Differences = nil, false, nil
+	posted, found, err := GetMonthHouseData(ctx, agg.Bucket, agg.Region)
+	if err != nil {
+		return err
+	}
+	if !found || agg.Days == 0 {
+		return nil
+	}
+	agg.Posted = &posted.MonthData
+	if diffs := monthDifferences(agg.MonthHouse().MonthData, posted.MonthData); len(diffs) > 0 {
+		agg.Mismatch, agg.Differences = true, diffs
+		log.Logger.Warn().Str("region", agg.Region).Str("month", agg.Bucket).Interface("differences", diffs).Msg("Derived month disagrees with posted month data")
+	}
+	return nil
+}
+
+// refreshAggregate recomputes and stores the aggregate of period containing t
+func refreshAggregate(ctx context.Context, region, period string, t time.Time) (HouseAggregate, error) {
+	agg, err := computeAggregate(ctx, region, period, t, false)
+	if err != nil {
+		return agg, err
+	}
+	return agg, storeAggregate(ctx, agg)
+}
+
+// storeAggregate stores agg under its bucket key
+func storeAggregate(ctx context.Context, agg HouseAggregate) error {
+	jsonData, err := json.Marshal(agg)
+	if err != nil {
+		return err
+	}
+	key := formatAggregateKey(agg.Region, agg.Period, agg.Bucket)
+	if err := storageFor(ctx).Set(ctx, key, jsonData); err != nil {
+		log.Logger.Error().Err(err).Str("key", key).Msg("Failed to store house aggregate")
+		return err
+	}
+	return nil
+}
+
+// refreshAggregates recomputes every aggregate containing day from the daily
+// records stored in its buckets. Hourly records and writes made with deferred
+// aggregates are skipped.
+func refreshAggregates(ctx context.Context, region, day string) error {
+	if !isDayRecord(day) || aggregatesDeferred(ctx) {
+		return nil
+	}
+	t, err := time.Parse(dayLayout, day)
+	if err != nil {
+		return err
+	}
+
+	aggregateMu.Lock()
+	defer aggregateMu.Unlock()
+	var errs []error
+	for _, period := range aggregatePeriods {
+		if _, err := refreshAggregate(ctx, region, period, t); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// refreshAggregatesForDays recomputes the aggregates containing days, once per bucket
+func refreshAggregatesForDays(ctx context.Context, region string, days []string) error {
+	aggregateMu.Lock()
+	defer aggregateMu.Unlock()
+	done := make(map[string]bool)
+	var errs []error
+	for _, day := range days {
+		if !isDayRecord(day) {
+			continue
+		}
+		t, err := time.Parse(dayLayout, day)
+		if err != nil {
+			continue
+		}
+		for _, period := range aggregatePeriods {
+			bucket, _, _, _ := bucketFor(period, t)
+			if done[period+bucket] {
+				continue
+			}
+			done[period+bucket] = true
+			if _, err := refreshAggregate(ctx, region, period, t); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// refreshAggregatesForKey refreshes the aggregates depending on a daily or
+// monthly house key written directly, e.g. by a rollback
+func refreshAggregatesForKey(ctx context.Context, key string) error {
+	if rest, ok := strings.CutPrefix(key, HouseDailyKeyPrefix+":"); ok {
+		if region, day, ok := strings.Cut(rest, ":"); ok {
+			return refreshAggregates(ctx, region, day)
+		}
+	}
+	if rest, ok := strings.CutPrefix(key, HouseMonthlyKeyPrefix+":"); ok {
+		if region, month, ok := strings.Cut(rest, ":"); ok {
+			if t, err := time.Parse(monthLayout, month); err == nil {
+				aggregateMu.Lock()
+				defer aggregateMu.Unlock()
+				_, err := refreshAggregate(ctx, region, PeriodMonth, t)
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+type deferAggregatesCtxKey struct{}
+
+// deferAggregates returns a context whose house writes leave the aggregates
+// alone; the caller refreshes them with refreshAggregatesForDays when done
+func deferAggregates(ctx context.Context) context.Context {
+	return context.WithValue(ctx, deferAggregatesCtxKey{}, true)
+}
+
+func aggregatesDeferred(ctx context.Context) bool {
+	deferred, _ := ctx.Value(deferAggregatesCtxKey{}).(bool)
+	return deferred
+}
+
+// GetAggregate returns the stored aggregate of a bucket
+func GetAggregate(ctx context.Context, region, period, bucket string) (HouseAggregate, bool, error) {
+	var agg HouseAggregate
+
+	key := formatAggregateKey(region, period, bucket)
+	jsonData, found, err := storageFor(ctx).Get(ctx, key)
+	if err != nil {
+		log.Logger.Error().Err(err).Str("key", key).Msg("Failed to get house aggregate")
+		return agg, false, err
+	} else if !found {
+		return agg, false, nil
+	}
+
+	if err := json.Unmarshal([]byte(jsonData), &agg); err != nil {
+		log.Logger.Error().Err(err).Str("key", key).Msg("Failed to unmarshal house aggregate")
+		return agg, false, err
+	}
+	return agg, true, nil
+}
+
+// getMonthHouse returns the posted month data, or the month derived from the
+// daily records when none was posted
+func getMonthHouse(ctx context.Context, month, region string) (MonthHouseResp, bool, error) {
+	monthData, found, err := GetMonthHouseData(ctx, month, region)
+	if err != nil || found {
+		return monthData, found, err
+	}
+	agg, found, err := GetAggregate(ctx, region, PeriodMonth, month)
+	if err != nil || !found || agg.Days == 0 {
+		return monthData, false, err
+	}
+	return agg.MonthHouse(), true, nil
+}
+
+// getAggregate returns an aggregate: period (default month) and bucket
+// (default the current one). workdays_only=true rolls up working days only.
+func getAggregate(c *gin.Context) {
+	region := requestRegion(c)
+	period := c.DefaultQuery("period", PeriodMonth)
+	bucket, ok := c.GetQuery("bucket")
+	if !ok {
+		var err error
+		if bucket, _, _, err = bucketFor(period, time.Now()); err != nil {
+			respondValidationError(c, []FieldError{{Field: "period", Rule: "oneof", Message: err.Error()}})
+			return
+		}
+	}
+	start, err := parseBucket(period, bucket)
+	if err != nil {
+		respondValidationError(c, []FieldError{{Field: "bucket", Rule: "bucket", Message: err.Error()}})
+		return
+	}
+
+	if workdaysOnly(c) {
+		agg, err := computeAggregate(ctx, region, period, start, true)
+		if err != nil {
+			log.Logger.Error().Err(err).Str("region", region).Str("bucket", bucket).Msg("Failed to compute working-day aggregate")
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get aggregate"})
+			return
+		}
+		if agg.Days == 0 {
+			c.JSON(http.StatusNotFound, gin.H{"msg": "data not found"})
+			return
+		}
+		c.JSON(http.StatusOK, withDerived(c, agg))
+		return
+	}
+
+	agg, found, err := GetAggregate(ctx, region, period, bucket)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get aggregate"})
+		return
+	}
+	if !found {
+		c.JSON(http.StatusNotFound, gin.H{"msg": "data not found"})
+		return
+	}
+	c.JSON(http.StatusOK, withDerived(c, agg))
+}
+
+// getMonthMismatches lists the months between from and to (YYYY-MM, default
+// the last 12 months) whose derived data disagrees with the posted month data
+func getMonthMismatches(c *gin.Context) {
+	region := requestRegion(c)
+	now := time.Now()
+	fromParam := c.DefaultQuery("from", now.AddDate(0, -11, 0).Format(monthLayout))
+	toParam := c.DefaultQuery("to", now.Format(monthLayout))
+
+	errs := append(checkDate("from", fromParam, monthLayout), checkDate("to", toParam, monthLayout)...)
+	if len(errs) > 0 {
+		respondValidationError(c, errs)
+		return
+	}
+	from, _ := time.Parse(monthLayout, fromParam)
+	to, _ := time.Parse(monthLayout, toParam)
+	if to.Before(from) || to.Sub(from) > time.Duration(appConfig.MaxRangeDays)*24*time.Hour {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("to must not be before from, and the range must be at most %d days", appConfig.MaxRangeDays)})
+		return
+	}
+
+	mismatches := []HouseAggregate{}
+	for m := from; !m.After(to); m = m.AddDate(0, 1, 0) {
+		agg, found, err := GetAggregate(ctx, region, PeriodMonth, m.Format(monthLayout))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get aggregate"})
+			return
+		}
+		if found && agg.Mismatch {
+			mismatches = append(mismatches, agg)
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"region":     region,
+		"from":       fromParam,
+		"to":         toParam,
+		"mismatches": mismatches,
+	})
+}
+
+// rebuildAggregates recomputes the aggregates of a region for the days
+// stored between from and to, e.g. after importing data written before
+// aggregation existed
+func rebuildAggregates(c *gin.Context) {
+	region := c.Query("region")
+	if region == "" {
+		respondValidationError(c, []FieldError{{Field: "region", Rule: "required", Message: "is required"}})
+		return
+	}
+	from, err := parseRangeBound(c.Query("from"), false)
+	if err != nil {
+		respondValidationError(c, []FieldError{{Field: "from", Rule: "date", Message: "must be a valid date in YYYY-MM-DD format"}})
+		return
+	}
+	to, err := parseRangeBound(c.DefaultQuery("to", getTodayDay()), true)
+	if err != nil {
+		respondValidationError(c, []FieldError{{Field: "to", Rule: "date", Message: "must be a valid date in YYYY-MM-DD format"}})
+		return
+	}
+
+	wctx := requestContext(c)
+	days, err := GetHouseDaysInRange(wctx, from, to, region)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get house days"})
+		return
+	}
+	if err := refreshAggregatesForDays(wctx, region, days); err != nil {
+		log.Logger.Error().Err(err).Str("region", region).Msg("Failed to rebuild aggregates")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to rebuild aggregates"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"msg": "aggregates rebuilt", "region": region, "days": len(days)})
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBucketNames(t *testing.T) {
+	day, _ := time.Parse(dayLayout, "2024-12-30")
+	cases := map[string]string{
+		PeriodWeek:    "2025-W01",
+		PeriodMonth:   "2024-12",
+		PeriodQuarter: "2024-Q4",
+		PeriodYear:    "2024",
+	}
+	for period, want := range cases {
+		bucket, from, _, err := bucketFor(period, day)
+		if err != nil || bucket != want {
+			t.Errorf("%s: got %q (%v), want %q", period, bucket, err, want)
+			continue
+		}
+		start, err := parseBucket(period, bucket)
+		if err != nil || !start.Equal(from) {
+			t.Errorf("%s: parse %q = %v (%v), want %v", period, bucket, start, err, from)
+		}
+	}
+	if _, err := parseBucket(PeriodWeek, "2025-W60"); err == nil {
+		t.Error("expected error for week 60")
+	}
+}
+
+func TestStoreHouseDataRefreshesAggregates(t *testing.T) {
+	EnableMockRedisForTesting()
+	month := MonthHouseResp{Month: "2025-04", MonthData: MonthData{TotalCount: 30, TotalArea: 300}}
+	if err := StoreMonthHouseData(ctx, month.Month, month, beijingKey); err != nil {
+		t.Fatal(err)
+	}
+	records := []DailyHouseResp{
+		{Day: "2025-04-10", DailyData: DailyData{TotalCount: 10, TotalArea: 100, TotalPrice: 50000}},
+		{Day: "2025-04-11", DailyData: DailyData{TotalCount: 20, TotalArea: 300, TotalPrice: 60000}},
+		{Day: "2025-04-11-00", DailyData: DailyData{TotalCount: 99}}, // new house record, not rolled up
+	}
+	for _, r := range records {
+		if err := StoreHouseData(ctx, r.Day, r, beijingKey); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	agg, found, err := GetAggregate(ctx, beijingKey, PeriodMonth, "2025-04")
+	if err != nil || !found {
+		t.Fatalf("month aggregate not stored: %v", err)
+	}
+	if agg.Days != 2 || agg.Data.TotalCount != 30 || agg.Data.TotalArea != 400 || agg.Data.TotalPrice != 110000 {
+		t.Errorf("unexpected aggregate %+v", agg)
+	}
+	if !agg.Mismatch || agg.Differences["total_area"] != 100 || len(agg.Differences) != 1 {
+		t.Errorf("expected total_area mismatch, got %v", agg.Differences)
+	}
+
+	if agg, _, _ := GetAggregate(ctx, beijingKey, PeriodQuarter, "2025-Q2"); agg.Days != 2 {
+		t.Errorf("quarter aggregate has %d days, want 2", agg.Days)
+	}
+}
+
+func TestAggregateRecomputedOnWrite(t *testing.T) {
+	useTestStorage(t)
+	records := []DailyHouseResp{
+		{Day: "2025-04-10", DailyData: DailyData{TotalCount: 10, TotalArea: 100}},
+		{Day: "2025-04-11", DailyData: DailyData{TotalCount: 20, TotalArea: 300}},
+		{Day: "2025-04-10", DailyData: DailyData{TotalCount: 15, TotalArea: 120}}, // overwrites the first
+	}
+	for _, r := range records {
+		if err := StoreHouseData(ctx, r.Day, r, beijingKey); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, period := range aggregatePeriods {
+		bucket, _, _, _ := bucketFor(period, time.Date(2025, 4, 10, 0, 0, 0, 0, time.UTC))
+		agg, found, err := GetAggregate(ctx, beijingKey, period, bucket)
+		if err != nil || !found || agg.Days != 2 || agg.Data.TotalCount != 35 || agg.Data.TotalArea != 420 {
+			t.Errorf("%s: unexpected aggregate %+v (%v)", period, agg, err)
+		}
+	}
+
+	// Rewriting an unchanged day still repairs a bucket that went stale
+	if err := storageFor(ctx).Set(ctx, formatAggregateKey(beijingKey, PeriodMonth, "2025-04"), []byte(`{"days":7}`)); err != nil {
+		t.Fatal(err)
+	}
+	if err := StoreHouseData(ctx, records[2].Day, records[2], beijingKey); err != nil {
+		t.Fatal(err)
+	}
+	if agg, _, _ := GetAggregate(ctx, beijingKey, PeriodMonth, "2025-04"); agg.Days != 2 || agg.Data.TotalCount != 35 {
+		t.Errorf("unexpected aggregate %+v", agg)
+	}
+}
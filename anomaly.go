@@ -0,0 +1,444 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// Key prefixes
+	HouseAnomaliesKeyPrefix      = "house:anomalies"       // List of flagged records per region
+	HouseQuarantineKeyPrefix     = "house:quarantine"      // Quarantined records
+	HouseQuarantineDaysKeyPrefix = "house:quarantine_days" // Sorted set of quarantined days per region
+)
+
+// Quarantined record states
+const (
+	quarantinePending   = "pending"
+	quarantineReleased  = "released"
+	quarantineDiscarded = "discarded"
+)
+
+// madScale makes the median absolute deviation comparable to a standard
+// deviation for normally distributed data
+const madScale = 0.6745
+
+// ErrQuarantined is returned by StoreHouseData when a record was flagged in
+// strict mode and stored in quarantine instead of being served
+var ErrQuarantined = errors.New("house data quarantined as anomalous")
+
+// QuarantinedError carries the anomaly that quarantined a record
+type QuarantinedError struct {
+	Anomaly Anomaly
+}
+
+func (e *QuarantinedError) Error() string {
+	return fmt.Sprintf("%s: %s %s", ErrQuarantined, e.Anomaly.Region, e.Anomaly.Day)
+}
+
+// Is makes errors.Is(err, ErrQuarantined) match
+func (e *QuarantinedError) Is(target error) bool {
+	return target == ErrQuarantined
+}
+
+// FieldScore is the robust z-score of one field against the recent history
+type FieldScore struct {
+	Field  string  `json:"field"`
+	Value  float64 `json:"value"`
+	Median float64 `json:"median"`
+	MAD    float64 `json:"mad"`
+	Score  float64 `json:"score"`
+}
+
+// Anomaly is a daily record with fields beyond the configured threshold
+type Anomaly struct {
+	Region      string       `json:"region"`
+	Day         string       `json:"day"`
+	Fields      []FieldScore `json:"fields"`
+	History     int          `json:"history"` // Records scored against
+	Threshold   float64      `json:"threshold"`
+	Quarantined bool         `json:"quarantined"`
+	DetectedAt  time.Time    `json:"detected_at"`
+	Caller      string       `json:"caller,omitempty"`
+	Source      string       `json:"source,omitempty"`
+}
+
+// QuarantinedRecord is a record held back by strict mode until an admin
+// releases or discards it
+type QuarantinedRecord struct {
+	Data      DailyHouseResp `json:"data"`
+	Anomaly   Anomaly        `json:"anomaly"`
+	Status    string         `json:"status"`
+	UpdatedAt time.Time      `json:"updated_at"`
+}
+
+// How StoreHouseData treats anomalies, set per write with withAnomalyMode
+const (
+	anomalyCheck    = iota // Score, and quarantine in strict mode
+	anomalyFlagOnly        // Score and flag but always store, e.g. forced writes
+	anomalySkip            // Do not score, e.g. released records
+)
+
+type anomalyModeCtxKey struct{}
+
+func withAnomalyMode(ctx context.Context, mode int) context.Context {
+	return context.WithValue(ctx, anomalyModeCtxKey{}, mode)
+}
+
+func anomalyModeFrom(ctx context.Context) int {
+	mode, _ := ctx.Value(anomalyModeCtxKey{}).(int)
+	return mode
+}
+
+// Key format: house:anomalies:{region}
+func formatAnomaliesKey(region string) string {
+	return fmt.Sprintf("%s:%s", HouseAnomaliesKeyPrefix, region)
+}
+
+// Key format: house:quarantine:{region}:{day}
+func formatQuarantineKey(region, day string) string {
+	return fmt.Sprintf("%s:%s:%s", HouseQuarantineKeyPrefix, region, day)
+}
+
+// Key format: house:quarantine_days:{region}
+func formatQuarantineDaysKey(region string) string {
+	return fmt.Sprintf("%s:%s", HouseQuarantineDaysKeyPrefix, region)
+}
+
+// median returns the median of values, which it sorts
+func median(values []float64) float64 {
+	slices.Sort(values)
+	n := len(values)
+	if n%2 == 1 {
+		return values[n/2]
+	}
+	return (values[n/2-1] + values[n/2]) / 2
+}
+
+// robustZ scores x against history with the median and the median absolute
+// deviation. It reports false when the history has no spread to score against.
+func robustZ(history []float64, x float64) (score, med, mad float64, ok bool) {
+	med = median(slices.Clone(history))
+	deviations := make([]float64, len(history))
+	for i, v := range history {
+		deviations[i] = math.Abs(v - med)
+	}
+	mad = median(deviations)
+	if mad == 0 {
+		return 0, med, mad, false
+	}
+	return madScale * (x - med) / mad, med, mad, true
+}
+
+// scoreDailyData returns the fields of d whose robust z-score against history
+// exceeds threshold
+func scoreDailyData(history []DailyData, d DailyData, threshold float64) []FieldScore {
+	var flagged []FieldScore
+	values := d.Metrics()
+	for _, field := range houseMetrics {
+		series := make([]float64, len(history))
+		for i, h := range history {
+			series[i], _ = h.Metric(field)
+		}
+		score, med, mad, ok := robustZ(series, values[field])
+		if ok && math.Abs(score) > threshold {
+			flagged = append(flagged, FieldScore{
+				Field:  field,
+				Value:  values[field],
+				Median: med,
+				MAD:    mad,
+				Score:  round2(score),
+			})
+		}
+	}
+	return flagged
+}
+
+// checkAnomaly scores a record about to be stored against the records of the
+// same granularity in the preceding window. It returns nil when the record
+// is not new, there is not enough history or nothing stands out.
+func checkAnomaly(ctx context.Context, region, day string, data DailyHouseResp) (*Anomaly, error) {
+	cfg := appConfig.AnomalyConfig
+	origin := writeOriginFrom(ctx)
+	if !cfg.Enabled || anomalyModeFrom(ctx) == anomalySkip || origin.Source == sourceWriteBack {
+		return nil, nil
+	}
+
+	existing, found, err := GetHouseData(ctx, day, region)
+	if err != nil {
+		return nil, err
+	}
+	if found && existing.DailyData == data.DailyData {
+		return nil, nil
+	}
+
+	t, err := parseDay(day)
+	if err != nil {
+		return nil, err
+	}
+	days, err := GetHouseDaysInRange(ctx, t.AddDate(0, 0, -cfg.Window), t.Add(-time.Second), region)
+	if err != nil {
+		return nil, err
+	}
+	var history []DailyData
+	for _, d := range days {
+		if len(d) != len(day) {
+			continue
+		}
+		record, found, err := GetHouseData(ctx, d, region)
+		if err != nil {
+			return nil, err
+		}
+		if found {
+			history = append(history, record.DailyData)
+		}
+	}
+	if len(history) < cfg.MinHistory {
+		return nil, nil
+	}
+
+	fields := scoreDailyData(history, data.DailyData, cfg.Threshold)
+	if len(fields) == 0 {
+		return nil, nil
+	}
+	return &Anomaly{
+		Region:      region,
+		Day:         day,
+		Fields:      fields,
+		History:     len(history),
+		Threshold:   cfg.Threshold,
+		Quarantined: cfg.Strict && anomalyModeFrom(ctx) == anomalyCheck,
+		DetectedAt:  time.Now(),
+		Caller:      origin.Caller,
+		Source:      origin.Source,
+	}, nil
+}
+
+// recordAnomaly appends anomaly to the region's anomalies list, keeping the
+// latest log_size, and, when it is quarantined, stores data in quarantine
+func recordAnomaly(ctx context.Context, anomaly *Anomaly, data DailyHouseResp) error {
+	s := storageFor(ctx)
+	if anomaly.Quarantined {
+		t, err := parseDay(anomaly.Day)
+		if err != nil {
+			return err
+		}
+		record := QuarantinedRecord{Data: data, Anomaly: *anomaly, Status: quarantinePending, UpdatedAt: anomaly.DetectedAt}
+		if err := storeQuarantinedRecord(ctx, record); err != nil {
+			return err
+		}
+		if err := s.ZAdd(ctx, formatQuarantineDaysKey(anomaly.Region), float64(t.Unix()), anomaly.Day); err != nil {
+			log.Logger.Error().Err(err).Str("day", anomaly.Day).Msg("Failed to add day to quarantine set")
+			return err
+		}
+	}
+
+	jsonData, err := json.Marshal(anomaly)
+	if err != nil {
+		log.Logger.Error().Err(err).Msg("Failed to marshal anomaly")
+		return err
+	}
+	key := formatAnomaliesKey(anomaly.Region)
+	n, err := s.RPush(ctx, key, jsonData)
+	if err != nil {
+		log.Logger.Error().Err(err).Str("key", key).Msg("Failed to store anomaly")
+		return err
+	}
+	if size := int64(appConfig.AnomalyConfig.LogSize); n > size {
+		if err := s.LTrim(ctx, key, -size, -1); err != nil {
+			log.Logger.Error().Err(err).Str("key", key).Msg("Failed to trim anomalies")
+			return err
+		}
+	}
+
+	anomaliesTotal.WithLabelValues(anomaly.Region, strconv.FormatBool(anomaly.Quarantined)).Inc()
+	log.Logger.Warn().Str("region", anomaly.Region).Str("day", anomaly.Day).Bool("quarantined", anomaly.Quarantined).Interface("fields", anomaly.Fields).Msg("Anomalous house data")
+	return nil
+}
+
+func storeQuarantinedRecord(ctx context.Context, record QuarantinedRecord) error {
+	jsonData, err := json.Marshal(record)
+	if err != nil {
+		log.Logger.Error().Err(err).Msg("Failed to marshal quarantined record")
+		return err
+	}
+	key := formatQuarantineKey(record.Anomaly.Region, record.Data.Day)
+	if err := storageFor(ctx).Set(ctx, key, jsonData); err != nil {
+		log.Logger.Error().Err(err).Str("key", key).Msg("Failed to store quarantined record")
+		return err
+	}
+	return nil
+}
+
+// GetQuarantinedRecord returns the quarantined record of a day
+func GetQuarantinedRecord(ctx context.Context, region, day string) (QuarantinedRecord, bool, error) {
+	var record QuarantinedRecord
+
+	key := formatQuarantineKey(region, day)
+	jsonData, found, err := storageFor(ctx).Get(ctx, key)
+	if err != nil {
+		log.Logger.Error().Err(err).Str("key", key).Msg("Failed to get quarantined record")
+		return record, false, err
+	} else if !found {
+		return record, false, nil
+	}
+
+	if err := json.Unmarshal([]byte(jsonData), &record); err != nil {
+		log.Logger.Error().Err(err).Str("key", key).Msg("Failed to unmarshal quarantined record")
+		return record, false, err
+	}
+	return record, true, nil
+}
+
+// GetAnomalies returns the anomalies flagged for a region, oldest first
+func GetAnomalies(ctx context.Context, region string) ([]Anomaly, error) {
+	key := formatAnomaliesKey(region)
+	result, err := storageFor(ctx).LRange(ctx, key, 0, -1)
+	if err != nil {
+		log.Logger.Error().Err(err).Str("key", key).Msg("Failed to get anomalies")
+		return nil, err
+	}
+	anomalies := make([]Anomaly, 0, len(result))
+	for _, item := range result {
+		var anomaly Anomaly
+		if err := json.Unmarshal([]byte(item), &anomaly); err != nil {
+			log.Logger.Error().Err(err).Str("key", key).Msg("Failed to unmarshal anomaly")
+			continue
+		}
+		anomalies = append(anomalies, anomaly)
+	}
+	return anomalies, nil
+}
+
+// respondQuarantined answers a write whose record was quarantined with 202:
+// it was received but is not served until an admin releases it
+func respondQuarantined(c *gin.Context, err error) {
+	var qerr *QuarantinedError
+	if !errors.As(err, &qerr) {
+		c.JSON(http.StatusAccepted, gin.H{"msg": "record quarantined"})
+		return
+	}
+	c.JSON(http.StatusAccepted, gin.H{"msg": "record quarantined", "anomaly": qerr.Anomaly})
+}
+
+// getAnomalies lists the anomalies of the request region, newest first,
+// optionally limited to days between from and to (YYYY-MM-DD)
+func getAnomalies(c *gin.Context) {
+	region := requestRegion(c)
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "100"))
+	if err != nil || limit <= 0 {
+		respondValidationError(c, []FieldError{{Field: "limit", Rule: "gt", Message: "must be a positive integer"}})
+		return
+	}
+	fromParam, toParam := c.Query("from"), c.Query("to")
+	var errs []FieldError
+	if fromParam != "" {
+		errs = append(errs, checkDate("from", fromParam, dayLayout)...)
+	}
+	if toParam != "" {
+		errs = append(errs, checkDate("to", toParam, dayLayout)...)
+	}
+	if len(errs) > 0 {
+		respondValidationError(c, errs)
+		return
+	}
+
+	anomalies, err := GetAnomalies(ctx, region)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get anomalies"})
+		return
+	}
+	result := []Anomaly{}
+	for i := len(anomalies) - 1; i >= 0 && len(result) < limit; i-- {
+		// Days compare as strings; hourly days sort within their day
+		day := anomalies[i].Day
+		if (fromParam != "" && day < fromParam) || (toParam != "" && day[:len(dayLayout)] > toParam) {
+			continue
+		}
+		result = append(result, anomalies[i])
+	}
+	c.JSON(http.StatusOK, gin.H{"region": region, "count": len(result), "anomalies": result})
+}
+
+// listQuarantine lists the quarantined records of a region with the given
+// status (default pending; "all" for every status)
+func listQuarantine(c *gin.Context) {
+	region := c.DefaultQuery("region", beijingKey)
+	status := c.DefaultQuery("status", quarantinePending)
+
+	days, err := storageFor(ctx).ZRangeByScore(ctx, formatQuarantineDaysKey(region), 0, math.MaxInt64)
+	if err != nil {
+		log.Logger.Error().Err(err).Str("region", region).Msg("Failed to get quarantined days")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get quarantined records"})
+		return
+	}
+	records := []QuarantinedRecord{}
+	for _, day := range days {
+		record, found, err := GetQuarantinedRecord(ctx, region, day)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get quarantined records"})
+			return
+		}
+		if found && (status == "all" || record.Status == status) {
+			records = append(records, record)
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{"region": region, "status": status, "records": records})
+}
+
+// releaseQuarantine stores a quarantined record as the served value, or
+// discards it when discard is set
+func releaseQuarantine(c *gin.Context) {
+	var req struct {
+		Region  string `json:"region" binding:"required"`
+		Day     string `json:"day" binding:"required"`
+		Discard bool   `json:"discard"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondValidationError(c, bindingFieldErrors(err))
+		return
+	}
+
+	wctx := requestContext(c)
+	record, found, err := GetQuarantinedRecord(wctx, req.Region, req.Day)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get quarantined record"})
+		return
+	}
+	if !found {
+		c.JSON(http.StatusNotFound, gin.H{"msg": "quarantined record not found"})
+		return
+	}
+	if record.Status != quarantinePending {
+		c.JSON(http.StatusConflict, gin.H{"error": "record already " + record.Status})
+		return
+	}
+
+	record.Status = quarantineDiscarded
+	if !req.Discard {
+		if err := StoreHouseData(withAnomalyMode(wctx, anomalySkip), req.Day, record.Data, req.Region); err != nil {
+			log.Logger.Error().Err(err).Str("day", req.Day).Str("region", req.Region).Msg("Failed to store released house data")
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to store house data"})
+			return
+		}
+		record.Status = quarantineReleased
+	}
+	record.UpdatedAt = time.Now()
+	if err := storeQuarantinedRecord(wctx, record); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to update quarantined record"})
+		return
+	}
+
+	log.Logger.Info().Str("day", req.Day).Str("region", req.Region).Str("status", record.Status).Msg("Quarantined record handled")
+	c.JSON(http.StatusOK, record)
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestScoreDailyData(t *testing.T) {
+	var history []DailyData
+	for i := 0; i < 20; i++ {
+		history = append(history, DailyData{TotalCount: float64(100 + i%5), TotalArea: float64(9000 + 50*(i%7))})
+	}
+
+	if flagged := scoreDailyData(history, DailyData{TotalCount: 103, TotalArea: 9100}, 3.5); len(flagged) != 0 {
+		t.Errorf("expected no anomaly, got %+v", flagged)
+	}
+
+	flagged := scoreDailyData(history, DailyData{TotalCount: 103, TotalArea: 910000}, 3.5)
+	if len(flagged) != 1 || flagged[0].Field != "total_area" || flagged[0].Score <= 3.5 {
+		t.Errorf("expected total_area anomaly, got %+v", flagged)
+	}
+}
+
+func TestStrictModeQuarantinesAnomalies(t *testing.T) {
+	EnableMockRedisForTesting()
+	saved := appConfig.AnomalyConfig
+	defer func() { appConfig.AnomalyConfig = saved }()
+	appConfig.AnomalyConfig.Strict = true
+
+	for i := 1; i <= 12; i++ {
+		day := fmt.Sprintf("2025-03-%02d", i)
+		data := DailyHouseResp{Day: day, DailyData: DailyData{TotalCount: float64(100 + i%4), TotalArea: float64(9000 + 40*(i%5))}}
+		if err := StoreHouseData(ctx, day, data, beijingKey); err != nil {
+			t.Fatalf("store %s: %v", day, err)
+		}
+	}
+
+	outlier := DailyHouseResp{Day: "2025-03-13", DailyData: DailyData{TotalCount: 101, TotalArea: 900000}}
+	err := StoreHouseData(ctx, outlier.Day, outlier, beijingKey)
+	if !errors.Is(err, ErrQuarantined) {
+		t.Fatalf("expected ErrQuarantined, got %v", err)
+	}
+	if _, found, _ := GetHouseData(ctx, outlier.Day, beijingKey); found {
+		t.Error("quarantined record must not be served")
+	}
+	record, found, err := GetQuarantinedRecord(ctx, beijingKey, outlier.Day)
+	if err != nil || !found || record.Status != quarantinePending {
+		t.Fatalf("quarantined record not stored: %+v %v", record, err)
+	}
+	anomalies, _ := GetAnomalies(ctx, beijingKey)
+	if len(anomalies) != 1 || !anomalies[0].Quarantined {
+		t.Errorf("expected one quarantined anomaly, got %+v", anomalies)
+	}
+
+	// Forced writes are flagged but stored
+	if err := StoreHouseData(withAnomalyMode(ctx, anomalyFlagOnly), outlier.Day, outlier, beijingKey); err != nil {
+		t.Fatal(err)
+	}
+	if _, found, _ := GetHouseData(ctx, outlier.Day, beijingKey); !found {
+		t.Error("forced record should be served")
+	}
+}
+
+func TestAnomaliesBounded(t *testing.T) {
+	useTestStorage(t)
+	saved := appConfig.AnomalyConfig
+	defer func() { appConfig.AnomalyConfig = saved }()
+	appConfig.AnomalyConfig.LogSize = 2
+
+	for i := 1; i <= 3; i++ {
+		anomaly := &Anomaly{Region: beijingKey, Day: fmt.Sprintf("2025-03-%02d", i), DetectedAt: time.Now()}
+		if err := recordAnomaly(ctx, anomaly, DailyHouseResp{Day: anomaly.Day}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	anomalies, err := GetAnomalies(ctx, beijingKey)
+	if err != nil || len(anomalies) != 2 {
+		t.Fatalf("want the latest 2 anomalies, got %+v (%v)", anomalies, err)
+	}
+	for _, a := range anomalies {
+		if a.Day == "2025-03-01" {
+			t.Errorf("the oldest anomaly should be dropped, got %+v", anomalies)
+		}
+	}
+}
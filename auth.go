@@ -0,0 +1,326 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+)
+
+// API key scopes
+const (
+	ScopeRead   = "read"   // GET endpoints
+	ScopeIngest = "ingest" // add endpoints
+	ScopeForce  = "force"  // overwriting existing records
+	ScopeAdmin  = "admin"  // everything, including key management
+)
+
+// Redis key prefixes and structures for API keys
+const (
+	APIKeyPrefix  = "apikey"  // Prefix for API key records, keyed by key hash
+	APIKeysSetKey = "apikeys" // Sorted set of key hashes by creation time
+
+	apiKeyTokenPrefix = "hk_"     // Prefix of generated keys
+	apiKeyCtxKey      = "api_key" // gin context key of the authenticated APIKey
+)
+
+var errInvalidAPIKey = errors.New("invalid or revoked API key")
+
+// APIKey is a stored API key. Only the SHA-256 hash of the key is persisted;
+// the ID is a prefix of that hash.
+type APIKey struct {
+	ID        string     `json:"id"`
+	Name      string     `json:"name"`
+	Scopes    []string   `json:"scopes"`
+	CreatedAt time.Time  `json:"created_at"`
+	Revoked   bool       `json:"revoked"`
+	RevokedAt *time.Time `json:"revoked_at,omitempty"`
+}
+
+// HasScope reports whether the key grants scope; admin grants every scope
+func (k *APIKey) HasScope(scope string) bool {
+	return slices.Contains(k.Scopes, ScopeAdmin) || slices.Contains(k.Scopes, scope)
+}
+
+// apiKeyCache keeps validated keys by hash so ingestion keeps working while
+// storage is unreachable
+var apiKeyCache sync.Map
+
+func hashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+func formatAPIKeyKey(hash string) string {
+	return fmt.Sprintf("%s:%s", APIKeyPrefix, hash)
+}
+
+func validScope(scope string) bool {
+	switch scope {
+	case ScopeRead, ScopeIngest, ScopeForce, ScopeAdmin:
+		return true
+	}
+	return false
+}
+
+// CreateAPIKey generates a new key with the given scopes and stores its hash.
+// The plaintext key is returned only here.
+func CreateAPIKey(name string, scopes []string) (string, APIKey, error) {
+	buf := make([]byte, 24)
+	if _, err := rand.Read(buf); err != nil {
+		return "", APIKey{}, err
+	}
+	token := apiKeyTokenPrefix + hex.EncodeToString(buf)
+	apiKey, err := storeAPIKey(token, name, scopes)
+	return token, apiKey, err
+}
+
+func storeAPIKey(token, name string, scopes []string) (APIKey, error) {
+	hash := hashAPIKey(token)
+	apiKey := APIKey{
+		ID:        hash[:16],
+		Name:      name,
+		Scopes:    scopes,
+		CreatedAt: time.Now(),
+	}
+	if err := saveAPIKey(hash, apiKey); err != nil {
+		return apiKey, err
+	}
+	if err := storage.ZAdd(ctx, APIKeysSetKey, float64(apiKey.CreatedAt.Unix()), hash); err != nil {
+		return apiKey, err
+	}
+	return apiKey, nil
+}
+
+func saveAPIKey(hash string, apiKey APIKey) error {
+	jsonData, err := json.Marshal(apiKey)
+	if err != nil {
+		return err
+	}
+	if err := storage.Set(ctx, formatAPIKeyKey(hash), jsonData); err != nil {
+		return err
+	}
+	apiKeyCache.Store(hash, apiKey)
+	return nil
+}
+
+// lookupAPIKey returns the active key matching token
+func lookupAPIKey(token string) (APIKey, error) {
+	hash := hashAPIKey(token)
+
+	jsonData, found, err := storage.Get(ctx, formatAPIKeyKey(hash))
+	if err != nil {
+		// Fall back to keys validated earlier
+		v, ok := apiKeyCache.Load(hash)
+		if !ok {
+			return APIKey{}, err
+		}
+		if apiKey := v.(APIKey); !apiKey.Revoked {
+			return apiKey, nil
+		}
+		return APIKey{}, errInvalidAPIKey
+	}
+	if !found {
+		return APIKey{}, errInvalidAPIKey
+	}
+
+	var apiKey APIKey
+	if err := json.Unmarshal([]byte(jsonData), &apiKey); err != nil {
+		return APIKey{}, err
+	}
+	apiKeyCache.Store(hash, apiKey)
+	if apiKey.Revoked {
+		return APIKey{}, errInvalidAPIKey
+	}
+	return apiKey, nil
+}
+
+// listAPIKeys returns every stored key by creation time, with their hashes
+func listAPIKeys() ([]string, []APIKey, error) {
+	hashes, err := storage.ZRangeByScore(ctx, APIKeysSetKey, math.Inf(-1), math.Inf(1))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var found []string
+	apiKeys := []APIKey{}
+	for _, hash := range hashes {
+		jsonData, ok, err := storage.Get(ctx, formatAPIKeyKey(hash))
+		if err != nil {
+			return nil, nil, err
+		}
+		if !ok {
+			continue
+		}
+		var apiKey APIKey
+		if err := json.Unmarshal([]byte(jsonData), &apiKey); err != nil {
+			log.Logger.Error().Err(err).Str("hash", hash).Msg("Failed to unmarshal API key")
+			continue
+		}
+		found = append(found, hash)
+		apiKeys = append(apiKeys, apiKey)
+	}
+	return found, apiKeys, nil
+}
+
+// ensureBootstrapKey stores the admin key from the configuration unless it
+// was stored before, so the first keys can be created through the admin API.
+// Once revoked it stays revoked. While storage is unreachable the key is only
+// cached, like the keys validated earlier.
+func ensureBootstrapKey(token string) {
+	if token == "" {
+		return
+	}
+	hash := hashAPIKey(token)
+	_, found, err := storage.Get(ctx, formatAPIKeyKey(hash))
+	if err == nil && found {
+		return
+	}
+	if err == nil {
+		if _, err = storeAPIKey(token, "bootstrap", []string{ScopeAdmin}); err == nil {
+			return
+		}
+	}
+	log.Logger.Error().Err(err).Msg("Failed to store bootstrap API key")
+	apiKeyCache.Store(hash, APIKey{ID: hash[:16], Name: "bootstrap", Scopes: []string{ScopeAdmin}, CreatedAt: time.Now()})
+}
+
+// authorize authenticates the API key in the Authorization header
+// ("Bearer <key>") and requires the read scope for GET requests and the ingest
+// scope for everything else. Reads need no key unless auth.require_read_key is set.
+func authorize() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		scope := ScopeIngest
+		if c.Request.Method == http.MethodGet || c.Request.Method == http.MethodHead {
+			scope = ScopeRead
+		}
+
+		token, hasToken := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+		if !hasToken || token == "" {
+			if scope == ScopeRead && !appConfig.AuthConfig.RequireReadKey {
+				c.Next()
+				return
+			}
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "missing API key"})
+			return
+		}
+
+		apiKey, err := lookupAPIKey(token)
+		if err != nil {
+			log.Logger.Warn().Err(err).Str("path", c.Request.URL.Path).Msg("API key rejected")
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": errInvalidAPIKey.Error()})
+			return
+		}
+		c.Set(apiKeyCtxKey, apiKey)
+
+		if !apiKey.HasScope(scope) && !(scope == ScopeRead && !appConfig.AuthConfig.RequireReadKey) {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "API key lacks scope " + scope})
+			return
+		}
+		c.Next()
+	}
+}
+
+// requireScope rejects requests whose API key (set by authorize) lacks scope
+func requireScope(scope string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if _, ok := c.Get(apiKeyCtxKey); !ok {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "missing API key"})
+			return
+		}
+		if !hasScope(c, scope) {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "API key lacks scope " + scope})
+			return
+		}
+		c.Next()
+	}
+}
+
+// hasScope reports whether the request was authenticated with a key granting scope
+func hasScope(c *gin.Context, scope string) bool {
+	v, ok := c.Get(apiKeyCtxKey)
+	if !ok {
+		return false
+	}
+	apiKey := v.(APIKey)
+	return apiKey.HasScope(scope)
+}
+
+// createAPIKey creates a key: {"name": "...", "scopes": ["read", "ingest"]}
+func createAPIKey(c *gin.Context) {
+	var req struct {
+		Name   string   `json:"name" binding:"required"`
+		Scopes []string `json:"scopes" binding:"required,min=1"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	for _, scope := range req.Scopes {
+		if !validScope(scope) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid scope " + scope + " (must be read, ingest, force or admin)"})
+			return
+		}
+	}
+
+	token, apiKey, err := CreateAPIKey(req.Name, req.Scopes)
+	if err != nil {
+		log.Logger.Error().Err(err).Str("name", req.Name).Msg("Failed to create API key")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create API key"})
+		return
+	}
+
+	log.Logger.Info().Str("id", apiKey.ID).Str("name", apiKey.Name).Strs("scopes", apiKey.Scopes).Msg("API key created")
+	c.JSON(http.StatusOK, gin.H{"key": token, "api_key": apiKey})
+}
+
+// listAPIKeysHandler lists every key, including revoked ones
+func listAPIKeysHandler(c *gin.Context) {
+	_, apiKeys, err := listAPIKeys()
+	if err != nil {
+		log.Logger.Error().Err(err).Msg("Failed to list API keys")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to list API keys"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": apiKeys})
+}
+
+// revokeAPIKey revokes the key with the given ID
+func revokeAPIKey(c *gin.Context) {
+	id := c.Param("id")
+	hashes, apiKeys, err := listAPIKeys()
+	if err != nil {
+		log.Logger.Error().Err(err).Msg("Failed to list API keys")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to revoke API key"})
+		return
+	}
+
+	for i, apiKey := range apiKeys {
+		if apiKey.ID != id {
+			continue
+		}
+		now := time.Now()
+		apiKey.Revoked = true
+		apiKey.RevokedAt = &now
+		if err := saveAPIKey(hashes[i], apiKey); err != nil {
+			log.Logger.Error().Err(err).Str("id", id).Msg("Failed to revoke API key")
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to revoke API key"})
+			return
+		}
+		log.Logger.Info().Str("id", id).Msg("API key revoked")
+		c.JSON(http.StatusOK, apiKey)
+		return
+	}
+	c.JSON(http.StatusNotFound, gin.H{"msg": "API key not found"})
+}
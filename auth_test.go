@@ -0,0 +1,122 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func newAuthRouter() *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	ok := func(c *gin.Context) { c.Status(http.StatusOK) }
+	router.GET("/read", authorize(), ok)
+	router.POST("/add", authorize(), ok)
+	router.POST("/force", authorize(), requireScope(ScopeForce), ok)
+	return router
+}
+
+func authStatus(router *gin.Engine, method, path, token string) int {
+	req := httptest.NewRequest(method, path, nil)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w.Code
+}
+
+func TestAuthorizeScopes(t *testing.T) {
+	useTestStorage(t)
+	t.Cleanup(apiKeyCache.Clear)
+	router := newAuthRouter()
+	reader, _, err := CreateAPIKey("reader", []string{ScopeRead})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ingester, _, _ := CreateAPIKey("ingester", []string{ScopeIngest})
+	forcer, _, _ := CreateAPIKey("forcer", []string{ScopeIngest, ScopeForce})
+	admin, _, _ := CreateAPIKey("admin", []string{ScopeAdmin})
+
+	cases := []struct {
+		method, path, token string
+		want                int
+	}{
+		{http.MethodGet, "/read", "", http.StatusOK},
+		{http.MethodPost, "/add", "", http.StatusUnauthorized},
+		{http.MethodPost, "/add", "hk_unknown", http.StatusUnauthorized},
+		{http.MethodPost, "/add", reader, http.StatusForbidden},
+		{http.MethodPost, "/add", ingester, http.StatusOK},
+		{http.MethodPost, "/force", ingester, http.StatusForbidden},
+		{http.MethodPost, "/force", forcer, http.StatusOK},
+		{http.MethodPost, "/force", admin, http.StatusOK},
+		{http.MethodGet, "/read", ingester, http.StatusOK},
+	}
+	for _, tc := range cases {
+		if got := authStatus(router, tc.method, tc.path, tc.token); got != tc.want {
+			t.Errorf("%s %s with %q: got %d, want %d", tc.method, tc.path, tc.token, got, tc.want)
+		}
+	}
+
+	// Reads need a key granting read once require_read_key is set
+	saved := appConfig.AuthConfig.RequireReadKey
+	appConfig.AuthConfig.RequireReadKey = true
+	defer func() { appConfig.AuthConfig.RequireReadKey = saved }()
+	for token, want := range map[string]int{"": http.StatusUnauthorized, ingester: http.StatusForbidden, reader: http.StatusOK, admin: http.StatusOK} {
+		if got := authStatus(router, http.MethodGet, "/read", token); got != want {
+			t.Errorf("GET /read with %q and require_read_key: got %d, want %d", token, got, want)
+		}
+	}
+}
+
+func TestAPIKeyHashedAndRevoked(t *testing.T) {
+	useTestStorage(t)
+	t.Cleanup(apiKeyCache.Clear)
+	router := newAuthRouter()
+	token, apiKey, err := CreateAPIKey("scraper", []string{ScopeIngest})
+	if err != nil {
+		t.Fatal(err)
+	}
+	hash := hashAPIKey(token)
+	if apiKey.ID != hash[:16] {
+		t.Errorf("the key ID should be a prefix of its hash, got %s", apiKey.ID)
+	}
+	if _, found, _ := storage.Get(ctx, formatAPIKeyKey(token)); found {
+		t.Error("the plaintext key should not be stored")
+	}
+
+	apiKey.Revoked = true
+	if err := saveAPIKey(hash, apiKey); err != nil {
+		t.Fatal(err)
+	}
+	if got := authStatus(router, http.MethodPost, "/add", token); got != http.StatusUnauthorized {
+		t.Errorf("revoked key: got %d, want 401", got)
+	}
+}
+
+func TestBootstrapKeyRevoked(t *testing.T) {
+	useTestStorage(t)
+	t.Cleanup(apiKeyCache.Clear)
+	router := newAuthRouter()
+	ensureBootstrapKey("boot")
+	if got := authStatus(router, http.MethodPost, "/force", "boot"); got != http.StatusOK {
+		t.Fatalf("bootstrap key: got %d, want 200", got)
+	}
+
+	hash := hashAPIKey("boot")
+	apiKey, err := lookupAPIKey("boot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	apiKey.Revoked = true
+	if err := saveAPIKey(hash, apiKey); err != nil {
+		t.Fatal(err)
+	}
+	// Still configured after a restart, but revoked
+	ensureBootstrapKey("boot")
+	if got := authStatus(router, http.MethodPost, "/add", "boot"); got != http.StatusUnauthorized {
+		t.Errorf("revoked bootstrap key: got %d, want 401", got)
+	}
+}
@@ -0,0 +1,308 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// Bolt bucket names
+var (
+	boltValuesBucket     = []byte("values")      // key -> value
+	boltSortedSetsBucket = []byte("sorted_sets") // one nested bucket per sorted set
+	boltScoresBucket     = []byte("scores")      // member -> encoded score
+	boltIndexBucket      = []byte("index")       // encoded score + member -> nil
+	boltListsBucket      = []byte("lists")       // one nested bucket per list, sequence -> value
+)
+
+// BoltStorage implements Storage with an embedded BoltDB file, for deployments
+// without a Redis server
+type BoltStorage struct {
+	db *bolt.DB
+}
+
+// OpenBoltStorage opens (or creates) the bolt database file at path
+func OpenBoltStorage(path string) (*BoltStorage, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, err
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{boltValuesBucket, boltSortedSetsBucket, boltListsBucket} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &BoltStorage{db: db}, nil
+}
+
+// Get retrieves a value by key
+func (s *BoltStorage) Get(ctx context.Context, key string) (string, bool, error) {
+	var value string
+	var found bool
+	err := s.db.View(func(tx *bolt.Tx) error {
+		if v := tx.Bucket(boltValuesBucket).Get([]byte(key)); v != nil {
+			value, found = string(v), true
+		}
+		return nil
+	})
+	return value, found, err
+}
+
+// MGet retrieves the values of several keys
+func (s *BoltStorage) MGet(ctx context.Context, keys ...string) (map[string]string, error) {
+	values := make(map[string]string, len(keys))
+	err := s.db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(boltValuesBucket)
+		for _, key := range keys {
+			if v := bucket.Get([]byte(key)); v != nil {
+				values[key] = string(v)
+			}
+		}
+		return nil
+	})
+	return values, err
+}
+
+// Set stores a value by key
+func (s *BoltStorage) Set(ctx context.Context, key string, value []byte) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltValuesBucket).Put([]byte(key), value)
+	})
+}
+
+// Del removes values by key
+func (s *BoltStorage) Del(ctx context.Context, keys ...string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		values := tx.Bucket(boltValuesBucket)
+		for _, key := range keys {
+			if err := values.Delete([]byte(key)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// ZAdd adds a member to a sorted set, replacing its previous score
+func (s *BoltStorage) ZAdd(ctx context.Context, key string, score float64, member string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		set, err := tx.Bucket(boltSortedSetsBucket).CreateBucketIfNotExists([]byte(key))
+		if err != nil {
+			return err
+		}
+		scores, err := set.CreateBucketIfNotExists(boltScoresBucket)
+		if err != nil {
+			return err
+		}
+		index, err := set.CreateBucketIfNotExists(boltIndexBucket)
+		if err != nil {
+			return err
+		}
+
+		// Drop the index entry of the previous score
+		if old := scores.Get([]byte(member)); old != nil {
+			if err := index.Delete(append(append([]byte{}, old...), member...)); err != nil {
+				return err
+			}
+		}
+
+		encoded := encodeScore(score)
+		if err := scores.Put([]byte(member), encoded); err != nil {
+			return err
+		}
+		return index.Put(append(encoded, member...), nil)
+	})
+}
+
+// ZRem removes a member from a sorted set
+func (s *BoltStorage) ZRem(ctx context.Context, key string, member string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		set := tx.Bucket(boltSortedSetsBucket).Bucket([]byte(key))
+		if set == nil {
+			return nil
+		}
+		scores := set.Bucket(boltScoresBucket)
+		old := scores.Get([]byte(member))
+		if old == nil {
+			return nil
+		}
+		if err := set.Bucket(boltIndexBucket).Delete(append(append([]byte{}, old...), member...)); err != nil {
+			return err
+		}
+		return scores.Delete([]byte(member))
+	})
+}
+
+// ZRangeByScore retrieves members of a sorted set by score
+func (s *BoltStorage) ZRangeByScore(ctx context.Context, key string, min, max float64) ([]string, error) {
+	result := []string{}
+	err := s.db.View(func(tx *bolt.Tx) error {
+		set := tx.Bucket(boltSortedSetsBucket).Bucket([]byte(key))
+		if set == nil {
+			return nil
+		}
+		index := set.Bucket(boltIndexBucket)
+		if index == nil {
+			return nil
+		}
+
+		// Index keys sort by score, then member, like a Redis sorted set
+		c := index.Cursor()
+		for k, _ := c.Seek(encodeScore(min)); k != nil; k, _ = c.Next() {
+			if decodeScore(k[:8]) > max {
+				break
+			}
+			result = append(result, string(k[8:]))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("range %s: %w", key, err)
+	}
+	return result, nil
+}
+
+// RPush appends a value to a list
+func (s *BoltStorage) RPush(ctx context.Context, key string, value []byte) (int64, error) {
+	var n int64
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		list, err := tx.Bucket(boltListsBucket).CreateBucketIfNotExists([]byte(key))
+		if err != nil {
+			return err
+		}
+		seq, err := list.NextSequence()
+		if err != nil {
+			return err
+		}
+		if err := list.Put(encodeSeq(seq), value); err != nil {
+			return err
+		}
+		_, n = listSpan(list)
+		return nil
+	})
+	return n, err
+}
+
+// LRange retrieves a range of list elements
+func (s *BoltStorage) LRange(ctx context.Context, key string, start, stop int64) ([]string, error) {
+	values := []string{}
+	err := s.db.View(func(tx *bolt.Tx) error {
+		list := tx.Bucket(boltListsBucket).Bucket([]byte(key))
+		if list == nil {
+			return nil
+		}
+		first, n := listSpan(list)
+		from, to := listBounds(n, start, stop)
+		c := list.Cursor()
+		for k, v := c.Seek(encodeSeq(first + uint64(from))); k != nil && int64(len(values)) < to-from; k, v = c.Next() {
+			values = append(values, string(v))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("range %s: %w", key, err)
+	}
+	return values, nil
+}
+
+// LTrim keeps the list elements between start and stop
+func (s *BoltStorage) LTrim(ctx context.Context, key string, start, stop int64) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		list := tx.Bucket(boltListsBucket).Bucket([]byte(key))
+		if list == nil {
+			return nil
+		}
+		first, n := listSpan(list)
+		from, to := listBounds(n, start, stop)
+		c := list.Cursor()
+		for k, _ := c.First(); k != nil && decodeSeq(k) < first+uint64(from); k, _ = c.First() {
+			if err := c.Delete(); err != nil {
+				return err
+			}
+		}
+		for k, _ := c.Last(); k != nil && decodeSeq(k) >= first+uint64(to); k, _ = c.Last() {
+			if err := c.Delete(); err != nil {
+				return err
+			}
+		}
+		// Keep the sequence keys contiguous after dropping the tail
+		if to > from && to < n {
+			return list.SetSequence(first + uint64(to) - 1)
+		}
+		return nil
+	})
+}
+
+// listSpan returns the first sequence key of a list and its length. Lists
+// keep their keys contiguous (LTrim rewinds the sequence when it drops the
+// tail), so neither needs a walk over the list.
+func listSpan(list *bolt.Bucket) (uint64, int64) {
+	c := list.Cursor()
+	first, _ := c.First()
+	if first == nil {
+		return 0, 0
+	}
+	last, _ := c.Last()
+	return decodeSeq(first), int64(decodeSeq(last)-decodeSeq(first)) + 1
+}
+
+// encodeSeq encodes a list sequence number as a key sorting in numeric order
+func encodeSeq(seq uint64) []byte {
+	buf := make([]byte, 8)
+	binary.BigEndian.PutUint64(buf, seq)
+	return buf
+}
+
+func decodeSeq(key []byte) uint64 {
+	return binary.BigEndian.Uint64(key)
+}
+
+// Batch runs fn directly; bolt writes are local so there is no round trip to save
+func (s *BoltStorage) Batch(ctx context.Context, fn func(ctx context.Context) error) error {
+	return fn(context.WithValue(ctx, batchCtxKey{}, Storage(s)))
+}
+
+// Close closes the bolt database file
+func (s *BoltStorage) Close() error {
+	return s.db.Close()
+}
+
+// encodeScore encodes a float64 into 8 bytes whose byte order matches numeric order
+func encodeScore(score float64) []byte {
+	bits := math.Float64bits(score)
+	if score < 0 {
+		bits = ^bits
+	} else {
+		bits |= 1 << 63
+	}
+	buf := make([]byte, 8)
+	binary.BigEndian.PutUint64(buf, bits)
+	return buf
+}
+
+// decodeScore reverses encodeScore
+func decodeScore(buf []byte) float64 {
+	bits := binary.BigEndian.Uint64(buf)
+	if bits&(1<<63) != 0 {
+		bits &^= 1 << 63
+	} else {
+		bits = ^bits
+	}
+	return math.Float64frombits(bits)
+}
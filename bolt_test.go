@@ -0,0 +1,72 @@
+package main
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestBoltStorage(t *testing.T) {
+	db, err := OpenBoltStorage(filepath.Join(t.TempDir(), "house.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	if err := db.Set(ctx, "k", []byte("v")); err != nil {
+		t.Fatal(err)
+	}
+	if v, found, err := db.Get(ctx, "k"); err != nil || !found || v != "v" {
+		t.Fatalf("get: %q %v %v", v, found, err)
+	}
+	if _, found, _ := db.Get(ctx, "missing"); found {
+		t.Fatal("missing key found")
+	}
+
+	for member, score := range map[string]float64{"c": 3, "a": -1.5, "b": 2, "d": 10} {
+		if err := db.ZAdd(ctx, "set", score, member); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Re-adding moves the member to its new score
+	if err := db.ZAdd(ctx, "set", 0, "d"); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := db.ZRangeByScore(ctx, "set", -2, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"a", "d", "b", "c"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	for i, value := range []string{"x", "y", "z"} {
+		if n, err := db.RPush(ctx, "list", []byte(value)); err != nil || n != int64(i+1) {
+			t.Fatalf("rpush %s: length %d, %v", value, n, err)
+		}
+	}
+	if got, err := db.LRange(ctx, "list", -2, -1); err != nil || !reflect.DeepEqual(got, []string{"y", "z"}) {
+		t.Errorf("lrange: got %v, %v", got, err)
+	}
+
+	// Trimming either end keeps positions and lengths right for later pushes
+	if err := db.LTrim(ctx, "list", 0, 1); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := db.RPush(ctx, "list", []byte("w")); err != nil || n != 3 {
+		t.Fatalf("rpush after trimming the tail: length %d, %v", n, err)
+	}
+	if err := db.LTrim(ctx, "list", 1, -1); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := db.RPush(ctx, "list", []byte("v")); err != nil || n != 3 {
+		t.Fatalf("rpush after trimming the head: length %d, %v", n, err)
+	}
+	if got, err := db.LRange(ctx, "list", 1, 5); err != nil || !reflect.DeepEqual(got, []string{"w", "v"}) {
+		t.Errorf("lrange after trims: got %v, %v", got, err)
+	}
+	if got, err := db.LRange(ctx, "missing", 0, -1); err != nil || len(got) != 0 {
+		t.Errorf("lrange of a missing list: got %v, %v", got, err)
+	}
+}
@@ -0,0 +1,361 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	bulkMaxRows      = 10000    // Rows accepted per request
+	bulkBatchSize    = 200      // Rows written per pipeline
+	bulkMaxBodyBytes = 32 << 20 // 32 MB
+)
+
+// Bulk row statuses
+const (
+	rowAccepted    = "accepted"
+	rowSkipped     = "skipped"
+	rowQuarantined = "quarantined"
+	rowError       = "error"
+)
+
+// bulkCSVColumns are the CSV columns, in the order of DailyData
+var bulkCSVColumns = []string{"day", "total_count", "total_area", "house_count", "house_area", "house_price", "total_price"}
+
+// BulkRowResult reports what happened to one imported row
+type BulkRowResult struct {
+	Row    int          `json:"row"` // 1-based line (CSV, NDJSON) or element (JSON) number
+	Day    string       `json:"day,omitempty"`
+	Status string       `json:"status"`
+	Error  string       `json:"error,omitempty"`
+	Fields []FieldError `json:"fields,omitempty"` // set when the row fails validation
+}
+
+// BulkReport is the response of a bulk import
+type BulkReport struct {
+	Accepted    int             `json:"accepted"`
+	Skipped     int             `json:"skipped"`
+	Quarantined int             `json:"quarantined"`
+	Errors      int             `json:"errors"`
+	Rows        []BulkRowResult `json:"rows"`
+}
+
+// errTooManyRows stops parsing a body holding more than bulkMaxRows rows
+var errTooManyRows = fmt.Errorf("too many rows (max %d)", bulkMaxRows)
+
+type bulkRow struct {
+	row  int
+	data DailyHouseResp
+	err  error
+}
+
+// bulkImport imports many records of the daily dataset ds of region in one
+// request. The body is a JSON array, NDJSON or CSV (see bulkCSVColumns), chosen
+// by the format query parameter or the Content-Type. Days already stored are
+// skipped unless overwrite=true is given with a key holding the force scope.
+func bulkImport(c *gin.Context, region string, ds *Dataset) {
+	rows, err := parseBulkRows(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		log.Logger.Error().Err(err).Msg("Failed to parse bulk import")
+		return
+	}
+
+	overwrite := c.Query("overwrite") == "true"
+	if overwrite && !hasScope(c, ScopeForce) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "API key lacks scope " + ScopeForce})
+		return
+	}
+
+	wctx := requestContext(c)
+	results := make([]BulkRowResult, len(rows))
+	seen := make(map[string]bool)
+	var pending []int
+
+	// Check every row before writing anything
+	for i, r := range rows {
+		results[i] = BulkRowResult{Row: r.row, Day: r.data.Day, Status: rowError}
+		if r.err != nil {
+			results[i].Error = r.err.Error()
+			continue
+		}
+		day, errs := validateBulkRow(r.data, ds)
+		if len(errs) > 0 {
+			results[i].Error = "validation failed"
+			results[i].Fields = errs
+			continue
+		}
+		rows[i].data.Day, results[i].Day = day, day
+		if seen[day] {
+			results[i].Status = rowSkipped
+			results[i].Error = "duplicate day in request"
+			continue
+		}
+		seen[day] = true
+		pending = append(pending, i)
+	}
+
+	// Skip the days already stored, reading one batch of keys at a time
+	if !overwrite {
+		var remaining []int
+		for start := 0; start < len(pending); start += bulkBatchSize {
+			batch := pending[start:min(start+bulkBatchSize, len(pending))]
+			days := make([]string, len(batch))
+			for j, i := range batch {
+				days[j] = rows[i].data.Day
+			}
+			stored, err := storedHouseDays(wctx, region, days)
+			for _, i := range batch {
+				switch {
+				case err != nil:
+					results[i].Error = err.Error()
+				case stored[rows[i].data.Day]:
+					results[i].Status = rowSkipped
+					results[i].Error = "already stored"
+				default:
+					remaining = append(remaining, i)
+				}
+			}
+		}
+		pending = remaining
+	}
+
+	// Write the remaining rows through StoreHouseData, one pipeline per batch
+	for start := 0; start < len(pending); start += bulkBatchSize {
+		batch := pending[start:min(start+bulkBatchSize, len(pending))]
+		err := storage.Batch(deferAggregates(wctx), func(bctx context.Context) error {
+			for _, i := range batch {
+				data := rows[i].data
+				data.DailyData = ds.mapData(data.DailyData)
+				data.Districts = mapDistricts(data.Districts, ds.mapData)
+				rows[i].data = data
+				if err := StoreHouseData(bctx, data.Day, data, region); errors.Is(err, ErrQuarantined) {
+					results[i].Status = rowQuarantined
+					continue
+				} else if err != nil {
+					results[i].Error = err.Error()
+					continue
+				}
+				results[i].Status = rowAccepted
+			}
+			return nil
+		})
+		if err != nil {
+			log.Logger.Error().Err(err).Str("region", region).Msg("Failed to write bulk import batch")
+			for _, i := range batch {
+				results[i].Status = rowError
+				results[i].Error = err.Error()
+			}
+		}
+	}
+
+	// Aggregates are refreshed once per bucket after all batches are written
+	var accepted []string
+	for _, r := range results {
+		if r.Status == rowAccepted {
+			accepted = append(accepted, r.Day)
+		}
+	}
+	if err := refreshAggregatesForDays(wctx, region, accepted); err != nil {
+		log.Logger.Error().Err(err).Str("region", region).Msg("Failed to refresh house aggregates after bulk import")
+	}
+	var published []RecordEvent
+	for i, r := range results {
+		if r.Status != rowAccepted {
+			continue
+		}
+		if e, ok := newRecordEvent(region, ds.Name, r.Day, eventSourceBulk, rows[i].data); ok {
+			published = append(published, e)
+		}
+	}
+	publishRecords(published)
+
+	report := BulkReport{Rows: results}
+	for _, r := range results {
+		switch r.Status {
+		case rowAccepted:
+			report.Accepted++
+		case rowSkipped:
+			report.Skipped++
+		case rowQuarantined:
+			report.Quarantined++
+		default:
+			report.Errors++
+		}
+	}
+
+	log.Logger.Info().Str("region", region).Int("accepted", report.Accepted).Int("skipped", report.Skipped).Int("quarantined", report.Quarantined).Int("errors", report.Errors).Msg("Bulk import done")
+	c.JSON(http.StatusOK, report)
+}
+
+// validateBulkRow applies the checks of the matching add endpoint to a row
+// and returns the storage key of its day
+func validateBulkRow(data DailyHouseResp, ds *Dataset) (string, []FieldError) {
+	if errs := validateStruct(&data); len(errs) > 0 {
+		return "", errs
+	}
+	day, errs := ds.recordDay(data.Day)
+	mapped := ds.mapData(data.DailyData)
+	errs = append(errs, checkDailyData("daily_data", mapped)...)
+	return day, append(errs, checkDistricts(mapped, mapDistricts(data.Districts, ds.mapData))...)
+}
+
+// parseBulkRows reads the request body as a JSON array, NDJSON or CSV
+func parseBulkRows(c *gin.Context) ([]bulkRow, error) {
+	body := http.MaxBytesReader(c.Writer, c.Request.Body, bulkMaxBodyBytes)
+
+	format := c.Query("format")
+	if format == "" {
+		switch c.ContentType() {
+		case "text/csv":
+			format = "csv"
+		case "application/x-ndjson", "application/ndjson":
+			format = "ndjson"
+		default:
+			format = "json"
+		}
+	}
+
+	switch format {
+	case "json":
+		return parseJSONRows(body)
+	case "ndjson":
+		return parseNDJSONRows(body)
+	case "csv":
+		return parseCSVRows(body)
+	}
+	return nil, fmt.Errorf("invalid format %q (must be json, ndjson or csv)", format)
+}
+
+func parseJSONRows(r io.Reader) ([]bulkRow, error) {
+	// Decode one element at a time so an oversized array is not read whole
+	dec := json.NewDecoder(r)
+	if token, err := dec.Token(); err != nil || token != json.Delim('[') {
+		return nil, fmt.Errorf("invalid JSON array: expected [")
+	}
+
+	var rows []bulkRow
+	for dec.More() {
+		if len(rows) == bulkMaxRows {
+			return nil, errTooManyRows
+		}
+		var element json.RawMessage
+		if err := dec.Decode(&element); err != nil {
+			return nil, fmt.Errorf("invalid JSON array: %w", err)
+		}
+		row := bulkRow{row: len(rows) + 1}
+		row.err = json.Unmarshal(element, &row.data)
+		rows = append(rows, row)
+	}
+	if _, err := dec.Token(); err != nil {
+		return nil, fmt.Errorf("invalid JSON array: %w", err)
+	}
+	return rows, nil
+}
+
+func parseNDJSONRows(r io.Reader) ([]bulkRow, error) {
+	var rows []bulkRow
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+		if len(rows) == bulkMaxRows {
+			return nil, errTooManyRows
+		}
+		row := bulkRow{row: line}
+		row.err = json.Unmarshal([]byte(text), &row.data)
+		rows = append(rows, row)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("invalid NDJSON: %w", err)
+	}
+	return rows, nil
+}
+
+func parseCSVRows(r io.Reader) ([]bulkRow, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("invalid CSV header: %w", err)
+	}
+	columns := make(map[string]int)
+	for i, name := range header {
+		columns[strings.TrimPrefix(strings.TrimSpace(name), "\ufeff")] = i
+	}
+	if _, ok := columns["day"]; !ok {
+		return nil, fmt.Errorf("CSV header must include %s", strings.Join(bulkCSVColumns, ","))
+	}
+
+	var rows []bulkRow
+	for line := 2; ; line++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		// Only malformed records are reported per row; a failed read
+		// (e.g. the body size limit) fails the whole request
+		var parseErr *csv.ParseError
+		if err != nil && !errors.As(err, &parseErr) {
+			return nil, fmt.Errorf("invalid CSV: %w", err)
+		}
+		if len(rows) == bulkMaxRows {
+			return nil, errTooManyRows
+		}
+		if err != nil {
+			rows = append(rows, bulkRow{row: line, err: err})
+			continue
+		}
+		rows = append(rows, parseCSVRecord(line, record, columns))
+	}
+	return rows, nil
+}
+
+func parseCSVRecord(line int, record []string, columns map[string]int) bulkRow {
+	row := bulkRow{row: line}
+	field := func(name string) string {
+		if i, ok := columns[name]; ok && i < len(record) {
+			return strings.TrimSpace(record[i])
+		}
+		return ""
+	}
+
+	row.data.Day = field("day")
+	targets := []*float64{
+		&row.data.DailyData.TotalCount,
+		&row.data.DailyData.TotalArea,
+		&row.data.DailyData.HouseCount,
+		&row.data.DailyData.HouseArea,
+		&row.data.DailyData.HousePrice,
+		&row.data.DailyData.TotalPrice,
+	}
+	for i, name := range bulkCSVColumns[1:] {
+		value := field(name)
+		if value == "" {
+			continue
+		}
+		v, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			row.err = fmt.Errorf("invalid %s %q", name, value)
+			return row
+		}
+		*targets[i] = v
+	}
+	return row
+}
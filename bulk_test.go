@@ -0,0 +1,187 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/LIUHUANUCAS/house/config"
+	"github.com/gin-gonic/gin"
+)
+
+func TestParseBulkRows(t *testing.T) {
+	cases := []struct {
+		format, body string
+		rows         []int  // want row numbers
+		failed       []bool // want a parse error on the row
+	}{
+		{
+			"json",
+			`[{"day":"2025-06-09","daily_data":{"total_count":10}},{"day":7}]`,
+			[]int{1, 2}, []bool{false, true},
+		},
+		{
+			"ndjson",
+			"{\"day\":\"2025-06-09\",\"daily_data\":{\"total_count\":10}}\n\n{bad\n",
+			[]int{1, 3}, []bool{false, true},
+		},
+		{
+			"csv",
+			"\ufeffday, total_count,house_count\n2025-06-09,10,8\n2025-06-10,ten,8\n",
+			[]int{2, 3}, []bool{false, true},
+		},
+	}
+	for _, tc := range cases {
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request = httptest.NewRequest(http.MethodPost, "/?format="+tc.format, strings.NewReader(tc.body))
+		rows, err := parseBulkRows(c)
+		if err != nil || len(rows) != len(tc.rows) {
+			t.Errorf("%s: got %d rows (%v), want %d", tc.format, len(rows), err, len(tc.rows))
+			continue
+		}
+		for i, r := range rows {
+			if r.row != tc.rows[i] || (r.err != nil) != tc.failed[i] {
+				t.Errorf("%s: row %d: got line %d error %v", tc.format, i, r.row, r.err)
+			}
+		}
+		if d := rows[0].data; d.Day != "2025-06-09" || d.DailyData.TotalCount != 10 {
+			t.Errorf("%s: unexpected data %+v", tc.format, d)
+		}
+	}
+
+	for _, body := range []string{"total_count\n10\n", `{"day":"2025-06-09"}`} {
+		format := "csv"
+		if strings.HasPrefix(body, "{") {
+			format = "json"
+		}
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request = httptest.NewRequest(http.MethodPost, "/?format="+format, strings.NewReader(body))
+		if _, err := parseBulkRows(c); err == nil {
+			t.Errorf("%s: expected an error for %q", format, body)
+		}
+	}
+}
+
+type failingReader struct{ r io.Reader }
+
+func (f *failingReader) Read(p []byte) (int, error) {
+	if n, err := f.r.Read(p); n > 0 || err != io.EOF {
+		return n, err
+	}
+	return 0, errors.New("request body too large")
+}
+
+func TestParseBulkRowsLimits(t *testing.T) {
+	// A failed read ends parsing instead of being reported on every row
+	if _, err := parseCSVRows(&failingReader{strings.NewReader("day\n2025-06-09\n")}); err == nil {
+		t.Error("expected the read error to be returned")
+	}
+
+	csvBody := "day\n" + strings.Repeat("2025-06-09\n", bulkMaxRows+1)
+	ndjsonBody := strings.Repeat(`{"day":"2025-06-09"}`+"\n", bulkMaxRows+1)
+	jsonBody := "[" + strings.Repeat(`{"day":"2025-06-09"},`, bulkMaxRows) + `{"day":"2025-06-09"}]`
+	parsers := map[string]func(io.Reader) ([]bulkRow, error){
+		csvBody:    parseCSVRows,
+		ndjsonBody: parseNDJSONRows,
+		jsonBody:   parseJSONRows,
+	}
+	for body, parse := range parsers {
+		if _, err := parse(strings.NewReader(body)); err != errTooManyRows {
+			t.Errorf("want %v, got %v", errTooManyRows, err)
+		}
+	}
+}
+
+func TestBulkImport(t *testing.T) {
+	useTestStorage(t)
+	stored := DailyHouseResp{Day: "2025-06-08", DailyData: DailyData{TotalCount: 10, TotalArea: 900, HouseCount: 8, HouseArea: 700}}
+	if err := StoreHouseData(ctx, stored.Day, stored, beijingKey); err != nil {
+		t.Fatal(err)
+	}
+
+	var scopes []string
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(func(c *gin.Context) {
+		c.Set(apiKeyCtxKey, APIKey{Scopes: scopes})
+	})
+	router.POST("/bulk", bulkRecords(beijingKey, config.DatasetOld))
+	post := func(query, body string) (*httptest.ResponseRecorder, BulkReport) {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/bulk"+query, strings.NewReader(body))
+		req.Header.Set("Content-Type", "text/csv")
+		router.ServeHTTP(w, req)
+		var report BulkReport
+		json.Unmarshal(w.Body.Bytes(), &report)
+		return w, report
+	}
+
+	body := "day,total_count,total_area,house_count,house_area\n" +
+		"2025-06-09,12,1000,9,800\n" + // accepted
+		"2025-06-09,12,1000,9,800\n" + // duplicate
+		"2025-06-08,11,950,9,750\n" + // already stored
+		"2025-06-10,5,400,9,800\n" // more houses than the total
+	w, report := post("", body)
+	if w.Code != http.StatusOK {
+		t.Fatalf("got %d %s", w.Code, w.Body.String())
+	}
+	if report.Accepted != 1 || report.Skipped != 2 || report.Errors != 1 || len(report.Rows) != 4 {
+		t.Errorf("unexpected report %+v", report)
+	}
+	if r := report.Rows[3]; r.Row != 5 || r.Status != rowError || len(r.Fields) == 0 {
+		t.Errorf("unexpected invalid row %+v", r)
+	}
+	if got, found, _ := GetHouseData(ctx, "2025-06-09", beijingKey); !found || got.DailyData.TotalCount != 12 {
+		t.Errorf("accepted row not stored: %+v", got)
+	}
+
+	// Overwriting needs the force scope
+	overwrite := "day,total_count,total_area,house_count,house_area\n2025-06-08,11,950,9,750\n"
+	if w, _ := post("?overwrite=true", overwrite); w.Code != http.StatusForbidden {
+		t.Errorf("want 403 without the force scope, got %d", w.Code)
+	}
+	scopes = []string{ScopeForce}
+	if _, report := post("?overwrite=true", overwrite); report.Accepted != 1 {
+		t.Errorf("unexpected report %+v", report)
+	}
+	if got, _, _ := GetHouseData(ctx, "2025-06-08", beijingKey); got.DailyData.TotalCount != 11 {
+		t.Errorf("stored day not overwritten: %+v", got)
+	}
+}
+
+func TestBulkImportBatches(t *testing.T) {
+	useTestStorage(t)
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.POST("/bulk", bulkRecords(beijingKey, config.DatasetOld))
+
+	// Rows spanning several batches are all written
+	var rows []DailyHouseResp
+	for i := range bulkBatchSize + 10 {
+		day := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC).AddDate(0, 0, i).Format(dayLayout)
+		rows = append(rows, DailyHouseResp{Day: day, DailyData: DailyData{TotalCount: 10, TotalArea: 900, HouseCount: 8, HouseArea: 700}})
+	}
+	body, _ := json.Marshal(rows)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/bulk", strings.NewReader(string(body))))
+	var report BulkReport
+	if err := json.Unmarshal(w.Body.Bytes(), &report); err != nil || report.Accepted != len(rows) {
+		t.Fatalf("got %d %s", w.Code, w.Body.String())
+	}
+	if _, found, _ := GetHouseData(ctx, rows[len(rows)-1].Day, beijingKey); !found {
+		t.Error("last batch not stored")
+	}
+
+	// Sent again, every row is skipped after the batched existence check
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/bulk", strings.NewReader(string(body))))
+	report = BulkReport{}
+	if err := json.Unmarshal(w.Body.Bytes(), &report); err != nil || report.Skipped != len(rows) {
+		t.Errorf("got %d %s", w.Code, w.Body.String())
+	}
+}
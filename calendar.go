@@ -0,0 +1,239 @@
+package main
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/LIUHUANUCAS/house/config"
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+	"gopkg.in/yaml.v3"
+)
+
+// Day types
+const (
+	DayWorkday       = "workday"
+	DayWeekend       = "weekend"
+	DayHoliday       = "holiday"
+	DayMakeupWorkday = "makeup_workday" // Weekend day worked to make up for a holiday (调休)
+)
+
+// defaultCalendarData is the mainland China table for the years published so far
+//
+//go:embed calendar_cn.json
+var defaultCalendarData []byte
+
+// CalendarTable is the file format of a holiday table
+type CalendarTable struct {
+	Holidays []HolidayRange  `json:"holidays" yaml:"holidays"`
+	Workdays []MakeupWorkday `json:"workdays" yaml:"workdays"`
+}
+
+// HolidayRange is a public holiday from From to To (YYYY-MM-DD, inclusive)
+type HolidayRange struct {
+	Name string `json:"name" yaml:"name"`
+	From string `json:"from" yaml:"from"`
+	To   string `json:"to" yaml:"to"`
+}
+
+// MakeupWorkday is a weekend day that is a working day
+type MakeupWorkday struct {
+	Name string `json:"name" yaml:"name"`
+	Day  string `json:"day" yaml:"day"`
+}
+
+// DayInfo annotates a day with its type and the holiday it belongs to
+type DayInfo struct {
+	Day     string `json:"day"`
+	Type    string `json:"type"`
+	Holiday string `json:"holiday,omitempty"`
+}
+
+// Calendar classifies days. Days outside the years of its table are
+// workdays or weekends.
+type Calendar struct {
+	holidays map[string]string // day -> holiday name
+	workdays map[string]string // day -> holiday name
+	years    map[int]bool
+}
+
+// holidayCalendar is the calendar in use; InitCalendar replaces it
+var holidayCalendar = mustParseDefaultCalendar()
+
+func mustParseDefaultCalendar() *Calendar {
+	var table CalendarTable
+	if err := json.Unmarshal(defaultCalendarData, &table); err != nil {
+		panic(fmt.Sprintf("invalid built-in calendar: %v", err))
+	}
+	cal := &Calendar{holidays: map[string]string{}, workdays: map[string]string{}, years: map[int]bool{}}
+	if err := cal.merge(table); err != nil {
+		panic(fmt.Sprintf("invalid built-in calendar: %v", err))
+	}
+	return cal
+}
+
+// merge adds table to the calendar, replacing the entries of the years it covers
+func (cal *Calendar) merge(table CalendarTable) error {
+	holidays := make(map[string]string)
+	workdays := make(map[string]string)
+	years := make(map[int]bool)
+	for _, h := range table.Holidays {
+		from, err := time.Parse(dayLayout, h.From)
+		if err != nil {
+			return fmt.Errorf("holiday %s: invalid from %q", h.Name, h.From)
+		}
+		to, err := time.Parse(dayLayout, h.To)
+		if err != nil || to.Before(from) {
+			return fmt.Errorf("holiday %s: invalid to %q", h.Name, h.To)
+		}
+		for t := from; !t.After(to); t = t.AddDate(0, 0, 1) {
+			holidays[t.Format(dayLayout)] = h.Name
+			years[t.Year()] = true
+		}
+	}
+	for _, w := range table.Workdays {
+		t, err := time.Parse(dayLayout, w.Day)
+		if err != nil {
+			return fmt.Errorf("workday %s: invalid day %q", w.Name, w.Day)
+		}
+		workdays[w.Day] = w.Name
+		years[t.Year()] = true
+	}
+
+	for year := range years {
+		prefix := fmt.Sprintf("%d-", year)
+		for day := range cal.holidays {
+			if strings.HasPrefix(day, prefix) {
+				delete(cal.holidays, day)
+			}
+		}
+		for day := range cal.workdays {
+			if strings.HasPrefix(day, prefix) {
+				delete(cal.workdays, day)
+			}
+		}
+		cal.years[year] = true
+	}
+	for day, name := range holidays {
+		cal.holidays[day] = name
+	}
+	for day, name := range workdays {
+		cal.workdays[day] = name
+	}
+	return nil
+}
+
+// InitCalendar loads the table configured in calCfg on top of the built-in one
+func InitCalendar(calCfg *config.CalendarConfig) error {
+	if calCfg.Path == "" {
+		return nil
+	}
+	data, err := os.ReadFile(calCfg.Path)
+	if err != nil {
+		return fmt.Errorf("read calendar: %w", err)
+	}
+	var table CalendarTable
+	switch strings.ToLower(filepath.Ext(calCfg.Path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &table)
+	default:
+		err = json.Unmarshal(data, &table)
+	}
+	if err != nil {
+		return fmt.Errorf("parse calendar %s: %w", calCfg.Path, err)
+	}
+
+	cal := mustParseDefaultCalendar()
+	if err := cal.merge(table); err != nil {
+		return fmt.Errorf("calendar %s: %w", calCfg.Path, err)
+	}
+	holidayCalendar = cal
+	log.Logger.Info().Str("path", calCfg.Path).Int("holidays", len(cal.holidays)).Int("workdays", len(cal.workdays)).Msg("Loaded holiday calendar")
+	return nil
+}
+
+// Info classifies the day of t
+func (cal *Calendar) Info(t time.Time) DayInfo {
+	day := t.Format(dayLayout)
+	info := DayInfo{Day: day, Type: DayWorkday}
+	if name, ok := cal.holidays[day]; ok {
+		info.Type, info.Holiday = DayHoliday, name
+	} else if name, ok := cal.workdays[day]; ok {
+		info.Type, info.Holiday = DayMakeupWorkday, name
+	} else if wd := t.Weekday(); wd == time.Saturday || wd == time.Sunday {
+		info.Type = DayWeekend
+	}
+	return info
+}
+
+// IsWorkday reports whether t is a working day, including make-up workdays
+func (cal *Calendar) IsWorkday(t time.Time) bool {
+	switch cal.Info(t).Type {
+	case DayWorkday, DayMakeupWorkday:
+		return true
+	}
+	return false
+}
+
+// dayInfo classifies a record day (YYYY-MM-DD or YYYY-MM-DD-HH); it returns
+// nil for anything else
+func dayInfo(day string) *DayInfo {
+	if len(day) < len(dayLayout) {
+		return nil
+	}
+	t, err := time.Parse(dayLayout, day[:len(dayLayout)])
+	if err != nil {
+		return nil
+	}
+	info := holidayCalendar.Info(t)
+	return &info
+}
+
+// isWorkdayRecord reports whether a record day falls on a working day
+func isWorkdayRecord(day string) bool {
+	info := dayInfo(day)
+	return info != nil && (info.Type == DayWorkday || info.Type == DayMakeupWorkday)
+}
+
+// workdaysOnly reports whether the request asks to use working days only
+func workdaysOnly(c *gin.Context) bool {
+	v := c.Query("workdays_only")
+	return v == "1" || v == "true"
+}
+
+// getCalendar lists the days between from (default the first day of the
+// month) and to (default the last day of the month) with their type
+func getCalendar(c *gin.Context) {
+	now := time.Now()
+	first := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+	fromParam := c.DefaultQuery("from", first.Format(dayLayout))
+	toParam := c.DefaultQuery("to", first.AddDate(0, 1, -1).Format(dayLayout))
+	errs := append(checkDate("from", fromParam, dayLayout), checkDate("to", toParam, dayLayout)...)
+	if len(errs) > 0 {
+		respondValidationError(c, errs)
+		return
+	}
+	from, _ := time.Parse(dayLayout, fromParam)
+	to, _ := time.Parse(dayLayout, toParam)
+	if err := validateRange(from, to); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	days := []DayInfo{}
+	var workdays int
+	for t := from; !t.After(to); t = t.AddDate(0, 0, 1) {
+		info := holidayCalendar.Info(t)
+		if info.Type == DayWorkday || info.Type == DayMakeupWorkday {
+			workdays++
+		}
+		days = append(days, info)
+	}
+	c.JSON(http.StatusOK, gin.H{"from": fromParam, "to": toParam, "workdays": workdays, "days": days})
+}
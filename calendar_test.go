@@ -0,0 +1,41 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCalendarInfo(t *testing.T) {
+	cases := []struct {
+		day, typ, holiday string
+	}{
+		{"2025-01-26", DayMakeupWorkday, "春节"},
+		{"2025-10-01", DayHoliday, "国庆节、中秋节"},
+		{"2025-03-08", DayWeekend, ""},
+		{"2025-03-10", DayWorkday, ""},
+	}
+	for _, tc := range cases {
+		day, _ := time.Parse(dayLayout, tc.day)
+		info := holidayCalendar.Info(day)
+		if info.Type != tc.typ || info.Holiday != tc.holiday {
+			t.Errorf("%s: got %s %q, want %s %q", tc.day, info.Type, info.Holiday, tc.typ, tc.holiday)
+		}
+	}
+}
+
+func TestCalendarMergeReplacesYear(t *testing.T) {
+	cal := mustParseDefaultCalendar()
+	err := cal.merge(CalendarTable{Holidays: []HolidayRange{{Name: "test", From: "2025-03-10", To: "2025-03-11"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	check := func(day, want string) {
+		d, _ := time.Parse(dayLayout, day)
+		if got := cal.Info(d).Type; got != want {
+			t.Errorf("%s: got %s, want %s", day, got, want)
+		}
+	}
+	check("2025-03-10", DayHoliday)
+	check("2025-10-01", DayWorkday) // 2025 replaced by the override
+	check("2026-01-01", DayHoliday) // other years kept
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+)
+
+// Change compares one metric with the same metric of another period.
+// Previous and the changes are null when that period has no data, and the
+// percentage is null when the previous value is 0.
+type Change struct {
+	Current   float64  `json:"current"`
+	Previous  *float64 `json:"previous"`
+	AbsChange *float64 `json:"abs_change"`
+	PctChange *float64 `json:"pct_change"`
+}
+
+// PeriodComparison compares a day or month with another one
+type PeriodComparison struct {
+	Period  string            `json:"period"` // the day or month compared against
+	Found   bool              `json:"found"`
+	Changes map[string]Change `json:"changes"`
+}
+
+// compareMetrics computes the change of every current metric against previous
+func compareMetrics(current, previous map[string]float64, found bool) map[string]Change {
+	changes := make(map[string]Change, len(current))
+	for name, cur := range current {
+		change := Change{Current: cur}
+		if found {
+			prev := previous[name]
+			abs := cur - prev
+			change.Previous = &prev
+			change.AbsChange = &abs
+			if prev != 0 {
+				pct := math.Round(abs/prev*10000) / 100
+				change.PctChange = &pct
+			}
+		}
+		changes[name] = change
+	}
+	return changes
+}
+
+// compareHouse compares a day (day=YYYY-MM-DD or YYYY-MM-DD-HH) or a month
+// (month=YYYY-MM) with the previous period and the same period last year
+func compareHouse(c *gin.Context) {
+	region := requestRegion(c)
+
+	if month, ok := c.GetQuery("month"); ok {
+		if errs := checkDate("month", month, monthLayout); len(errs) > 0 {
+			respondValidationError(c, errs)
+			return
+		}
+		if workdaysOnly(c) {
+			compareWorkdayMonth(c, region, month)
+			return
+		}
+		compareMonth(c, region, month)
+		return
+	}
+
+	day := c.Query("day")
+	layout := dayLayout
+	if len(day) == len(dayHourLayout) {
+		layout = dayHourLayout
+	}
+	if errs := checkDate("day", day, layout); len(errs) > 0 {
+		respondValidationError(c, errs)
+		return
+	}
+	if workdaysOnly(c) && !isWorkdayRecord(day) {
+		respondValidationError(c, []FieldError{{Field: "day", Rule: "workday", Message: "must be a working day when workdays_only is set"}})
+		return
+	}
+	compareDay(c, region, day, layout, workdaysOnly(c))
+}
+
+// maxWorkdaySearch bounds the search for a working day, longer than any holiday
+const maxWorkdaySearch = 15
+
+// workdayOnOrBefore returns the closest working day on or before t
+func workdayOnOrBefore(t time.Time) time.Time {
+	for i := 0; i < maxWorkdaySearch && !holidayCalendar.IsWorkday(t); i++ {
+		t = t.AddDate(0, 0, -1)
+	}
+	return t
+}
+
+// compareDay compares a day with the day before and the same day last year;
+// with workdaysOnly these are the closest working days on or before them
+func compareDay(c *gin.Context, region, day, layout string, workdaysOnly bool) {
+	current, found, err := GetHouseData(ctx, day, region)
+	if err != nil {
+		log.Logger.Error().Err(err).Str("day", day).Str("region", region).Msg("Failed to get house data for comparison")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get house data"})
+		return
+	}
+	if !found {
+		c.JSON(http.StatusNotFound, gin.H{"msg": "data not found"})
+		return
+	}
+
+	t, _ := time.Parse(layout, day)
+	compareWith := func(other string) PeriodComparison {
+		data, found, err := GetHouseData(ctx, other, region)
+		if err != nil {
+			log.Logger.Error().Err(err).Str("day", other).Str("region", region).Msg("Failed to get house data for comparison")
+		}
+		return PeriodComparison{
+			Period:  other,
+			Found:   found,
+			Changes: compareMetrics(current.DailyData.Metrics(), data.DailyData.Metrics(), found),
+		}
+	}
+
+	previous, lastYear := t.AddDate(0, 0, -1), t.AddDate(-1, 0, 0)
+	if workdaysOnly {
+		previous, lastYear = workdayOnOrBefore(previous), workdayOnOrBefore(lastYear)
+	}
+
+	c.JSON(http.StatusOK, withDerived(c, gin.H{
+		"region":        region,
+		"day":           day,
+		"workdays_only": workdaysOnly,
+		"data":          current,
+		"vs_previous":   compareWith(previous.Format(layout)),
+		"vs_last_year":  compareWith(lastYear.Format(layout)),
+	}))
+}
+
+func compareMonth(c *gin.Context, region, month string) {
+	current, found, err := getMonthHouse(ctx, month, region)
+	if err != nil {
+		log.Logger.Error().Err(err).Str("month", month).Str("region", region).Msg("Failed to get month house data for comparison")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get month house data"})
+		return
+	}
+	if !found {
+		c.JSON(http.StatusNotFound, gin.H{"msg": "data not found"})
+		return
+	}
+
+	t, _ := time.Parse(monthLayout, month)
+	compareWith := func(other string) PeriodComparison {
+		data, found, err := getMonthHouse(ctx, other, region)
+		if err != nil {
+			log.Logger.Error().Err(err).Str("month", other).Str("region", region).Msg("Failed to get month house data for comparison")
+		}
+		return PeriodComparison{
+			Period:  other,
+			Found:   found,
+			Changes: compareMetrics(current.MonthData.Metrics(), data.MonthData.Metrics(), found),
+		}
+	}
+
+	c.JSON(http.StatusOK, withDerived(c, gin.H{
+		"region":       region,
+		"month":        month,
+		"data":         current,
+		"vs_previous":  compareWith(t.AddDate(0, -1, 0).Format(monthLayout)),
+		"vs_last_year": compareWith(t.AddDate(-1, 0, 0).Format(monthLayout)),
+	}))
+}
+
+// workdayAverages returns the fields of agg averaged per working day record
+func workdayAverages(agg HouseAggregate) map[string]float64 {
+	averages := agg.Data.Metrics()
+	for name, v := range averages {
+		averages[name] = round2(v / float64(agg.Days))
+	}
+	return averages
+}
+
+// compareWorkdayMonth compares the average working day of a month with the
+// previous month and the same month last year, so months with more holidays
+// compare fairly
+func compareWorkdayMonth(c *gin.Context, region, month string) {
+	t, _ := time.Parse(monthLayout, month)
+	current, err := computeAggregate(ctx, region, PeriodMonth, t, true)
+	if err != nil {
+		log.Logger.Error().Err(err).Str("month", month).Str("region", region).Msg("Failed to get working-day month for comparison")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get month house data"})
+		return
+	}
+	if current.Days == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"msg": "data not found"})
+		return
+	}
+
+	compareWith := func(other time.Time) PeriodComparison {
+		agg, err := computeAggregate(ctx, region, PeriodMonth, other, true)
+		if err != nil {
+			log.Logger.Error().Err(err).Str("month", other.Format(monthLayout)).Str("region", region).Msg("Failed to get working-day month for comparison")
+		}
+		found := err == nil && agg.Days > 0
+		var previous map[string]float64
+		if found {
+			previous = workdayAverages(agg)
+		}
+		return PeriodComparison{
+			Period:  other.Format(monthLayout),
+			Found:   found,
+			Changes: compareMetrics(workdayAverages(current), previous, found),
+		}
+	}
+
+	c.JSON(http.StatusOK, withDerived(c, gin.H{
+		"region":        region,
+		"month":         month,
+		"workdays_only": true,
+		"basis":         "working_day_average",
+		"data":          current,
+		"vs_previous":   compareWith(t.AddDate(0, -1, 0)),
+		"vs_last_year":  compareWith(t.AddDate(-1, 0, 0)),
+	}))
+}
@@ -0,0 +1,23 @@
+package main
+
+import "testing"
+
+func TestCompareMetrics(t *testing.T) {
+	current := map[string]float64{"total_count": 120, "house_count": 5}
+	previous := map[string]float64{"total_count": 100, "house_count": 0}
+
+	changes := compareMetrics(current, previous, true)
+	total := changes["total_count"]
+	if total.Previous == nil || *total.Previous != 100 || *total.AbsChange != 20 || *total.PctChange != 20 {
+		t.Errorf("total_count change = %+v", total)
+	}
+	if house := changes["house_count"]; house.AbsChange == nil || *house.AbsChange != 5 || house.PctChange != nil {
+		t.Errorf("house_count change with zero previous = %+v", house)
+	}
+
+	for name, change := range compareMetrics(current, nil, false) {
+		if change.Previous != nil || change.AbsChange != nil || change.PctChange != nil {
+			t.Errorf("%s: expected null changes without data, got %+v", name, change)
+		}
+	}
+}
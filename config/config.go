@@ -2,24 +2,201 @@ package config
 
 // Config contains the configuration for the application.
 type Config struct {
-	RedisConfig RedisConfig `json:"redis_config"`
-	Port        int         `json:"port"`
+	AppName         string         `json:"app_name" yaml:"app_name"`
+	Env             string         `json:"env" yaml:"env"`
+	LogLevel        string         `json:"log_level" yaml:"log_level"`
+	LogConfig       LogConfig      `json:"log_config" yaml:"log_config"`
+	RedisConfig     RedisConfig    `json:"redis_config" yaml:"redis_config"`
+	StorageConfig   StorageConfig  `json:"storage_config" yaml:"storage_config"`
+	AuthConfig      AuthConfig     `json:"auth_config" yaml:"auth_config"`
+	AnomalyConfig   AnomalyConfig  `json:"anomaly_config" yaml:"anomaly_config"`
+	CalendarConfig  CalendarConfig `json:"calendar_config" yaml:"calendar_config"`
+	EventsConfig    EventsConfig   `json:"events_config" yaml:"events_config"`
+	WebhookConfig   WebhookConfig  `json:"webhook_config" yaml:"webhook_config"`
+	Regions         []RegionConfig `json:"regions" yaml:"regions"` // Cities served; set in the config file only
+	Port            int            `json:"port" yaml:"port"`
+	ShutdownTimeout int            `json:"shutdown_timeout" yaml:"shutdown_timeout"` // Seconds to drain requests and pending writes on exit
+	MaxRangeDays    int            `json:"max_range_days" yaml:"max_range_days"`     // Longest span accepted by date-range queries
+}
+
+// LogConfig contains the log file location and rotation settings.
+type LogConfig struct {
+	Dir        string `json:"dir" yaml:"dir"`
+	MaxSize    int    `json:"max_size" yaml:"max_size"`       // MB
+	MaxBackups int    `json:"max_backups" yaml:"max_backups"` // Rotated files to keep
+	MaxAge     int    `json:"max_age" yaml:"max_age"`         // Days
+	Compress   bool   `json:"compress" yaml:"compress"`
 }
 
 // RedisConfig contains the configuration for Redis.
 type RedisConfig struct {
-	Addr     string `json:"addr"`
-	DB       int    `json:"db"`
-	Password string `json:"password"`
+	Addr              string `json:"addr" yaml:"addr"`
+	DB                int    `json:"db" yaml:"db"`
+	Password          string `json:"password" yaml:"password"`
+	ReconnectInterval int    `json:"reconnect_interval" yaml:"reconnect_interval"` // Seconds between connection checks
+}
+
+// StorageConfig selects the storage backend.
+type StorageConfig struct {
+	Backend string `json:"backend" yaml:"backend"` // "redis" or "bolt"
+	Path    string `json:"path" yaml:"path"`       // Database file for the bolt backend
+}
+
+// AuthConfig contains the API key settings.
+type AuthConfig struct {
+	RequireReadKey    bool   `json:"require_read_key" yaml:"require_read_key"`       // Require a key with the read scope for GET endpoints
+	BootstrapAdminKey string `json:"bootstrap_admin_key" yaml:"bootstrap_admin_key"` // Admin key accepted at startup to create the first keys
+}
+
+// AnomalyConfig contains the outlier detection settings for ingested records.
+type AnomalyConfig struct {
+	Enabled    bool    `json:"enabled" yaml:"enabled"`
+	Threshold  float64 `json:"threshold" yaml:"threshold"`     // Robust z-score above which a field is flagged
+	Window     int     `json:"window" yaml:"window"`           // Days of history a record is scored against
+	MinHistory int     `json:"min_history" yaml:"min_history"` // Records needed in the window before scoring
+	Strict     bool    `json:"strict" yaml:"strict"`           // Quarantine flagged records instead of serving them
+	LogSize    int     `json:"log_size" yaml:"log_size"`       // Anomalies kept per region
+}
+
+// CalendarConfig locates the holiday and make-up workday table.
+type CalendarConfig struct {
+	Path string `json:"path" yaml:"path"` // JSON or YAML table; its years replace the built-in ones
+}
+
+// EventsConfig contains the settings of the record event stream.
+type EventsConfig struct {
+	LogSize int    `json:"log_size" yaml:"log_size"` // Events kept for Last-Event-ID resume
+	Channel string `json:"channel" yaml:"channel"`   // Redis pub/sub channel shared by the instances
+}
+
+// WebhookConfig contains the outbound webhook delivery settings.
+type WebhookConfig struct {
+	Workers     int `json:"workers" yaml:"workers"`           // Concurrent deliveries
+	MaxAttempts int `json:"max_attempts" yaml:"max_attempts"` // Attempts before a delivery is dead-lettered
+	Backoff     int `json:"backoff" yaml:"backoff"`           // Seconds before the first retry, doubled after each attempt
+	Timeout     int `json:"timeout" yaml:"timeout"`           // Seconds to wait for the receiver
+	LogSize     int `json:"log_size" yaml:"log_size"`         // Attempts kept per webhook, and dead letters kept
+}
+
+// Dataset names
+const (
+	DatasetNew     = "new"     // New-house daily records
+	DatasetOld     = "old"     // Second-hand daily records
+	DatasetMonthly = "monthly" // Monthly records
+)
+
+// Key granularities
+const (
+	GranularityDay   = "day"   // YYYY-MM-DD
+	GranularityHour  = "hour"  // YYYY-MM-DD-HH
+	GranularityMonth = "month" // YYYY-MM
+)
+
+// RegionConfig describes a city whose house data is served.
+type RegionConfig struct {
+	Code     string          `json:"code" yaml:"code"`         // Storage key segment and route parameter
+	Name     string          `json:"name" yaml:"name"`         // Display name
+	Timezone string          `json:"timezone" yaml:"timezone"` // IANA zone the days of the region are counted in
+	Datasets []DatasetConfig `json:"datasets" yaml:"datasets"`
+}
+
+// DatasetConfig describes one dataset of a region.
+type DatasetConfig struct {
+	Name        string            `json:"name" yaml:"name"`               // new, old or monthly
+	Granularity string            `json:"granularity" yaml:"granularity"` // day, hour or month
+	KeySuffix   string            `json:"key_suffix" yaml:"key_suffix"`   // Appended to the day of the storage key
+	Delay       int               `json:"delay" yaml:"delay"`             // Periods between a period and the publication of its data
+	Lookback    int               `json:"lookback" yaml:"lookback"`       // Periods tried, from the newest, when serving the latest record
+	Fields      map[string]string `json:"fields" yaml:"fields"`           // Stored field -> posted field; empty stores the fields as posted
+	Derived     bool              `json:"derived" yaml:"derived"`         // Monthly only: served from the daily aggregates instead of posted months
+}
+
+// DefaultRegions returns the built-in Beijing and Shanghai registry.
+func DefaultRegions() []RegionConfig {
+	// Shanghai only publishes residential figures, so the total counts and
+	// areas mirror them; total_price is a separate measure and is only kept
+	// when posted
+	shNew := map[string]string{
+		"total_count": "house_count",
+		"total_area":  "house_area",
+		"house_count": "house_count",
+		"house_area":  "house_area",
+	}
+	shOld := map[string]string{
+		"total_count": "house_count",
+		"total_area":  "house_area",
+		"house_count": "house_count",
+		"house_area":  "house_area",
+		"house_price": "house_price",
+		"total_price": "total_price",
+	}
+	return []RegionConfig{
+		{
+			Code:     "beijing",
+			Name:     "北京",
+			Timezone: "Asia/Shanghai",
+			Datasets: []DatasetConfig{
+				{Name: DatasetOld, Granularity: GranularityDay, Delay: 1, Lookback: 2},
+				{Name: DatasetNew, Granularity: GranularityDay, KeySuffix: "-00", Delay: 1, Lookback: 2},
+				{Name: DatasetMonthly, Granularity: GranularityMonth, Lookback: 3},
+			},
+		},
+		{
+			Code:     "shanghai",
+			Name:     "上海",
+			Timezone: "Asia/Shanghai",
+			Datasets: []DatasetConfig{
+				{Name: DatasetNew, Granularity: GranularityHour, Lookback: 3, Fields: shNew},
+				{Name: DatasetOld, Granularity: GranularityDay, Delay: 1, Lookback: 2, Fields: shOld},
+				{Name: DatasetMonthly, Granularity: GranularityMonth, Lookback: 3, Derived: true},
+			},
+		},
+	}
 }
 
-// GetConfig returns the configuration for the application.
+// GetConfig returns the default configuration for the application.
 func GetConfig() *Config {
 	cfg := &Config{
+		AppName:  "house",
+		Env:      "dev",
+		LogLevel: "debug",
+		LogConfig: LogConfig{
+			Dir:        "logs",
+			MaxSize:    100,
+			MaxBackups: 5,
+			MaxAge:     30,
+			Compress:   true,
+		},
 		RedisConfig: RedisConfig{
-			Addr: "localhost:6379", // Default Redis address
+			Addr:              "localhost:6379", // Default Redis address
+			ReconnectInterval: 5,
+		},
+		StorageConfig: StorageConfig{
+			Backend: "redis",
+			Path:    "data/house.db",
+		},
+		AnomalyConfig: AnomalyConfig{
+			Enabled:    true,
+			Threshold:  3.5,
+			Window:     60,
+			MinHistory: 10,
+			LogSize:    1000,
+		},
+		EventsConfig: EventsConfig{
+			LogSize: 1000,
+			Channel: "house:events",
+		},
+		WebhookConfig: WebhookConfig{
+			Workers:     4,
+			MaxAttempts: 5,
+			Backoff:     2,
+			Timeout:     10,
+			LogSize:     1000,
 		},
-		Port: 8080,
+		Port:            8080,
+		ShutdownTimeout: 15,
+		MaxRangeDays:    366,
+		Regions:         DefaultRegions(),
 	}
 	return cfg
 }
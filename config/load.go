@@ -0,0 +1,285 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+	_ "time/tzdata" // Region timezones must resolve on hosts without zoneinfo
+
+	"gopkg.in/yaml.v3"
+)
+
+// EnvPrefix is the prefix of environment variables overriding the configuration
+const EnvPrefix = "HOUSE_"
+
+// option is a configuration value settable from the environment and the
+// command line. The environment variable is EnvPrefix + the upper-cased flag
+// name with dashes replaced by underscores (port -> HOUSE_PORT).
+type option struct {
+	name   string
+	usage  string
+	target interface{} // *string, *int, *float64 or *bool
+}
+
+func (o option) envName() string {
+	return EnvPrefix + strings.ToUpper(strings.ReplaceAll(o.name, "-", "_"))
+}
+
+func (o option) set(value string) error {
+	switch t := o.target.(type) {
+	case *string:
+		*t = value
+	case *int:
+		v, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("%s: invalid integer %q", o.name, value)
+		}
+		*t = v
+	case *float64:
+		v, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return fmt.Errorf("%s: invalid number %q", o.name, value)
+		}
+		*t = v
+	case *bool:
+		v, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("%s: invalid boolean %q", o.name, value)
+		}
+		*t = v
+	}
+	return nil
+}
+
+func options(cfg *Config) []option {
+	return []option{
+		{"app-name", "application name", &cfg.AppName},
+		{"env", "deployment environment", &cfg.Env},
+		{"port", "HTTP listen port", &cfg.Port},
+		{"shutdown-timeout", "seconds to drain requests and pending writes on exit", &cfg.ShutdownTimeout},
+		{"max-range-days", "longest span accepted by date-range queries", &cfg.MaxRangeDays},
+		{"log-level", "log level (trace, debug, info, warn, error)", &cfg.LogLevel},
+		{"log-dir", "log file directory", &cfg.LogConfig.Dir},
+		{"log-max-size", "log file size in MB before rotation", &cfg.LogConfig.MaxSize},
+		{"log-max-backups", "rotated log files to keep", &cfg.LogConfig.MaxBackups},
+		{"log-max-age", "days to keep rotated log files", &cfg.LogConfig.MaxAge},
+		{"log-compress", "compress rotated log files", &cfg.LogConfig.Compress},
+		{"redis-addr", "Redis address", &cfg.RedisConfig.Addr},
+		{"redis-db", "Redis database", &cfg.RedisConfig.DB},
+		{"redis-password", "Redis password", &cfg.RedisConfig.Password},
+		{"redis-reconnect-interval", "seconds between Redis connection checks", &cfg.RedisConfig.ReconnectInterval},
+		{"storage-backend", "storage backend (redis or bolt)", &cfg.StorageConfig.Backend},
+		{"storage-path", "database file for the bolt backend", &cfg.StorageConfig.Path},
+		{"auth-require-read-key", "require an API key for GET endpoints", &cfg.AuthConfig.RequireReadKey},
+		{"auth-bootstrap-key", "admin API key used to create the first keys", &cfg.AuthConfig.BootstrapAdminKey},
+		{"anomaly-enabled", "score ingested records against recent history", &cfg.AnomalyConfig.Enabled},
+		{"anomaly-threshold", "robust z-score above which a field is flagged", &cfg.AnomalyConfig.Threshold},
+		{"anomaly-window", "days of history a record is scored against", &cfg.AnomalyConfig.Window},
+		{"anomaly-min-history", "records needed in the window before scoring", &cfg.AnomalyConfig.MinHistory},
+		{"anomaly-strict", "quarantine flagged records instead of serving them", &cfg.AnomalyConfig.Strict},
+		{"anomaly-log-size", "anomalies kept per region", &cfg.AnomalyConfig.LogSize},
+		{"calendar-path", "holiday and make-up workday table (JSON or YAML)", &cfg.CalendarConfig.Path},
+		{"events-log-size", "events kept for Last-Event-ID resume", &cfg.EventsConfig.LogSize},
+		{"events-channel", "Redis pub/sub channel of the event stream", &cfg.EventsConfig.Channel},
+		{"webhook-workers", "concurrent webhook deliveries", &cfg.WebhookConfig.Workers},
+		{"webhook-max-attempts", "webhook attempts before a delivery is dead-lettered", &cfg.WebhookConfig.MaxAttempts},
+		{"webhook-backoff", "seconds before the first webhook retry, doubled after each attempt", &cfg.WebhookConfig.Backoff},
+		{"webhook-timeout", "seconds to wait for a webhook receiver", &cfg.WebhookConfig.Timeout},
+		{"webhook-log-size", "delivery attempts kept per webhook, and dead letters kept", &cfg.WebhookConfig.LogSize},
+	}
+}
+
+// Load builds the configuration from the defaults, then the config file, then
+// HOUSE_* environment variables, then command-line flags, each overriding the
+// previous one. The config file is given by -config or HOUSE_CONFIG and may be
+// JSON or YAML.
+func Load(args []string) (*Config, error) {
+	cfg := GetConfig()
+	opts := options(cfg)
+
+	// Collect flags first; they are applied last so they win over the file
+	fs := flag.NewFlagSet("house", flag.ContinueOnError)
+	configFile := fs.String("config", os.Getenv(EnvPrefix+"CONFIG"), "JSON or YAML config file")
+	flagValues := make(map[string]string)
+	for _, o := range opts {
+		name := o.name
+		collect := func(v string) error {
+			flagValues[name] = v
+			return nil
+		}
+		// Booleans may be given bare, e.g. -anomaly-strict
+		if _, ok := o.target.(*bool); ok {
+			fs.BoolFunc(name, o.usage+" (env "+o.envName()+")", collect)
+		} else {
+			fs.Func(name, o.usage+" (env "+o.envName()+")", collect)
+		}
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	if *configFile != "" {
+		if err := loadFile(cfg, *configFile); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, o := range opts {
+		if v, ok := os.LookupEnv(o.envName()); ok {
+			if err := o.set(v); err != nil {
+				return nil, fmt.Errorf("env %s: %w", o.envName(), err)
+			}
+		}
+	}
+
+	for _, o := range opts {
+		if v, ok := flagValues[o.name]; ok {
+			if err := o.set(v); err != nil {
+				return nil, fmt.Errorf("flag -%w", err)
+			}
+		}
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+func loadFile(cfg *Config, path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("read config file: %w", err)
+	}
+	// A regions list in the file replaces the built-in one rather than
+	// being merged into it element by element
+	regions := cfg.Regions
+	cfg.Regions = nil
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, cfg)
+	default:
+		err = json.Unmarshal(data, cfg)
+	}
+	if err != nil {
+		return fmt.Errorf("parse config file %s: %w", path, err)
+	}
+	if cfg.Regions == nil {
+		cfg.Regions = regions
+	}
+	return nil
+}
+
+// Validate checks that the configuration values are usable
+func (c *Config) Validate() error {
+	var errs []error
+	if c.Port <= 0 || c.Port > 65535 {
+		errs = append(errs, fmt.Errorf("port must be between 1 and 65535, got %d", c.Port))
+	}
+	if c.ShutdownTimeout <= 0 {
+		errs = append(errs, fmt.Errorf("shutdown_timeout must be positive, got %d", c.ShutdownTimeout))
+	}
+	if c.MaxRangeDays <= 0 {
+		errs = append(errs, fmt.Errorf("max_range_days must be positive, got %d", c.MaxRangeDays))
+	}
+	switch c.LogLevel {
+	case "trace", "debug", "info", "warn", "error", "fatal", "panic", "disabled":
+	default:
+		errs = append(errs, fmt.Errorf("invalid log_level %q", c.LogLevel))
+	}
+	if c.LogConfig.Dir == "" {
+		errs = append(errs, errors.New("log_config.dir must be set"))
+	}
+	if c.LogConfig.MaxSize <= 0 || c.LogConfig.MaxBackups < 0 || c.LogConfig.MaxAge < 0 {
+		errs = append(errs, errors.New("log_config rotation sizes must not be negative and max_size must be positive"))
+	}
+	if c.RedisConfig.ReconnectInterval <= 0 {
+		errs = append(errs, fmt.Errorf("redis_config.reconnect_interval must be positive, got %d", c.RedisConfig.ReconnectInterval))
+	}
+	switch c.StorageConfig.Backend {
+	case "redis":
+		if c.RedisConfig.Addr == "" {
+			errs = append(errs, errors.New("redis_config.addr must be set for the redis backend"))
+		}
+	case "bolt":
+		if c.StorageConfig.Path == "" {
+			errs = append(errs, errors.New("storage_config.path must be set for the bolt backend"))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("invalid storage_config.backend %q (must be redis or bolt)", c.StorageConfig.Backend))
+	}
+	if a := c.AnomalyConfig; a.Threshold <= 0 || a.Window <= 0 || a.MinHistory < 3 || a.LogSize <= 0 {
+		errs = append(errs, errors.New("anomaly_config threshold, window and log_size must be positive and min_history at least 3"))
+	}
+	if c.EventsConfig.LogSize <= 0 || c.EventsConfig.Channel == "" {
+		errs = append(errs, errors.New("events_config log_size must be positive and channel set"))
+	}
+	if w := c.WebhookConfig; w.Workers <= 0 || w.MaxAttempts <= 0 || w.Backoff < 0 || w.Timeout <= 0 || w.LogSize <= 0 {
+		errs = append(errs, errors.New("webhook_config workers, max_attempts, timeout and log_size must be positive and backoff not negative"))
+	}
+	errs = append(errs, validateRegions(c.Regions)...)
+	if len(errs) > 0 {
+		return fmt.Errorf("invalid config: %w", errors.Join(errs...))
+	}
+	return nil
+}
+
+// Redacted returns a copy of the configuration with secrets masked, for logging
+func (c *Config) Redacted() Config {
+	redacted := *c
+	if redacted.RedisConfig.Password != "" {
+		redacted.RedisConfig.Password = "******"
+	}
+	if redacted.AuthConfig.BootstrapAdminKey != "" {
+		redacted.AuthConfig.BootstrapAdminKey = "******"
+	}
+	return redacted
+}
+
+func validateRegions(regions []RegionConfig) []error {
+	var errs []error
+	if len(regions) == 0 {
+		errs = append(errs, errors.New("regions must list at least one region"))
+	}
+	codes := make(map[string]bool)
+	for _, r := range regions {
+		if r.Code == "" || strings.ContainsAny(r.Code, ": /") {
+			errs = append(errs, fmt.Errorf("regions: invalid code %q", r.Code))
+		} else if codes[r.Code] {
+			errs = append(errs, fmt.Errorf("regions: duplicate code %q", r.Code))
+		}
+		codes[r.Code] = true
+		if _, err := time.LoadLocation(r.Timezone); err != nil || r.Timezone == "" {
+			errs = append(errs, fmt.Errorf("regions.%s: invalid timezone %q", r.Code, r.Timezone))
+		}
+		names := make(map[string]bool)
+		for _, d := range r.Datasets {
+			switch d.Name {
+			case DatasetNew, DatasetOld:
+				if d.Granularity != GranularityDay && d.Granularity != GranularityHour {
+					errs = append(errs, fmt.Errorf("regions.%s.%s: granularity must be day or hour", r.Code, d.Name))
+				}
+			case DatasetMonthly:
+				if d.Granularity != GranularityMonth {
+					errs = append(errs, fmt.Errorf("regions.%s.%s: granularity must be month", r.Code, d.Name))
+				}
+			default:
+				errs = append(errs, fmt.Errorf("regions.%s: invalid dataset %q (must be new, old or monthly)", r.Code, d.Name))
+			}
+			if names[d.Name] {
+				errs = append(errs, fmt.Errorf("regions.%s: duplicate dataset %q", r.Code, d.Name))
+			}
+			names[d.Name] = true
+			if d.Delay < 0 || d.Lookback <= 0 {
+				errs = append(errs, fmt.Errorf("regions.%s.%s: delay must not be negative and lookback must be positive", r.Code, d.Name))
+			}
+		}
+	}
+	return errs
+}
@@ -0,0 +1,96 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoad(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "house.yaml")
+	content := "port: 9090\nlog_level: info\nredis_config:\n  addr: redis:6379\n"
+	if err := os.WriteFile(file, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("HOUSE_LOG_LEVEL", "warn")
+	t.Setenv("HOUSE_REDIS_DB", "2")
+
+	cfg, err := Load([]string{"-config", file, "-log-level", "error"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Port != 9090 {
+		t.Errorf("port from file: got %d", cfg.Port)
+	}
+	if cfg.RedisConfig.Addr != "redis:6379" {
+		t.Errorf("redis addr from file: got %s", cfg.RedisConfig.Addr)
+	}
+	if cfg.RedisConfig.DB != 2 {
+		t.Errorf("redis db from env: got %d", cfg.RedisConfig.DB)
+	}
+	if cfg.LogLevel != "error" {
+		t.Errorf("log level from flag: got %s", cfg.LogLevel)
+	}
+	if cfg.LogConfig.MaxSize != 100 {
+		t.Errorf("default log max size: got %d", cfg.LogConfig.MaxSize)
+	}
+}
+
+func TestLoadBoolFlags(t *testing.T) {
+	cfg, err := Load([]string{"-anomaly-strict", "-anomaly-enabled=false", "-log-compress", "-port", "9000"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !cfg.AnomalyConfig.Strict || cfg.AnomalyConfig.Enabled || !cfg.LogConfig.Compress || cfg.Port != 9000 {
+		t.Errorf("unexpected flags: strict %v, enabled %v, compress %v, port %d",
+			cfg.AnomalyConfig.Strict, cfg.AnomalyConfig.Enabled, cfg.LogConfig.Compress, cfg.Port)
+	}
+}
+
+func TestLoadInvalid(t *testing.T) {
+	if _, err := Load([]string{"-port", "0"}); err == nil {
+		t.Error("expected error for port 0")
+	}
+	if _, err := Load([]string{"-storage-backend", "mongo"}); err == nil {
+		t.Error("expected error for unknown storage backend")
+	}
+	if _, err := Load([]string{"-port", "abc"}); err == nil {
+		t.Error("expected error for non-numeric port")
+	}
+}
+
+func TestLoadRegions(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "house.yaml")
+	content := `regions:
+  - code: shenzhen
+    name: 深圳
+    timezone: Asia/Shanghai
+    datasets:
+      - {name: old, granularity: day, delay: 1, lookback: 2}
+`
+	if err := os.WriteFile(file, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := Load([]string{"-config", file})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cfg.Regions) != 1 || cfg.Regions[0].Code != "shenzhen" || cfg.Regions[0].Datasets[0].Fields != nil {
+		t.Errorf("regions from file should replace the defaults, got %+v", cfg.Regions)
+	}
+
+	cfg, err = Load(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cfg.Regions) != 2 {
+		t.Errorf("expected the default regions, got %d", len(cfg.Regions))
+	}
+
+	cfg.Regions[0].Datasets[0].Granularity = "month"
+	if err := cfg.Validate(); err == nil {
+		t.Error("expected error for a daily dataset with month granularity")
+	}
+}
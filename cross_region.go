@@ -0,0 +1,180 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+)
+
+// minCorrelationPoints is the number of dates both regions need for a correlation
+const minCorrelationPoints = 3
+
+// Correlation is the Pearson correlation of two regions over the dates both have data
+type Correlation struct {
+	Pearson *float64 `json:"pearson"` // null with too few points or a constant series
+	Points  int      `json:"points"`
+}
+
+// alignSeries returns the sorted union of the days in values (by region, then
+// day) and, per region, the value of each of those days or nil
+func alignSeries(values map[string]map[string]float64, regions []string) ([]string, map[string][]*float64) {
+	var dates []string
+	for _, region := range regions {
+		for day := range values[region] {
+			dates = append(dates, day)
+		}
+	}
+	slices.Sort(dates)
+	dates = slices.Compact(dates)
+
+	series := make(map[string][]*float64, len(regions))
+	for _, region := range regions {
+		points := make([]*float64, len(dates))
+		for i, day := range dates {
+			if v, ok := values[region][day]; ok {
+				points[i] = &v
+			}
+		}
+		series[region] = points
+	}
+	return dates, series
+}
+
+// ratioSeries divides a by b date by date; nil where either is missing or b is 0
+func ratioSeries(a, b []*float64) []*float64 {
+	ratios := make([]*float64, len(a))
+	for i := range a {
+		if a[i] != nil && b[i] != nil {
+			ratios[i] = ratio(*a[i], *b[i])
+		}
+	}
+	return ratios
+}
+
+// pearson returns the Pearson correlation of x and y, and false when either
+// series is constant
+func pearson(x, y []float64) (float64, bool) {
+	n := float64(len(x))
+	var sumX, sumY float64
+	for i := range x {
+		sumX += x[i]
+		sumY += y[i]
+	}
+	meanX, meanY := sumX/n, sumY/n
+	var cov, varX, varY float64
+	for i := range x {
+		dx, dy := x[i]-meanX, y[i]-meanY
+		cov += dx * dy
+		varX += dx * dx
+		varY += dy * dy
+	}
+	if varX == 0 || varY == 0 {
+		return 0, false
+	}
+	return cov / math.Sqrt(varX*varY), true
+}
+
+// correlate computes the correlation of a and b over the dates both have data
+func correlate(a, b []*float64) Correlation {
+	var x, y []float64
+	for i := range a {
+		if a[i] != nil && b[i] != nil {
+			x = append(x, *a[i])
+			y = append(y, *b[i])
+		}
+	}
+	corr := Correlation{Points: len(x)}
+	if len(x) >= minCorrelationPoints {
+		if r, ok := pearson(x, y); ok {
+			r = math.Round(r*10000) / 10000
+			corr.Pearson = &r
+		}
+	}
+	return corr
+}
+
+// getCrossRegion returns metric for several regions (regions=beijing,shanghai)
+// between from (default 30 days ago) and to (default today) on the same dates,
+// with the ratio and correlation of every pair of regions
+func getCrossRegion(c *gin.Context) {
+	metric := c.DefaultQuery("metric", "total_count")
+	toParam := c.DefaultQuery("to", getTodayDay())
+	to, toErr := time.Parse(dayLayout, toParam)
+	fromParam := c.DefaultQuery("from", to.AddDate(0, 0, -29).Format(dayLayout))
+
+	var regions []string
+	for _, region := range strings.Split(c.DefaultQuery("regions", strings.Join(regionCodes(), ",")), ",") {
+		if region = strings.TrimSpace(region); region != "" && !slices.Contains(regions, region) {
+			regions = append(regions, region)
+		}
+	}
+
+	var errs []FieldError
+	if len(regions) < 2 {
+		errs = append(errs, FieldError{Field: "regions", Rule: "min", Message: "must list at least 2 regions"})
+	}
+	for _, region := range regions {
+		if !slices.Contains(regionCodes(), region) {
+			errs = append(errs, FieldError{Field: "regions", Rule: "oneof", Message: "unknown region " + region + " (must be one of " + strings.Join(regionCodes(), ", ") + ")"})
+		}
+	}
+	if !slices.Contains(houseMetrics, metric) {
+		errs = append(errs, FieldError{Field: "metric", Rule: "oneof", Message: "must be one of " + strings.Join(houseMetrics, ", ")})
+	}
+	errs = append(errs, checkDate("from", fromParam, dayLayout)...)
+	if toErr != nil {
+		errs = append(errs, checkDate("to", toParam, dayLayout)...)
+	}
+	if len(errs) > 0 {
+		respondValidationError(c, errs)
+		return
+	}
+	from, _ := time.Parse(dayLayout, fromParam)
+	if err := validateRange(from, to); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	values := make(map[string]map[string]float64, len(regions))
+	for _, region := range regions {
+		v, err := GetMetricValues(ctx, from, to.Add(24*time.Hour-time.Second), region, metric)
+		if err != nil {
+			log.Logger.Error().Err(err).Str("from", fromParam).Str("to", toParam).Str("region", region).Msg("Failed to get house data for cross-region comparison")
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get house data"})
+			return
+		}
+		values[region] = v
+	}
+
+	dates, series := alignSeries(values, regions)
+	if len(dates) == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"msg": "no data found for the specified range"})
+		return
+	}
+
+	ratios := make(map[string][]*float64)
+	correlations := make(map[string]Correlation)
+	for i, a := range regions {
+		for _, b := range regions[i+1:] {
+			pair := a + "/" + b
+			ratios[pair] = ratioSeries(series[a], series[b])
+			correlations[pair] = correlate(series[a], series[b])
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"regions":      regions,
+		"metric":       metric,
+		"from":         fromParam,
+		"to":           toParam,
+		"dates":        dates,
+		"series":       series,
+		"ratios":       ratios,
+		"correlations": correlations,
+	})
+}
@@ -0,0 +1,37 @@
+package main
+
+import "testing"
+
+func TestAlignSeries(t *testing.T) {
+	values := map[string]map[string]float64{
+		beijingKey:  {"2025-06-01": 10, "2025-06-02": 20, "2025-06-03": 30},
+		shanghaiKey: {"2025-06-02": 10, "2025-06-03": 15, "2025-06-04": 20},
+	}
+	dates, series := alignSeries(values, []string{beijingKey, shanghaiKey})
+	if len(dates) != 4 || dates[0] != "2025-06-01" || dates[3] != "2025-06-04" {
+		t.Fatalf("unexpected dates %v", dates)
+	}
+	if series[shanghaiKey][0] != nil || series[beijingKey][3] != nil {
+		t.Error("expected nulls where a region has no data")
+	}
+
+	ratios := ratioSeries(series[beijingKey], series[shanghaiKey])
+	if ratios[0] != nil || ratios[1] == nil || *ratios[1] != 2 {
+		t.Errorf("unexpected ratios %v", ratios)
+	}
+	if corr := correlate(series[beijingKey], series[shanghaiKey]); corr.Points != 2 || corr.Pearson != nil {
+		t.Errorf("expected no correlation from 2 points, got %+v", corr)
+	}
+}
+
+func TestPearson(t *testing.T) {
+	if r, ok := pearson([]float64{1, 2, 3, 4}, []float64{2, 4, 6, 8}); !ok || r < 0.9999 {
+		t.Errorf("expected perfect correlation, got %v", r)
+	}
+	if r, ok := pearson([]float64{1, 2, 3, 4}, []float64{8, 6, 4, 2}); !ok || r > -0.9999 {
+		t.Errorf("expected perfect anti-correlation, got %v", r)
+	}
+	if _, ok := pearson([]float64{1, 2, 3}, []float64{5, 5, 5}); ok {
+		t.Error("expected no correlation for a constant series")
+	}
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// ErrStorageUnavailable is returned by RedisStorage while Redis is unreachable
+var ErrStorageUnavailable = errors.New("storage unavailable: redis is down")
+
+// redisDegraded is set while Redis is unreachable and data is served from memory
+var redisDegraded atomic.Bool
+
+// isDegraded reports whether the service is running without Redis
+func isDegraded() bool {
+	return redisDegraded.Load()
+}
+
+// maxReplayAttempts is the number of failed replays after which a pending
+// write is dropped
+const maxReplayAttempts = 5
+
+// pendingWrite is a write accepted while Redis was unreachable
+type pendingWrite struct {
+	write    func(context.Context) error
+	attempts int // failed replays
+}
+
+// pendingWrites holds writes accepted while Redis was unreachable, keyed by
+// record so only the latest write of a record is replayed
+var pendingWrites = struct {
+	mu     sync.Mutex
+	writes map[string]*pendingWrite
+}{writes: make(map[string]*pendingWrite)}
+
+// replayCtxKey marks the context of replayed writes
+type replayCtxKey struct{}
+
+func queuePendingWrite(id string, write func(context.Context) error) {
+	pendingWrites.mu.Lock()
+	defer pendingWrites.mu.Unlock()
+	pendingWrites.writes[id] = &pendingWrite{write: write}
+}
+
+// supersedePendingWrite drops the pending write of a record once a newer write
+// of it is stored, so that replaying cannot bring back the older value.
+// Replays and write-backs, which may carry an older value, never supersede.
+func supersedePendingWrite(ctx context.Context, id string) {
+	if ctx.Value(replayCtxKey{}) != nil || writeOriginFrom(ctx).Source == sourceWriteBack {
+		return
+	}
+	pendingWrites.mu.Lock()
+	defer pendingWrites.mu.Unlock()
+	delete(pendingWrites.writes, id)
+}
+
+// writeContext returns a function restoring the origin and anomaly mode of
+// writeCtx on the context a queued write is replayed with
+func writeContext(writeCtx context.Context) func(context.Context) context.Context {
+	origin, mode := writeOriginFrom(writeCtx), anomalyModeFrom(writeCtx)
+	return func(ctx context.Context) context.Context {
+		return withAnomalyMode(withWriteOrigin(ctx, origin), mode)
+	}
+}
+
+// queueHouseWrite queues daily house data to be replayed into Redis, keeping
+// the origin and anomaly mode of writeCtx
+func queueHouseWrite(writeCtx context.Context, day string, data DailyHouseResp, region string) {
+	restore := writeContext(writeCtx)
+	queuePendingWrite(formatDailyKey(region, day), func(ctx context.Context) error {
+		err := StoreHouseData(restore(ctx), day, data, region)
+		if errors.Is(err, ErrQuarantined) {
+			// Stored in quarantine, nothing left to replay
+			return nil
+		}
+		return err
+	})
+}
+
+// queueMonthHouseWrite queues monthly house data to be replayed into Redis
+func queueMonthHouseWrite(writeCtx context.Context, month string, data MonthHouseResp, region string) {
+	restore := writeContext(writeCtx)
+	queuePendingWrite(formatMonthlyKey(region, month), func(ctx context.Context) error {
+		return StoreMonthHouseData(restore(ctx), month, data, region)
+	})
+}
+
+// queueFortuneWrite queues fortune data to be replayed into Redis
+func queueFortuneWrite(writeCtx context.Context, day string, data Poem) {
+	restore := writeContext(writeCtx)
+	queuePendingWrite(formatFortuneKey(day), func(ctx context.Context) error {
+		return StoreFortuneData(restore(ctx), day, data)
+	})
+}
+
+// pendingWriteCount returns the number of writes waiting for Redis
+func pendingWriteCount() int {
+	pendingWrites.mu.Lock()
+	defer pendingWrites.mu.Unlock()
+	return len(pendingWrites.writes)
+}
+
+// replayPendingWrites stores the queued writes in Redis. A write that fails
+// stays queued for the next replay, up to maxReplayAttempts; writes queued or
+// superseded meanwhile are left alone.
+func replayPendingWrites(ctx context.Context) {
+	pendingWrites.mu.Lock()
+	ids := make([]string, 0, len(pendingWrites.writes))
+	for id := range pendingWrites.writes {
+		ids = append(ids, id)
+	}
+	pendingWrites.mu.Unlock()
+
+	replayCtx := context.WithValue(ctx, replayCtxKey{}, true)
+	var replayed, failed, dropped int
+	for _, id := range ids {
+		if ctx.Err() != nil {
+			break
+		}
+		pendingWrites.mu.Lock()
+		w, ok := pendingWrites.writes[id]
+		pendingWrites.mu.Unlock()
+		if !ok {
+			continue
+		}
+
+		err := w.write(replayCtx)
+		pendingWrites.mu.Lock()
+		current := pendingWrites.writes[id] == w
+		switch {
+		case err == nil:
+			if current {
+				delete(pendingWrites.writes, id)
+			}
+			replayed++
+		case current && w.attempts+1 >= maxReplayAttempts:
+			delete(pendingWrites.writes, id)
+			dropped++
+		default:
+			w.attempts++
+			failed++
+		}
+		pendingWrites.mu.Unlock()
+
+		if err != nil {
+			log.Logger.Error().Err(err).Str("key", id).Msg("Failed to replay pending write")
+			writeBackFailuresTotal.WithLabelValues("replay").Inc()
+		}
+	}
+	if dropped > 0 {
+		log.Logger.Error().Int("dropped", dropped).Msg("Dropped pending writes after repeated replay failures")
+	}
+	log.Logger.Info().Int("replayed", replayed).Int("failed", failed).Msg("Replayed pending writes into Redis")
+}
+
+// drainPendingWrites replays the queued writes before shutdown and logs the
+// ones that are lost
+func drainPendingWrites(ctx context.Context) {
+	if pendingWriteCount() == 0 {
+		return
+	}
+	if !isDegraded() {
+		replayPendingWrites(ctx)
+	}
+	pendingWrites.mu.Lock()
+	defer pendingWrites.mu.Unlock()
+	for id := range pendingWrites.writes {
+		log.Logger.Error().Str("key", id).Msg("Pending write lost on shutdown")
+	}
+}
+
+// watchRedis pings Redis every interval, switching to degraded mode when it
+// stops answering and back to Redis once it does. Pending writes are replayed
+// on every tick Redis answers.
+func watchRedis(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		pingCtx, cancel := context.WithTimeout(ctx, interval)
+		err := redisClient.Ping(pingCtx).Err()
+		cancel()
+
+		if err != nil {
+			if !redisDegraded.Swap(true) {
+				log.Logger.Warn().Err(err).Msg("Redis is unreachable, serving from memory")
+			}
+			continue
+		}
+		if redisDegraded.Swap(false) {
+			log.Logger.Info().Msg("Redis is reachable again")
+		}
+		if pendingWriteCount() > 0 {
+			replayPendingWrites(ctx)
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+func useTestStorage(t *testing.T) {
+	t.Helper()
+	db, err := OpenBoltStorage(filepath.Join(t.TempDir(), "house.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	saved := storage
+	storage = db
+	t.Cleanup(func() {
+		storage = saved
+		db.Close()
+		pendingWrites.mu.Lock()
+		clear(pendingWrites.writes)
+		pendingWrites.mu.Unlock()
+	})
+}
+
+func TestReplayPendingWrites(t *testing.T) {
+	useTestStorage(t)
+	data := DailyHouseResp{Day: "2025-06-09", DailyData: DailyData{TotalCount: 10, TotalArea: 900, HouseCount: 8, HouseArea: 700}}
+	queueHouseWrite(ctx, data.Day, data, beijingKey)
+
+	replayPendingWrites(ctx)
+	if n := pendingWriteCount(); n != 0 {
+		t.Errorf("want no pending writes, got %d", n)
+	}
+	got, found, err := GetHouseData(ctx, data.Day, beijingKey)
+	if err != nil || !found || got.DailyData != data.DailyData {
+		t.Errorf("replayed data not stored: %+v %v %v", got, found, err)
+	}
+}
+
+func TestPendingWriteSuperseded(t *testing.T) {
+	useTestStorage(t)
+	stale := DailyHouseResp{Day: "2025-06-09", DailyData: DailyData{TotalCount: 10, TotalArea: 900, HouseCount: 8, HouseArea: 700}}
+	fresh := DailyHouseResp{Day: "2025-06-09", DailyData: DailyData{TotalCount: 12, TotalArea: 1000, HouseCount: 9, HouseArea: 800}}
+	queueHouseWrite(ctx, stale.Day, stale, beijingKey)
+
+	if err := StoreHouseData(ctx, fresh.Day, fresh, beijingKey); err != nil {
+		t.Fatal(err)
+	}
+	if n := pendingWriteCount(); n != 0 {
+		t.Fatalf("direct write should supersede the pending one, %d left", n)
+	}
+	replayPendingWrites(ctx)
+	got, _, _ := GetHouseData(ctx, fresh.Day, beijingKey)
+	if got.DailyData != fresh.DailyData {
+		t.Errorf("stale write replayed: got %+v", got.DailyData)
+	}
+}
+
+func TestPendingWriteNotSupersededByWriteBack(t *testing.T) {
+	useTestStorage(t)
+	queued := DailyHouseResp{Day: "2025-06-09", DailyData: DailyData{TotalCount: 12, TotalArea: 1000, HouseCount: 9, HouseArea: 800}}
+	stale := DailyHouseResp{Day: "2025-06-09", DailyData: DailyData{TotalCount: 10, TotalArea: 900, HouseCount: 8, HouseArea: 700}}
+	queueHouseWrite(ctx, queued.Day, queued, beijingKey)
+
+	// A cache fill of an older in-memory copy must not drop the queued write
+	if err := StoreHouseData(writeBackCtx, stale.Day, stale, beijingKey); err != nil {
+		t.Fatal(err)
+	}
+	if n := pendingWriteCount(); n != 1 {
+		t.Fatalf("write-back superseded the queued write, %d left", n)
+	}
+	replayPendingWrites(ctx)
+	got, _, _ := GetHouseData(ctx, queued.Day, beijingKey)
+	if got.DailyData != queued.DailyData {
+		t.Errorf("queued write lost: got %+v", got.DailyData)
+	}
+}
+
+func TestPendingWriteFailure(t *testing.T) {
+	useTestStorage(t)
+	var calls int
+	queuePendingWrite("house:daily:beijing:2025-06-09", func(context.Context) error {
+		calls++
+		return errors.New("unavailable")
+	})
+
+	for i := 1; i < maxReplayAttempts; i++ {
+		replayPendingWrites(ctx)
+		if pendingWriteCount() != 1 {
+			t.Fatalf("write dropped after %d failed replays", i)
+		}
+	}
+	replayPendingWrites(ctx)
+	if pendingWriteCount() != 0 || calls != maxReplayAttempts {
+		t.Errorf("want the write dropped after %d attempts, got %d pending and %d calls", maxReplayAttempts, pendingWriteCount(), calls)
+	}
+}
+
+func TestWriteContext(t *testing.T) {
+	origin := writeOrigin{Caller: "tester", Source: "/v1/force_house"}
+	restore := writeContext(withAnomalyMode(withWriteOrigin(ctx, origin), anomalyFlagOnly))
+	replayCtx := restore(context.Background())
+	if anomalyModeFrom(replayCtx) != anomalyFlagOnly || writeOriginFrom(replayCtx) != origin {
+		t.Error("the replayed write should keep the origin and anomaly mode")
+	}
+}
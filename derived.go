@@ -0,0 +1,145 @@
+package main
+
+import (
+	"github.com/gin-gonic/gin"
+)
+
+// DerivedMetrics are computed from the posted figures on read. A metric is
+// null when its divisor is 0, or for prices when no price was reported.
+type DerivedMetrics struct {
+	AvgPricePerM2        *float64 `json:"avg_price_per_m2"`         // total_price / total_area
+	HouseAvgPricePerM2   *float64 `json:"house_avg_price_per_m2"`   // house_price / house_area
+	AvgPricePerUnit      *float64 `json:"avg_price_per_unit"`       // total_price / total_count
+	HouseAvgPricePerUnit *float64 `json:"house_avg_price_per_unit"` // house_price / house_count
+	AvgUnitSize          *float64 `json:"avg_unit_size"`            // total_area / total_count, m²
+	HouseAvgUnitSize     *float64 `json:"house_avg_unit_size"`      // house_area / house_count, m²
+	HouseAreaShare       *float64 `json:"house_area_share"`         // house_area / total_area, percent
+	HouseCountShare      *float64 `json:"house_count_share"`        // house_count / total_count, percent
+}
+
+// PeriodSummary totals the daily records of a period with the metrics
+// derived from the totals
+type PeriodSummary struct {
+	Days    int             `json:"days"`
+	Data    DailyData       `json:"data"`
+	Derived *DerivedMetrics `json:"derived,omitempty"`
+}
+
+// ratio returns num/den rounded to 2 decimals, or nil when den is 0
+func ratio(num, den float64) *float64 {
+	if den == 0 {
+		return nil
+	}
+	r := round2(num / den)
+	return &r
+}
+
+// priceRatio is ratio for prices, where 0 means the price was not reported
+func priceRatio(price, den float64) *float64 {
+	if price == 0 {
+		return nil
+	}
+	return ratio(price, den)
+}
+
+// deriveDaily computes the derived metrics of d
+func deriveDaily(d DailyData) *DerivedMetrics {
+	return &DerivedMetrics{
+		AvgPricePerM2:        priceRatio(d.TotalPrice, d.TotalArea),
+		HouseAvgPricePerM2:   priceRatio(d.HousePrice, d.HouseArea),
+		AvgPricePerUnit:      priceRatio(d.TotalPrice, d.TotalCount),
+		HouseAvgPricePerUnit: priceRatio(d.HousePrice, d.HouseCount),
+		AvgUnitSize:          ratio(d.TotalArea, d.TotalCount),
+		HouseAvgUnitSize:     ratio(d.HouseArea, d.HouseCount),
+		HouseAreaShare:       ratio(d.HouseArea*100, d.TotalArea),
+		HouseCountShare:      ratio(d.HouseCount*100, d.TotalCount),
+	}
+}
+
+// deriveMonth computes the derived metrics of m; months carry no prices
+func deriveMonth(m MonthData) *DerivedMetrics {
+	return deriveDaily(DailyData{
+		TotalCount: m.TotalCount,
+		TotalArea:  m.TotalArea,
+		HouseCount: m.HouseCount,
+		HouseArea:  m.HouseArea,
+	})
+}
+
+// summarizePeriod totals records into a PeriodSummary
+func summarizePeriod(records []DailyHouseResp) PeriodSummary {
+	sum := aggregateDailyData(records)
+	return PeriodSummary{Days: len(records), Data: sum, Derived: deriveDaily(sum)}
+}
+
+// summarizeRecords summarizes daily and hourly records separately, as they
+// belong to different datasets
+func summarizeRecords(records []DailyHouseResp) map[string]PeriodSummary {
+	var daily, hourly []DailyHouseResp
+	for _, r := range records {
+		if isDayRecord(r.Day) {
+			daily = append(daily, r)
+		} else {
+			hourly = append(hourly, r)
+		}
+	}
+	summaries := make(map[string]PeriodSummary)
+	if len(daily) > 0 {
+		summaries["daily"] = summarizePeriod(daily)
+	}
+	if len(hourly) > 0 {
+		summaries["hourly"] = summarizePeriod(hourly)
+	}
+	return summaries
+}
+
+// legacyResponse reports whether the client asked for the response shape
+// without derived metrics (legacy=1)
+func legacyResponse(c *gin.Context) bool {
+	legacy := c.Query("legacy")
+	return legacy == "1" || legacy == "true"
+}
+
+// withDerived returns v, a house record, a list of them or a gin.H holding
+// them, with the derived metrics and the calendar day type filled in. It
+// returns v unchanged for the legacy shape.
+func withDerived(c *gin.Context, v interface{}) interface{} {
+	if legacyResponse(c) {
+		return v
+	}
+	return addDerived(v)
+}
+
+func addDerived(v interface{}) interface{} {
+	switch t := v.(type) {
+	case DailyHouseResp:
+		return deriveRecord(t)
+	case []DailyHouseResp:
+		derived := make([]DailyHouseResp, len(t))
+		for i, d := range t {
+			derived[i] = deriveRecord(d)
+		}
+		return derived
+	case MonthHouseResp:
+		t.Derived = deriveMonth(t.MonthData)
+		return t
+	case HouseAggregate:
+		t.Derived = deriveDaily(t.Data)
+		return t
+	case gin.H:
+		derived := make(gin.H, len(t))
+		for k, item := range t {
+			derived[k] = addDerived(item)
+		}
+		return derived
+	}
+	return v
+}
+
+func deriveRecord(d DailyHouseResp) DailyHouseResp {
+	d.Derived = deriveDaily(d.DailyData)
+	if info := dayInfo(d.Day); info != nil {
+		d.DayType, d.Holiday = info.Type, info.Holiday
+	}
+	return d
+}
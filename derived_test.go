@@ -0,0 +1,41 @@
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestDeriveDaily(t *testing.T) {
+	d := deriveDaily(DailyData{TotalCount: 200, TotalArea: 18000, HouseCount: 150, HouseArea: 13500, TotalPrice: 9000000})
+	if d.AvgPricePerM2 == nil || *d.AvgPricePerM2 != 500 {
+		t.Errorf("avg_price_per_m2 = %v, want 500", d.AvgPricePerM2)
+	}
+	if d.HouseAvgPricePerM2 != nil {
+		t.Errorf("house_avg_price_per_m2 should be null without a house price, got %v", *d.HouseAvgPricePerM2)
+	}
+	if *d.AvgUnitSize != 90 || *d.HouseAreaShare != 75 || *d.HouseCountShare != 75 {
+		t.Errorf("unexpected derived metrics %+v", d)
+	}
+	if empty := deriveDaily(DailyData{}); empty.AvgUnitSize != nil || empty.HouseAreaShare != nil {
+		t.Errorf("expected null metrics for empty data, got %+v", empty)
+	}
+}
+
+func TestWithDerivedLegacy(t *testing.T) {
+	record := DailyHouseResp{Day: "2025-06-09", DailyData: DailyData{TotalCount: 2, TotalArea: 180}}
+
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest("GET", "/v1/house_range", nil)
+	body := withDerived(c, gin.H{"data": []DailyHouseResp{record}}).(gin.H)
+	if got := body["data"].([]DailyHouseResp)[0].Derived; got == nil || *got.AvgUnitSize != 90 {
+		t.Errorf("expected derived metrics, got %+v", got)
+	}
+
+	c, _ = gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest("GET", "/v1/house_range?legacy=1", nil)
+	if got := withDerived(c, record).(DailyHouseResp); got.Derived != nil {
+		t.Error("legacy responses must not carry derived metrics")
+	}
+}
@@ -0,0 +1,428 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+)
+
+// District keys
+const (
+	HouseDistrictsKeyPrefix    = "house:districts"     // house:districts:{region}:{day}, every district of a day
+	HouseDistrictKeyPrefix     = "house:district"      // house:district:{region}:{district}:{day}
+	HouseDistrictDaysKeyPrefix = "house:district_days" // Sorted sets of days with district data, per region and per district
+)
+
+const (
+	maxDistrictNameLength = 32
+	// districtSumTolerance is the relative difference allowed between the sum
+	// of the districts and the city total, for rounded areas
+	districtSumTolerance = 0.005
+)
+
+// districtSumFields are the fields whose district values must add up to the
+// city total; prices are not always published per district
+var districtSumFields = []string{"total_count", "total_area", "house_count", "house_area"}
+
+// DistrictDay is the record of one district on one day
+type DistrictDay struct {
+	Day       string          `json:"day"`
+	DailyData DailyData       `json:"daily_data"`
+	Derived   *DerivedMetrics `json:"derived,omitempty"`
+}
+
+// DistrictRank is a district of a ranking with its total over the range
+type DistrictRank struct {
+	Rank     int      `json:"rank"`
+	District string   `json:"district"`
+	Value    float64  `json:"value"`
+	Share    *float64 `json:"share"` // percent of the sum of all districts
+	Days     int      `json:"days"`
+}
+
+// checkDistricts validates the district breakdown of a daily record: names
+// are usable in keys, every district is consistent and the districts add up
+// to the city total d
+func checkDistricts(d DailyData, districts map[string]DailyData) []FieldError {
+	if len(districts) == 0 {
+		return nil
+	}
+	var errs []FieldError
+	sums := make(map[string]float64)
+	for name, dd := range districts {
+		if name == "" || strings.ContainsAny(name, ": ") || utf8.RuneCountInString(name) > maxDistrictNameLength {
+			errs = append(errs, FieldError{Field: "districts", Rule: "name", Message: fmt.Sprintf("invalid district name %q", name)})
+			continue
+		}
+		errs = append(errs, checkDailyData("districts."+name, dd)...)
+		for field, v := range dd.Metrics() {
+			sums[field] += v
+		}
+	}
+	city := d.Metrics()
+	for _, field := range districtSumFields {
+		if math.Abs(sums[field]-city[field]) > districtSumTolerance*math.Max(city[field], 1) {
+			errs = append(errs, FieldError{
+				Field:   "districts",
+				Rule:    "sum",
+				Message: fmt.Sprintf("%s of the districts (%s) does not match the city total (%s)", field, formatNumber(sums[field]), formatNumber(city[field])),
+			})
+		}
+	}
+	return errs
+}
+
+func formatNumber(v float64) string {
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
+
+// mapDistricts maps the posted figures of every district like the city ones
+func mapDistricts(districts map[string]DailyData, mapData func(DailyData) DailyData) map[string]DailyData {
+	if districts == nil {
+		return nil
+	}
+	mapped := make(map[string]DailyData, len(districts))
+	for name, d := range districts {
+		mapped[name] = mapData(d)
+	}
+	return mapped
+}
+
+// storeDistricts replaces the districts of a day: the whole breakdown, one
+// record per district and the day indexes. The districts of the previous
+// breakdown left out are removed, all of them when districts is empty.
+func storeDistricts(ctx context.Context, region, day string, t time.Time, districts map[string]DailyData) error {
+	s := storageFor(ctx)
+	previous, _, err := GetDistricts(ctx, region, day)
+	if err != nil {
+		return err
+	}
+	for name := range previous {
+		if _, ok := districts[name]; ok {
+			continue
+		}
+		if err := s.Del(ctx, formatDistrictKey(region, name, day)); err != nil {
+			return err
+		}
+		if err := s.ZRem(ctx, formatDistrictDaysKey(region, name), day); err != nil {
+			return err
+		}
+	}
+	if len(districts) == 0 {
+		if previous == nil {
+			return nil
+		}
+		if err := s.Del(ctx, formatDistrictsKey(region, day)); err != nil {
+			return err
+		}
+		return s.ZRem(ctx, formatDistrictDaysKey(region, ""), day)
+	}
+
+	jsonData, err := json.Marshal(districts)
+	if err != nil {
+		return err
+	}
+	if err := s.Set(ctx, formatDistrictsKey(region, day), jsonData); err != nil {
+		return err
+	}
+	score := float64(t.Unix())
+	if err := s.ZAdd(ctx, formatDistrictDaysKey(region, ""), score, day); err != nil {
+		return err
+	}
+	for name, d := range districts {
+		jsonData, err := json.Marshal(d)
+		if err != nil {
+			return err
+		}
+		if err := s.Set(ctx, formatDistrictKey(region, name, day), jsonData); err != nil {
+			return err
+		}
+		if err := s.ZAdd(ctx, formatDistrictDaysKey(region, name), score, day); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetDistricts returns every district of a day
+func GetDistricts(ctx context.Context, region, day string) (map[string]DailyData, bool, error) {
+	jsonData, found, err := storageFor(ctx).Get(ctx, formatDistrictsKey(region, day))
+	if err != nil || !found {
+		return nil, false, err
+	}
+	var districts map[string]DailyData
+	if err := json.Unmarshal([]byte(jsonData), &districts); err != nil {
+		return nil, false, err
+	}
+	return districts, true, nil
+}
+
+// GetDistrictSeries returns the records of a district between from and to
+func GetDistrictSeries(ctx context.Context, region, district string, from, to time.Time) ([]DistrictDay, error) {
+	s := storageFor(ctx)
+	days, err := s.ZRangeByScore(ctx, formatDistrictDaysKey(region, district), float64(from.Unix()), float64(to.Unix()))
+	if err != nil {
+		return nil, err
+	}
+	series := make([]DistrictDay, 0, len(days))
+	for _, day := range days {
+		jsonData, found, err := s.Get(ctx, formatDistrictKey(region, district, day))
+		if err != nil {
+			return nil, err
+		}
+		if !found {
+			continue
+		}
+		var d DailyData
+		if err := json.Unmarshal([]byte(jsonData), &d); err != nil {
+			return nil, err
+		}
+		series = append(series, DistrictDay{Day: day, DailyData: d})
+	}
+	return series, nil
+}
+
+// getDistricts returns every district of a day (default the latest day with
+// district data), sorted by name
+func getDistricts(c *gin.Context) {
+	region := requestRegion(c)
+	day := c.Query("day")
+	if day == "" {
+		latest, err := latestDistrictDay(ctx, region)
+		if err != nil {
+			log.Logger.Error().Err(err).Str("region", region).Msg("Failed to get latest district day")
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get district data"})
+			return
+		}
+		day = latest
+	} else if _, err := parseDay(day); err != nil {
+		respondValidationError(c, []FieldError{{Field: "day", Rule: "date", Message: "must be a valid date in YYYY-MM-DD or YYYY-MM-DD-HH format"}})
+		return
+	}
+
+	districts, found, err := GetDistricts(ctx, region, day)
+	if err != nil {
+		log.Logger.Error().Err(err).Str("day", day).Str("region", region).Msg("Failed to get district data")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get district data"})
+		return
+	}
+	if !found {
+		c.JSON(http.StatusNotFound, gin.H{"msg": "data not found"})
+		return
+	}
+
+	names := make([]string, 0, len(districts))
+	for name := range districts {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	list := make([]gin.H, len(names))
+	for i, name := range names {
+		list[i] = gin.H{"district": name, "daily_data": districts[name]}
+		if !legacyResponse(c) {
+			list[i]["derived"] = deriveDaily(districts[name])
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{"region": region, "day": day, "districts": list})
+}
+
+// getDistrict returns the records of one district between from and to
+// (default the last 30 days), or of one day
+func getDistrict(c *gin.Context) {
+	region := requestRegion(c)
+	district := c.Param("district")
+	from, to, ok := districtRange(c, 30)
+	if !ok {
+		return
+	}
+
+	series, err := GetDistrictSeries(ctx, region, district, from, to)
+	if err != nil {
+		log.Logger.Error().Err(err).Str("district", district).Str("region", region).Msg("Failed to get district data")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get district data"})
+		return
+	}
+	if len(series) == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"msg": "no data found for the specified range"})
+		return
+	}
+	if !legacyResponse(c) {
+		for i := range series {
+			series[i].Derived = deriveDaily(series[i].DailyData)
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"region":   region,
+		"district": district,
+		"from":     from.Format(dayLayout),
+		"to":       to.Format(dayLayout),
+		"data":     series,
+	})
+}
+
+// getDistrictRankings ranks the districts by metric (default total_count)
+// summed over the daily records between from and to (default the last 30
+// days), or of one day
+func getDistrictRankings(c *gin.Context) {
+	region := requestRegion(c)
+	metric := c.DefaultQuery("metric", "total_count")
+	n, nErr := strconv.Atoi(c.DefaultQuery("n", strconv.Itoa(defaultRankingSize)))
+
+	var errs []FieldError
+	if !slices.Contains(houseMetrics, metric) {
+		errs = append(errs, FieldError{Field: "metric", Rule: "oneof", Message: "must be one of " + strings.Join(houseMetrics, ", ")})
+	}
+	if nErr != nil || n < 1 || n > maxRankingSize {
+		errs = append(errs, FieldError{Field: "n", Rule: "range", Message: fmt.Sprintf("must be between 1 and %d", maxRankingSize)})
+	}
+	if len(errs) > 0 {
+		respondValidationError(c, errs)
+		return
+	}
+	from, to, ok := districtRange(c, 30)
+	if !ok {
+		return
+	}
+
+	days, err := storageFor(ctx).ZRangeByScore(ctx, formatDistrictDaysKey(region, ""), float64(from.Unix()), float64(to.Unix()))
+	if err != nil {
+		log.Logger.Error().Err(err).Str("region", region).Msg("Failed to get district days")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get district data"})
+		return
+	}
+	totals := make(map[string]float64)
+	counts := make(map[string]int)
+	for _, day := range days {
+		if !isDayRecord(day) {
+			continue
+		}
+		districts, found, err := GetDistricts(ctx, region, day)
+		if err != nil {
+			log.Logger.Error().Err(err).Str("day", day).Str("region", region).Msg("Failed to get district data")
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get district data"})
+			return
+		}
+		if !found {
+			continue
+		}
+		for name, d := range districts {
+			v, _ := d.Metric(metric)
+			totals[name] += v
+			counts[name]++
+		}
+	}
+	if len(totals) == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"msg": "no data found for the specified range"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"region":   region,
+		"metric":   metric,
+		"from":     from.Format(dayLayout),
+		"to":       to.Format(dayLayout),
+		"rankings": rankDistricts(totals, counts, n),
+	})
+}
+
+// rankDistricts orders districts by their total, highest first; ties go to
+// the name
+func rankDistricts(totals map[string]float64, counts map[string]int, n int) []DistrictRank {
+	names := make([]string, 0, len(totals))
+	var sum float64
+	for name, v := range totals {
+		names = append(names, name)
+		sum += v
+	}
+	sort.Slice(names, func(i, j int) bool {
+		a, b := totals[names[i]], totals[names[j]]
+		if a != b {
+			return a > b
+		}
+		return names[i] < names[j]
+	})
+	if len(names) > n {
+		names = names[:n]
+	}
+	ranks := make([]DistrictRank, len(names))
+	for i, name := range names {
+		ranks[i] = DistrictRank{
+			Rank:     i + 1,
+			District: name,
+			Value:    round2(totals[name]),
+			Share:    ratio(totals[name]*100, sum),
+			Days:     counts[name],
+		}
+	}
+	return ranks
+}
+
+// districtRange parses the from and to days of a district query (or a single
+// day), defaulting to the last days days; it answers the request and returns
+// false when they are invalid
+func districtRange(c *gin.Context, days int) (time.Time, time.Time, bool) {
+	toParam := c.DefaultQuery("to", getTodayDay())
+	if day, ok := c.GetQuery("day"); ok {
+		toParam = day
+	}
+	to, toErr := time.Parse(dayLayout, toParam)
+	fromParam := c.DefaultQuery("from", to.AddDate(0, 0, -(days-1)).Format(dayLayout))
+	if day, ok := c.GetQuery("day"); ok {
+		fromParam = day
+	}
+	errs := checkDate("from", fromParam, dayLayout)
+	if toErr != nil {
+		errs = append(errs, checkDate("to", toParam, dayLayout)...)
+	}
+	if len(errs) > 0 {
+		respondValidationError(c, errs)
+		return time.Time{}, time.Time{}, false
+	}
+	from, _ := time.Parse(dayLayout, fromParam)
+	if err := validateRange(from, to); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return time.Time{}, time.Time{}, false
+	}
+	return from, to.Add(24*time.Hour - time.Second), true
+}
+
+// latestDistrictDay returns the latest day with district data within
+// max_range_days, or "" when there is none
+func latestDistrictDay(ctx context.Context, region string) (string, error) {
+	today, _ := time.Parse(dayLayout, getTodayDay())
+	to := today.Add(24*time.Hour - time.Second)
+	from := today.AddDate(0, 0, -appConfig.MaxRangeDays)
+	days, err := storageFor(ctx).ZRangeByScore(ctx, formatDistrictDaysKey(region, ""), float64(from.Unix()), float64(to.Unix()))
+	if err != nil || len(days) == 0 {
+		return "", err
+	}
+	return days[len(days)-1], nil
+}
+
+func formatDistrictsKey(region, day string) string {
+	return fmt.Sprintf("%s:%s:%s", HouseDistrictsKeyPrefix, region, day)
+}
+
+func formatDistrictKey(region, district, day string) string {
+	return fmt.Sprintf("%s:%s:%s:%s", HouseDistrictKeyPrefix, region, district, day)
+}
+
+// formatDistrictDaysKey returns the day index of a district, or of the
+// region when district is empty
+func formatDistrictDaysKey(region, district string) string {
+	if district == "" {
+		return fmt.Sprintf("%s:%s", HouseDistrictDaysKeyPrefix, region)
+	}
+	return fmt.Sprintf("%s:%s:%s", HouseDistrictDaysKeyPrefix, region, district)
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCheckDistricts(t *testing.T) {
+	city := DailyData{TotalCount: 100, TotalArea: 9000, HouseCount: 80, HouseArea: 7000}
+	districts := map[string]DailyData{
+		"朝阳": {TotalCount: 60, TotalArea: 5400, HouseCount: 50, HouseArea: 4400},
+		"海淀": {TotalCount: 40, TotalArea: 3600, HouseCount: 30, HouseArea: 2600},
+	}
+	if errs := checkDistricts(city, districts); len(errs) != 0 {
+		t.Errorf("unexpected errors %+v", errs)
+	}
+
+	districts["海淀"] = DailyData{TotalCount: 30, TotalArea: 3600, HouseCount: 30, HouseArea: 2600}
+	errs := checkDistricts(city, districts)
+	if len(errs) != 1 || errs[0].Rule != "sum" {
+		t.Errorf("expected a sum error, got %+v", errs)
+	}
+
+	errs = checkDistricts(city, map[string]DailyData{"a:b": {}})
+	if len(errs) == 0 || errs[0].Rule != "name" {
+		t.Errorf("expected a name error, got %+v", errs)
+	}
+}
+
+func TestRankDistricts(t *testing.T) {
+	totals := map[string]float64{"朝阳": 30, "海淀": 50, "东城": 30, "西城": 0}
+	counts := map[string]int{"朝阳": 2, "海淀": 3, "东城": 1, "西城": 1}
+	ranks := rankDistricts(totals, counts, 3)
+	if len(ranks) != 3 || ranks[0].District != "海淀" || ranks[0].Days != 3 {
+		t.Fatalf("unexpected ranking %+v", ranks)
+	}
+	// Ties are ordered by name
+	if ranks[1].District != "东城" || ranks[2].District != "朝阳" || ranks[2].Rank != 3 {
+		t.Errorf("unexpected tie order %+v", ranks)
+	}
+	if ranks[0].Share == nil || *ranks[0].Share != 45.45 {
+		t.Errorf("unexpected share %v", ranks[0].Share)
+	}
+}
+
+func TestStoreDistrictsOverwrite(t *testing.T) {
+	useTestStorage(t)
+	city := DailyData{TotalCount: 100, TotalArea: 9000, HouseCount: 80, HouseArea: 7000}
+	half := DailyData{TotalCount: 50, TotalArea: 4500, HouseCount: 40, HouseArea: 3500}
+	store := func(districts map[string]DailyData) {
+		t.Helper()
+		data := DailyHouseResp{Day: "2025-06-09", DailyData: city, Districts: districts}
+		if err := StoreHouseData(ctx, data.Day, data, beijingKey); err != nil {
+			t.Fatal(err)
+		}
+	}
+	day := time.Date(2025, 6, 9, 0, 0, 0, 0, time.UTC)
+	series := func(district string) int {
+		t.Helper()
+		s, err := GetDistrictSeries(ctx, beijingKey, district, day, day.Add(24*time.Hour))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return len(s)
+	}
+
+	store(map[string]DailyData{"朝阳": half, "海淀": half})
+	// Renaming a district drops the old one
+	store(map[string]DailyData{"朝阳": half, "东城": half})
+	districts, found, err := GetDistricts(ctx, beijingKey, "2025-06-09")
+	if err != nil || !found || len(districts) != 2 || districts["东城"] != half {
+		t.Fatalf("unexpected breakdown %v %v %v", districts, found, err)
+	}
+	if series("海淀") != 0 || series("东城") != 1 {
+		t.Error("the dropped district should be removed from its index")
+	}
+	if _, found, _ := storage.Get(ctx, formatDistrictKey(beijingKey, "海淀", "2025-06-09")); found {
+		t.Error("the dropped district record should be deleted")
+	}
+
+	// An overwrite without districts clears the breakdown
+	store(nil)
+	if _, found, _ := GetDistricts(ctx, beijingKey, "2025-06-09"); found {
+		t.Error("the breakdown should be cleared")
+	}
+	if series("朝阳") != 0 {
+		t.Error("every district should be removed from its index")
+	}
+	days, err := storage.ZRangeByScore(ctx, formatDistrictDaysKey(beijingKey, ""), float64(day.Unix()), float64(day.Unix()))
+	if err != nil || len(days) != 0 {
+		t.Errorf("the day should leave the region index, got %v (%v)", days, err)
+	}
+}
@@ -0,0 +1,423 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/LIUHUANUCAS/house/config"
+	"github.com/gin-gonic/gin"
+	"github.com/go-redis/redis/v8"
+	"github.com/rs/zerolog/log"
+)
+
+// Sources of record events
+const (
+	eventSourceAdd   = "add"   // add endpoints
+	eventSourceForce = "force" // /v1/force_house
+	eventSourceBulk  = "bulk"  // bulk imports
+)
+
+const (
+	// eventSeqKey is the Redis counter numbering events across instances
+	eventSeqKey = "house:events:seq"
+	// eventBufferSize is the number of events a stream may fall behind before
+	// it is closed; the client resumes with Last-Event-ID
+	eventBufferSize   = 64
+	eventPingInterval = 15 * time.Second
+)
+
+// RecordEvent is a record accepted by an add endpoint or a bulk import
+type RecordEvent struct {
+	ID      int64           `json:"id"`
+	Region  string          `json:"region"`
+	Dataset string          `json:"dataset"`
+	Day     string          `json:"day"` // Day, hour or month of the record
+	Source  string          `json:"source"`
+	Time    time.Time       `json:"time"`
+	Data    json.RawMessage `json:"data"`
+}
+
+// eventMessage is an event relayed through Redis pub/sub
+type eventMessage struct {
+	Instance string      `json:"instance"`
+	Event    RecordEvent `json:"event"`
+}
+
+// instanceID tells the events of this process apart from the ones relayed by
+// other instances
+var instanceID = randomHex(8)
+
+// eventFilter selects events by region and dataset; empty lists match all
+type eventFilter struct {
+	regions  []string
+	datasets []string
+}
+
+func (f eventFilter) match(e RecordEvent) bool {
+	return (len(f.regions) == 0 || slices.Contains(f.regions, e.Region)) &&
+		(len(f.datasets) == 0 || slices.Contains(f.datasets, e.Dataset))
+}
+
+type eventSubscriber struct {
+	filter eventFilter
+	ch     chan RecordEvent
+}
+
+// eventBroker keeps the latest events and fans them out to the streams
+type eventBroker struct {
+	mu          sync.Mutex
+	size        int
+	log         []RecordEvent
+	lastID      int64 // highest ID seen
+	evictedID   int64 // highest ID dropped from the log
+	seeded      bool  // whether evictedID accounts for the IDs numbered before the log started
+	subscribers map[*eventSubscriber]struct{}
+}
+
+// events is the broker of the process; InitEvents sizes it
+var events = newEventBroker(config.GetConfig().EventsConfig.LogSize)
+
+func newEventBroker(size int) *eventBroker {
+	return &eventBroker{size: size, seeded: true, subscribers: make(map[*eventSubscriber]struct{})}
+}
+
+// seed records that the events up to id were numbered before the log started
+// and can no longer be resumed
+func (b *eventBroker) seed(id int64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.seedLocked(id)
+}
+
+func (b *eventBroker) seedLocked(id int64) {
+	b.lastID = max(b.lastID, id)
+	b.evictedID = max(b.evictedID, id)
+	b.seeded = true
+}
+
+// publish numbers the events raised by this instance and delivers them, in
+// ID order under the broker lock. number reserves len(es) consecutive IDs and
+// returns the last one; without it the events are numbered after the highest
+// ID seen. When number fails the events are left unnumbered: they are streamed
+// live but cannot be resumed.
+func (b *eventBroker) publish(es []RecordEvent, number func(n int) (int64, error)) []RecordEvent {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	last := b.lastID + int64(len(es))
+	if number != nil {
+		var err error
+		if last, err = number(len(es)); err != nil {
+			log.Logger.Error().Err(err).Int("events", len(es)).Msg("Failed to number record events")
+			last = 0
+		}
+	}
+	for i := range es {
+		if last > 0 {
+			es[i].ID = last - int64(len(es)-1-i)
+		}
+		b.deliverLocked(es[i])
+	}
+	return es
+}
+
+// deliver delivers an event relayed from another instance
+func (b *eventBroker) deliver(e RecordEvent) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.deliverLocked(e)
+}
+
+// deliverLocked logs e in ID order, unless it is unnumbered, and sends it to
+// the matching streams. Streams too slow to take the event are closed.
+func (b *eventBroker) deliverLocked(e RecordEvent) {
+	if e.ID > 0 {
+		// Without a seed, the first numbered event bounds the IDs before it
+		if !b.seeded {
+			b.seedLocked(e.ID - 1)
+		}
+		b.lastID = max(b.lastID, e.ID)
+		// Relayed events may arrive after later local ones
+		i := len(b.log)
+		for i > 0 && b.log[i-1].ID > e.ID {
+			i--
+		}
+		b.log = slices.Insert(b.log, i, e)
+		if len(b.log) > b.size {
+			evicted := len(b.log) - b.size
+			for _, old := range b.log[:evicted] {
+				b.evictedID = max(b.evictedID, old.ID)
+			}
+			b.log = slices.Delete(b.log, 0, evicted)
+		}
+	}
+
+	for sub := range b.subscribers {
+		if !sub.filter.match(e) {
+			continue
+		}
+		select {
+		case sub.ch <- e:
+		default:
+			delete(b.subscribers, sub)
+			close(sub.ch)
+		}
+	}
+}
+
+// subscribe registers a stream. With resume set, it also returns the logged
+// events after lastID and whether some of them were already dropped, which
+// is assumed until the broker is seeded.
+func (b *eventBroker) subscribe(filter eventFilter, lastID int64, resume bool) (*eventSubscriber, []RecordEvent, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	sub := &eventSubscriber{filter: filter, ch: make(chan RecordEvent, eventBufferSize)}
+	b.subscribers[sub] = struct{}{}
+	if !resume {
+		return sub, nil, false
+	}
+	var backlog []RecordEvent
+	for _, e := range b.log {
+		if e.ID > lastID && filter.match(e) {
+			backlog = append(backlog, e)
+		}
+	}
+	return sub, backlog, lastID < b.evictedID || !b.seeded
+}
+
+// unsubscribe removes a stream unless it was already closed
+func (b *eventBroker) unsubscribe(sub *eventSubscriber) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, ok := b.subscribers[sub]; ok {
+		delete(b.subscribers, sub)
+		close(sub.ch)
+	}
+}
+
+// closeAll ends every stream, so that shutdown does not wait for them
+func (b *eventBroker) closeAll() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for sub := range b.subscribers {
+		delete(b.subscribers, sub)
+		close(sub.ch)
+	}
+}
+
+func (b *eventBroker) subscriberCount() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.subscribers)
+}
+
+// InitEvents sizes the event log and, with the Redis backend, relays events
+// between the instances until ctx is done
+func InitEvents(ctx context.Context, cfg *config.EventsConfig) {
+	events = newEventBroker(cfg.LogSize)
+	if redisClient == nil {
+		return
+	}
+
+	// The counter survives restarts but the log does not: the events numbered
+	// before this process started are reported as dropped
+	events.seeded = false
+	seq, err := redisClient.Get(ctx, eventSeqKey).Int64()
+	if err != nil && err != redis.Nil {
+		log.Logger.Error().Err(err).Msg("Failed to read the event counter; resumed streams report a gap until an event is numbered")
+	} else {
+		events.seed(seq)
+	}
+	go relayEvents(ctx, cfg.Channel)
+}
+
+// relayEvents delivers the events published by the other instances
+func relayEvents(ctx context.Context, channel string) {
+	pubsub := redisClient.Subscribe(ctx, channel)
+	defer pubsub.Close()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case msg, ok := <-pubsub.Channel():
+			if !ok {
+				return
+			}
+			var m eventMessage
+			if err := json.Unmarshal([]byte(msg.Payload), &m); err != nil {
+				log.Logger.Error().Err(err).Str("channel", channel).Msg("Failed to decode relayed event")
+				continue
+			}
+			if m.Instance != instanceID {
+				events.deliver(m.Event)
+			}
+		}
+	}
+}
+
+// newRecordEvent returns the event of a stored record
+func newRecordEvent(region, dataset, day, source string, data any) (RecordEvent, bool) {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		log.Logger.Error().Err(err).Str("day", day).Str("region", region).Msg("Failed to marshal record event")
+		return RecordEvent{}, false
+	}
+	return RecordEvent{Region: region, Dataset: dataset, Day: day, Source: source, Time: time.Now(), Data: payload}, true
+}
+
+// publishRecord streams a stored record and raises its webhook events
+func publishRecord(region, dataset, day, source string, data any) {
+	if e, ok := newRecordEvent(region, dataset, day, source, data); ok {
+		publishRecords([]RecordEvent{e})
+	}
+}
+
+// publishRecords streams stored records and raises their webhook events.
+// With the Redis backend the events are numbered by the shared counter and
+// relayed to the other instances, one round trip each for all of them;
+// events the counter cannot number are only streamed live. Without Redis
+// they are numbered locally.
+func publishRecords(es []RecordEvent) {
+	if len(es) == 0 {
+		return
+	}
+	for _, e := range es {
+		notifyRecord(e)
+	}
+	if redisClient == nil {
+		events.publish(es, nil)
+		return
+	}
+	es = events.publish(es, numberEvents)
+	if isDegraded() {
+		return
+	}
+
+	pipe := redisClient.Pipeline()
+	for _, e := range es {
+		msg, err := json.Marshal(eventMessage{Instance: instanceID, Event: e})
+		if err != nil {
+			log.Logger.Error().Err(err).Int64("id", e.ID).Msg("Failed to marshal relayed event")
+			continue
+		}
+		pipe.Publish(ctx, appConfig.EventsConfig.Channel, msg)
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		recordRedisError("publish", err)
+		log.Logger.Error().Err(err).Int("events", len(es)).Msg("Failed to relay record events")
+	}
+}
+
+// numberEvents reserves n consecutive IDs of the shared counter and returns
+// the last one
+func numberEvents(n int) (int64, error) {
+	if isDegraded() {
+		return 0, ErrStorageUnavailable
+	}
+	last, err := redisClient.IncrBy(ctx, eventSeqKey, int64(n)).Result()
+	if err != nil {
+		recordRedisError("incrby", err)
+	}
+	return last, err
+}
+
+// streamEvents streams record events as Server-Sent Events, filtered by the
+// region (of the path, or region=beijing,shanghai) and dataset (new, old,
+// monthly). Clients resuming with Last-Event-ID (or last_event_id) first get
+// the logged events they missed, preceded by a gap event when some were
+// already dropped from the log.
+func streamEvents(c *gin.Context) {
+	var filter eventFilter
+	var errs []FieldError
+	if region := c.Param("region"); region != "" {
+		filter.regions = []string{region}
+	} else if param := c.Query("region"); param != "" {
+		for _, code := range strings.Split(param, ",") {
+			if _, ok := lookupRegion(code); !ok {
+				errs = append(errs, FieldError{Field: "region", Rule: "oneof", Message: "must be among " + strings.Join(regionCodes(), ", ")})
+				break
+			}
+			filter.regions = append(filter.regions, code)
+		}
+	}
+	if param := c.Query("dataset"); param != "" {
+		datasets := []string{config.DatasetNew, config.DatasetOld, config.DatasetMonthly}
+		for _, name := range strings.Split(param, ",") {
+			if !slices.Contains(datasets, name) {
+				errs = append(errs, FieldError{Field: "dataset", Rule: "oneof", Message: "must be among " + strings.Join(datasets, ", ")})
+				break
+			}
+			filter.datasets = append(filter.datasets, name)
+		}
+	}
+	lastParam := c.GetHeader("Last-Event-ID")
+	if lastParam == "" {
+		lastParam = c.Query("last_event_id")
+	}
+	var lastID int64
+	if lastParam != "" {
+		var err error
+		if lastID, err = strconv.ParseInt(lastParam, 10, 64); err != nil || lastID < 0 {
+			errs = append(errs, FieldError{Field: "last_event_id", Rule: "number", Message: "must be a non-negative event id"})
+		}
+	}
+	if len(errs) > 0 {
+		respondValidationError(c, errs)
+		return
+	}
+
+	sub, backlog, gap := events.subscribe(filter, lastID, lastParam != "")
+	defer events.unsubscribe(sub)
+
+	w := c.Writer
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	if gap {
+		fmt.Fprintf(w, "event: gap\ndata: {\"last_event_id\":%d}\n\n", lastID)
+	}
+	for _, e := range backlog {
+		writeEvent(w, e)
+	}
+	w.Flush()
+
+	ping := time.NewTicker(eventPingInterval)
+	defer ping.Stop()
+	for {
+		select {
+		case <-c.Request.Context().Done():
+			return
+		case <-ping.C:
+			fmt.Fprint(w, ": ping\n\n")
+		case e, ok := <-sub.ch:
+			if !ok {
+				// Too slow or shutting down; the client resumes from its last id
+				return
+			}
+			writeEvent(w, e)
+		}
+		w.Flush()
+	}
+}
+
+// writeEvent writes e; unnumbered events have no id, so that the client keeps
+// resuming from the last numbered one
+func writeEvent(w gin.ResponseWriter, e RecordEvent) {
+	data, err := json.Marshal(e)
+	if err != nil {
+		log.Logger.Error().Err(err).Int64("id", e.ID).Msg("Failed to marshal record event")
+		return
+	}
+	if e.ID > 0 {
+		fmt.Fprintf(w, "id: %d\n", e.ID)
+	}
+	fmt.Fprintf(w, "event: record\ndata: %s\n\n", data)
+}
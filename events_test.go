@@ -0,0 +1,111 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestEventBrokerResume(t *testing.T) {
+	b := newEventBroker(3)
+	for _, region := range []string{"beijing", "shanghai", "beijing", "beijing"} {
+		b.publish([]RecordEvent{{Region: region, Dataset: "old"}}, nil)
+	}
+
+	// Events 1 to 4 were delivered; the log keeps 2 to 4
+	sub, backlog, gap := b.subscribe(eventFilter{regions: []string{"beijing"}}, 2, true)
+	defer b.unsubscribe(sub)
+	if gap {
+		t.Error("no event after 2 was dropped")
+	}
+	if len(backlog) != 2 || backlog[0].ID != 3 || backlog[1].ID != 4 {
+		t.Errorf("unexpected backlog %+v", backlog)
+	}
+	if _, _, gap := b.subscribe(eventFilter{}, 0, true); !gap {
+		t.Error("event 1 was dropped from the log")
+	}
+
+	b.publish([]RecordEvent{{Region: "shanghai", Dataset: "new"}, {Region: "beijing", Dataset: "new"}}, nil)
+	if e := <-sub.ch; e.ID != 6 || e.Region != "beijing" {
+		t.Errorf("unexpected event %+v", e)
+	}
+}
+
+func TestEventBrokerDropsSlowStreams(t *testing.T) {
+	b := newEventBroker(eventBufferSize * 2)
+	sub, _, _ := b.subscribe(eventFilter{datasets: []string{"new"}}, 0, false)
+	for range eventBufferSize + 1 {
+		b.publish([]RecordEvent{{Region: "beijing", Dataset: "new"}}, nil)
+	}
+	if b.subscriberCount() != 0 {
+		t.Error("a stream that fell behind should be closed")
+	}
+	n := 0
+	for range sub.ch {
+		n++
+	}
+	if n != eventBufferSize {
+		t.Errorf("got %d buffered events, want %d", n, eventBufferSize)
+	}
+	b.unsubscribe(sub) // already closed
+}
+
+func TestEventBrokerNumbering(t *testing.T) {
+	b := newEventBroker(10)
+	es := b.publish([]RecordEvent{{Region: "beijing"}, {Region: "shanghai"}}, func(n int) (int64, error) {
+		return 7 + int64(n), nil
+	})
+	if es[0].ID != 8 || es[1].ID != 9 {
+		t.Errorf("want IDs 8 and 9, got %d and %d", es[0].ID, es[1].ID)
+	}
+
+	// A relayed event numbered before the local ones is logged in ID order
+	b.deliver(RecordEvent{ID: 5, Region: "beijing"})
+	_, backlog, _ := b.subscribe(eventFilter{}, 0, true)
+	if len(backlog) != 3 || backlog[0].ID != 5 || backlog[2].ID != 9 {
+		t.Errorf("unexpected backlog %+v", backlog)
+	}
+
+	// Events the counter cannot number are streamed but not logged
+	sub, _, _ := b.subscribe(eventFilter{}, 0, false)
+	es = b.publish([]RecordEvent{{Region: "beijing"}}, func(int) (int64, error) {
+		return 0, errors.New("unavailable")
+	})
+	if es[0].ID != 0 {
+		t.Errorf("want an unnumbered event, got %d", es[0].ID)
+	}
+	if e := <-sub.ch; e.ID != 0 {
+		t.Errorf("unexpected event %+v", e)
+	}
+	if _, backlog, _ := b.subscribe(eventFilter{}, 9, true); len(backlog) != 0 {
+		t.Errorf("unnumbered events should not be resumable, got %+v", backlog)
+	}
+}
+
+func TestEventBrokerSeed(t *testing.T) {
+	// Events numbered before a restart are not in the log
+	b := newEventBroker(10)
+	b.seed(100)
+	if _, _, gap := b.subscribe(eventFilter{}, 50, true); !gap {
+		t.Error("events 51 to 100 were numbered before the log started")
+	}
+	if _, _, gap := b.subscribe(eventFilter{}, 100, true); gap {
+		t.Error("no event after 100 was dropped")
+	}
+	if es := b.publish([]RecordEvent{{Region: "beijing"}}, nil); es[0].ID != 101 {
+		t.Errorf("want ID 101, got %d", es[0].ID)
+	}
+
+	// Unseeded, every resume reports a gap until an event is numbered
+	b = newEventBroker(10)
+	b.seeded = false
+	if _, _, gap := b.subscribe(eventFilter{}, 50, true); !gap {
+		t.Error("an unseeded broker cannot tell which events were dropped")
+	}
+	b.deliver(RecordEvent{ID: 80, Region: "beijing"})
+	if _, backlog, gap := b.subscribe(eventFilter{}, 79, true); gap || len(backlog) != 1 {
+		t.Errorf("unexpected backlog %+v (gap %v)", backlog, gap)
+	}
+	if _, _, gap := b.subscribe(eventFilter{}, 50, true); !gap {
+		t.Error("events 51 to 79 were numbered before the log started")
+	}
+}
@@ -0,0 +1,427 @@
+package main
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/csv"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// maxExportDays bounds an export; ranges are read one max_range_days
+	// window at a time
+	maxExportDays = 3660
+	// utf8BOM lets Excel detect the encoding of the Chinese headers
+	utf8BOM = "\ufeff"
+
+	exportDailySheet   = "日数据"
+	exportMonthlySheet = "月数据"
+)
+
+var (
+	dailyExportHeaders   = []string{"日期", "总成交套数", "总成交面积(㎡)", "住宅成交套数", "住宅成交面积(㎡)", "住宅成交金额", "总成交金额"}
+	monthlyExportHeaders = []string{"月份", "总成交套数", "总成交面积(㎡)", "住宅成交套数", "住宅成交面积(㎡)"}
+	derivedExportHeaders = []string{
+		"平均单价(每㎡)", "住宅平均单价(每㎡)", "套均总价", "住宅套均总价",
+		"套均面积(㎡)", "住宅套均面积(㎡)", "住宅面积占比(%)", "住宅套数占比(%)",
+	}
+)
+
+// exportSheet receives the rows of an export. Cells are strings, float64 or
+// *float64 (nil for an empty cell).
+type exportSheet interface {
+	WriteRow(cells []any) error
+}
+
+func exportHeaders(headers []string, derived bool) []any {
+	if derived {
+		headers = append(headers[:len(headers):len(headers)], derivedExportHeaders...)
+	}
+	cells := make([]any, len(headers))
+	for i, h := range headers {
+		cells[i] = h
+	}
+	return cells
+}
+
+func derivedCells(m *DerivedMetrics) []any {
+	return []any{
+		m.AvgPricePerM2, m.HouseAvgPricePerM2, m.AvgPricePerUnit, m.HouseAvgPricePerUnit,
+		m.AvgUnitSize, m.HouseAvgUnitSize, m.HouseAreaShare, m.HouseCountShare,
+	}
+}
+
+func dailyExportRow(r DailyHouseResp, derived bool) []any {
+	d := r.DailyData
+	cells := []any{r.Day, d.TotalCount, d.TotalArea, d.HouseCount, d.HouseArea, d.HousePrice, d.TotalPrice}
+	if derived {
+		cells = append(cells, derivedCells(deriveDaily(d))...)
+	}
+	return cells
+}
+
+func monthlyExportRow(r MonthHouseResp, derived bool) []any {
+	m := r.MonthData
+	cells := []any{r.Month, m.TotalCount, m.TotalArea, m.HouseCount, m.HouseArea}
+	if derived {
+		cells = append(cells, derivedCells(deriveMonth(m))...)
+	}
+	return cells
+}
+
+// formatExportCell formats a numeric cell without exponent or trailing zeros;
+// ok is false for an empty cell
+func formatExportCell(cell any) (string, bool) {
+	switch v := cell.(type) {
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), true
+	case *float64:
+		if v == nil {
+			return "", false
+		}
+		return strconv.FormatFloat(*v, 'f', -1, 64), true
+	default:
+		return fmt.Sprint(v), true
+	}
+}
+
+// csvSheet writes rows as CSV
+type csvSheet struct {
+	w *csv.Writer
+}
+
+func (s *csvSheet) WriteRow(cells []any) error {
+	record := make([]string, len(cells))
+	for i, cell := range cells {
+		record[i], _ = formatExportCell(cell)
+	}
+	return s.w.Write(record)
+}
+
+// xlsxWriter writes a workbook as a stream: the package parts listing the
+// sheets come first, then each sheet as its rows are produced. Strings are
+// stored inline so nothing has to be buffered.
+type xlsxWriter struct {
+	zw     *zip.Writer
+	sheets int
+	next   int
+}
+
+const (
+	xlsxMainNS = "http://schemas.openxmlformats.org/spreadsheetml/2006/main"
+	xlsxRelNS  = "http://schemas.openxmlformats.org/officeDocument/2006/relationships"
+	xlsxPkgNS  = "http://schemas.openxmlformats.org/package/2006/relationships"
+)
+
+// newXLSXWriter writes the workbook parts for the named sheets to w
+func newXLSXWriter(w io.Writer, sheets ...string) (*xlsxWriter, error) {
+	var types, workbook, rels strings.Builder
+	types.WriteString(xml.Header + `<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">` +
+		`<Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/>` +
+		`<Default Extension="xml" ContentType="application/xml"/>` +
+		`<Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/>`)
+	workbook.WriteString(xml.Header + `<workbook xmlns="` + xlsxMainNS + `" xmlns:r="` + xlsxRelNS + `"><sheets>`)
+	rels.WriteString(xml.Header + `<Relationships xmlns="` + xlsxPkgNS + `">`)
+	for i, name := range sheets {
+		n := i + 1
+		fmt.Fprintf(&types, `<Override PartName="/xl/worksheets/sheet%d.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/>`, n)
+		fmt.Fprintf(&workbook, `<sheet name="%s" sheetId="%d" r:id="rId%d"/>`, xmlEscape(name), n, n)
+		fmt.Fprintf(&rels, `<Relationship Id="rId%d" Type="%s/worksheet" Target="worksheets/sheet%d.xml"/>`, n, xlsxRelNS, n)
+	}
+	types.WriteString(`</Types>`)
+	workbook.WriteString(`</sheets></workbook>`)
+	rels.WriteString(`</Relationships>`)
+
+	x := &xlsxWriter{zw: zip.NewWriter(w), sheets: len(sheets)}
+	parts := []struct{ name, content string }{
+		{"[Content_Types].xml", types.String()},
+		{"_rels/.rels", xml.Header + `<Relationships xmlns="` + xlsxPkgNS + `"><Relationship Id="rId1" Type="` + xlsxRelNS + `/officeDocument" Target="xl/workbook.xml"/></Relationships>`},
+		{"xl/workbook.xml", workbook.String()},
+		{"xl/_rels/workbook.xml.rels", rels.String()},
+	}
+	for _, part := range parts {
+		f, err := x.zw.Create(part.name)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := io.WriteString(f, part.content); err != nil {
+			return nil, err
+		}
+	}
+	return x, nil
+}
+
+// nextSheet starts the next sheet; call its close function before starting
+// another one
+func (x *xlsxWriter) nextSheet() (*xlsxSheet, error) {
+	if x.next == x.sheets {
+		return nil, fmt.Errorf("workbook has only %d sheets", x.sheets)
+	}
+	x.next++
+	f, err := x.zw.Create(fmt.Sprintf("xl/worksheets/sheet%d.xml", x.next))
+	if err != nil {
+		return nil, err
+	}
+	if _, err := io.WriteString(f, xml.Header+`<worksheet xmlns="`+xlsxMainNS+`"><sheetData>`); err != nil {
+		return nil, err
+	}
+	return &xlsxSheet{w: f}, nil
+}
+
+// Close finishes the workbook; sheets not started are left empty
+func (x *xlsxWriter) Close() error {
+	for x.next < x.sheets {
+		sheet, err := x.nextSheet()
+		if err != nil {
+			return err
+		}
+		if err := sheet.close(); err != nil {
+			return err
+		}
+	}
+	return x.zw.Close()
+}
+
+// xlsxSheet writes the rows of a worksheet
+type xlsxSheet struct {
+	w   io.Writer
+	row int
+}
+
+func (s *xlsxSheet) WriteRow(cells []any) error {
+	s.row++
+	var b strings.Builder
+	fmt.Fprintf(&b, `<row r="%d">`, s.row)
+	for i, cell := range cells {
+		ref := xlsxColumn(i) + strconv.Itoa(s.row)
+		value, ok := formatExportCell(cell)
+		switch {
+		case !ok:
+			continue
+		case isNumericCell(cell):
+			fmt.Fprintf(&b, `<c r="%s"><v>%s</v></c>`, ref, value)
+		default:
+			fmt.Fprintf(&b, `<c r="%s" t="inlineStr"><is><t>%s</t></is></c>`, ref, xmlEscape(value))
+		}
+	}
+	b.WriteString(`</row>`)
+	_, err := io.WriteString(s.w, b.String())
+	return err
+}
+
+func (s *xlsxSheet) close() error {
+	_, err := io.WriteString(s.w, `</sheetData></worksheet>`)
+	return err
+}
+
+func isNumericCell(cell any) bool {
+	switch cell.(type) {
+	case float64, *float64:
+		return true
+	}
+	return false
+}
+
+// xlsxColumn returns the column letters of a zero-based column index
+func xlsxColumn(i int) string {
+	name := ""
+	for i++; i > 0; i = (i - 1) / 26 {
+		name = string(rune('A'+(i-1)%26)) + name
+	}
+	return name
+}
+
+func xmlEscape(s string) string {
+	var b strings.Builder
+	_ = xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
+
+// exportDailyRows writes the daily records stored between from and to, one
+// max_range_days window at a time, calling flush after each window. Hourly and
+// new-house records are left out, and the export stops once ctx is done.
+func exportDailyRows(ctx context.Context, sheet exportSheet, region string, from, to time.Time, derived bool, flush func()) error {
+	window := time.Duration(appConfig.MaxRangeDays) * 24 * time.Hour
+	for start := from; !start.After(to); start = start.Add(window) {
+		end := start.Add(window - time.Second)
+		if end.After(to) {
+			end = to
+		}
+		days, err := GetHouseDaysInRange(ctx, start, end, region)
+		if err != nil {
+			return err
+		}
+		for _, day := range days {
+			if !isDayRecord(day) {
+				continue
+			}
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			record, found, err := GetHouseData(ctx, day, region)
+			if err != nil {
+				return err
+			}
+			if !found {
+				continue
+			}
+			if err := sheet.WriteRow(dailyExportRow(record, derived)); err != nil {
+				return err
+			}
+		}
+		flush()
+	}
+	return nil
+}
+
+// exportMonthlyRows writes the posted months from the month of from to the
+// month of to
+func exportMonthlyRows(ctx context.Context, sheet exportSheet, region string, from, to time.Time, derived bool) error {
+	for m := time.Date(from.Year(), from.Month(), 1, 0, 0, 0, 0, time.UTC); !m.After(to); m = m.AddDate(0, 1, 0) {
+		record, found, err := GetMonthHouseData(ctx, m.Format(monthLayout), region)
+		if err != nil {
+			return err
+		}
+		if !found {
+			continue
+		}
+		if err := sheet.WriteRow(monthlyExportRow(record, derived)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// exportHouse streams the records of a region between from and to (default
+// today) as CSV (format=csv, the default; dataset=daily or monthly) or as an
+// XLSX workbook with a daily and a monthly sheet (format=xlsx). derived=1 adds
+// the derived metrics. Errors after the first row can only be logged.
+func exportHouse(c *gin.Context) {
+	region := requestRegion(c)
+	format := c.DefaultQuery("format", "csv")
+	dataset := c.DefaultQuery("dataset", "daily")
+	derived := c.Query("derived") == "1" || c.Query("derived") == "true"
+	fromParam := c.Query("from")
+	toParam := c.DefaultQuery("to", getTodayDay())
+
+	var errs []FieldError
+	if _, ok := lookupRegion(region); !ok {
+		errs = append(errs, FieldError{Field: "region", Rule: "oneof", Message: "must be one of " + strings.Join(regionCodes(), ", ")})
+	}
+	if format != "csv" && format != "xlsx" {
+		errs = append(errs, FieldError{Field: "format", Rule: "oneof", Message: "must be csv or xlsx"})
+	}
+	if dataset != "daily" && dataset != "monthly" {
+		errs = append(errs, FieldError{Field: "dataset", Rule: "oneof", Message: "must be daily or monthly"})
+	}
+	if fromParam == "" {
+		errs = append(errs, FieldError{Field: "from", Rule: "required", Message: "is required"})
+	} else {
+		errs = append(errs, checkDate("from", fromParam, dayLayout)...)
+	}
+	errs = append(errs, checkDate("to", toParam, dayLayout)...)
+	if len(errs) > 0 {
+		respondValidationError(c, errs)
+		return
+	}
+	from, _ := time.Parse(dayLayout, fromParam)
+	to, _ := time.Parse(dayLayout, toParam)
+	if to.Before(from) || to.Sub(from) >= maxExportDays*24*time.Hour {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("invalid range: to must be on or after from, at most %d days later", maxExportDays-1)})
+		return
+	}
+	to = to.Add(24*time.Hour - time.Second)
+
+	name := fmt.Sprintf("house-%s-%s-%s", region, fromParam, toParam)
+	if format == "csv" && dataset == "monthly" {
+		name = fmt.Sprintf("house-%s-monthly-%s-%s", region, fromParam, toParam)
+	}
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.%s"`, name, format))
+	c.Header("Cache-Control", "no-cache")
+
+	var err error
+	if format == "xlsx" {
+		c.Header("Content-Type", "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet")
+		c.Status(http.StatusOK)
+		err = writeXLSXExport(c, region, from, to, derived)
+	} else {
+		c.Header("Content-Type", "text/csv; charset=utf-8")
+		c.Status(http.StatusOK)
+		err = writeCSVExport(c, region, dataset, from, to, derived)
+	}
+	if err != nil {
+		log.Logger.Error().Err(err).Str("region", region).Str("from", fromParam).Str("to", toParam).Str("format", format).Msg("Failed to export house data")
+	}
+}
+
+func writeCSVExport(c *gin.Context, region, dataset string, from, to time.Time, derived bool) error {
+	if _, err := io.WriteString(c.Writer, utf8BOM); err != nil {
+		return err
+	}
+	w := csv.NewWriter(c.Writer)
+	sheet := &csvSheet{w: w}
+	flush := func() {
+		w.Flush()
+		c.Writer.Flush()
+	}
+
+	var err error
+	if dataset == "monthly" {
+		if err = sheet.WriteRow(exportHeaders(monthlyExportHeaders, derived)); err == nil {
+			err = exportMonthlyRows(c.Request.Context(), sheet, region, from, to, derived)
+		}
+	} else {
+		if err = sheet.WriteRow(exportHeaders(dailyExportHeaders, derived)); err == nil {
+			err = exportDailyRows(c.Request.Context(), sheet, region, from, to, derived, flush)
+		}
+	}
+	flush()
+	if err != nil {
+		return err
+	}
+	return w.Error()
+}
+
+func writeXLSXExport(c *gin.Context, region string, from, to time.Time, derived bool) error {
+	x, err := newXLSXWriter(c.Writer, exportDailySheet, exportMonthlySheet)
+	if err != nil {
+		return err
+	}
+	daily, err := x.nextSheet()
+	if err != nil {
+		return err
+	}
+	if err := daily.WriteRow(exportHeaders(dailyExportHeaders, derived)); err != nil {
+		return err
+	}
+	if err := exportDailyRows(c.Request.Context(), daily, region, from, to, derived, c.Writer.Flush); err != nil {
+		return err
+	}
+	if err := daily.close(); err != nil {
+		return err
+	}
+
+	monthly, err := x.nextSheet()
+	if err != nil {
+		return err
+	}
+	if err := monthly.WriteRow(exportHeaders(monthlyExportHeaders, derived)); err != nil {
+		return err
+	}
+	if err := exportMonthlyRows(c.Request.Context(), monthly, region, from, to, derived); err != nil {
+		return err
+	}
+	if err := monthly.close(); err != nil {
+		return err
+	}
+	return x.Close()
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/csv"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestXLSXColumn(t *testing.T) {
+	for i, want := range map[int]string{0: "A", 25: "Z", 26: "AA", 27: "AB", 701: "ZZ", 702: "AAA"} {
+		if got := xlsxColumn(i); got != want {
+			t.Errorf("xlsxColumn(%d) = %s, want %s", i, got, want)
+		}
+	}
+}
+
+func TestXLSXWriter(t *testing.T) {
+	var buf bytes.Buffer
+	x, err := newXLSXWriter(&buf, exportDailySheet, exportMonthlySheet)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sheet, err := x.nextSheet()
+	if err != nil {
+		t.Fatal(err)
+	}
+	record := DailyHouseResp{Day: "2025-06-09", DailyData: DailyData{TotalCount: 10, TotalArea: 905.5}}
+	if err := sheet.WriteRow(exportHeaders(dailyExportHeaders, true)); err != nil {
+		t.Fatal(err)
+	}
+	if err := sheet.WriteRow(dailyExportRow(record, true)); err != nil {
+		t.Fatal(err)
+	}
+	if err := sheet.close(); err != nil {
+		t.Fatal(err)
+	}
+	// The monthly sheet is left empty
+	if err := x.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	parts := make(map[string]string)
+	for _, f := range zr.File {
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, _ := io.ReadAll(rc)
+		rc.Close()
+		parts[f.Name] = string(data)
+	}
+	for _, name := range []string{"[Content_Types].xml", "_rels/.rels", "xl/workbook.xml", "xl/_rels/workbook.xml.rels", "xl/worksheets/sheet1.xml", "xl/worksheets/sheet2.xml"} {
+		if _, ok := parts[name]; !ok {
+			t.Errorf("missing part %s", name)
+		}
+	}
+	if !strings.Contains(parts["xl/workbook.xml"], `name="月数据"`) {
+		t.Error("workbook should list the monthly sheet")
+	}
+	sheet1 := parts["xl/worksheets/sheet1.xml"]
+	for _, want := range []string{
+		`<c r="A1" t="inlineStr"><is><t>日期</t></is></c>`,
+		`<c r="A2" t="inlineStr"><is><t>2025-06-09</t></is></c>`,
+		`<c r="C2"><v>905.5</v></c>`,
+		`<c r="L2"><v>90.55</v></c>`, // average unit size
+	} {
+		if !strings.Contains(sheet1, want) {
+			t.Errorf("sheet1 lacks %s", want)
+		}
+	}
+	// No price: the price per m² is left empty
+	if strings.Contains(sheet1, `r="H2"`) {
+		t.Error("null derived metrics should be empty cells")
+	}
+}
+
+func TestExportDailyRowsSkipsHours(t *testing.T) {
+	useTestStorage(t)
+	data := DailyData{TotalCount: 10, TotalArea: 900, HouseCount: 8, HouseArea: 700}
+	for _, day := range []string{"2025-06-09", "2025-06-09-00", "2025-06-09-08"} {
+		if err := StoreHouseData(ctx, day, DailyHouseResp{Day: day, DailyData: data}, beijingKey); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	from := time.Date(2025, 6, 9, 0, 0, 0, 0, time.UTC)
+	if err := exportDailyRows(ctx, &csvSheet{w: w}, beijingKey, from, from.Add(24*time.Hour-time.Second), false, func() {}); err != nil {
+		t.Fatal(err)
+	}
+	w.Flush()
+	if rows := strings.Count(buf.String(), "\n"); rows != 1 || !strings.HasPrefix(buf.String(), "2025-06-09,") {
+		t.Errorf("want the daily record only, got %q", buf.String())
+	}
+}
@@ -4,27 +4,22 @@ import (
 	"sync"
 )
 
-var beijing *Beijing
-var shanghai *Shanghai
 var fortune *Fortune
 
+// regionStores holds the in-memory store of each region, by region code
+var regionStores sync.Map
+
 type DataAccessor interface {
 	GetDB() *sync.Map
 }
-type Beijing struct {
-	DB *sync.Map
-}
-
-func (b *Beijing) GetDB() *sync.Map {
-	return b.DB
-}
 
-type Shanghai struct {
+// RegionStore is the in-memory house data of a region
+type RegionStore struct {
 	DB *sync.Map
 }
 
-func (s *Shanghai) GetDB() *sync.Map {
-	return s.DB
+func (r *RegionStore) GetDB() *sync.Map {
+	return r.DB
 }
 
 type Fortune struct {
@@ -35,14 +30,22 @@ func (f *Fortune) GetDB() *sync.Map {
 	return f.DB
 }
 
-// InitInMemoryDB initializes the in-memory databases for Beijing, Shanghai, and Fortune data.
+// InitInMemoryDB initializes the in-memory databases of the registered regions and Fortune data.
 func InitInMemoryDB() {
 	// Initialize in-memory databases
-	beijing = &Beijing{DB: &sync.Map{}}
-	shanghai = &Shanghai{DB: &sync.Map{}}
+	regionStores.Clear()
+	for _, code := range regionCodes() {
+		regionStores.Store(code, &RegionStore{DB: &sync.Map{}})
+	}
 	fortune = &Fortune{DB: &sync.Map{}}
 }
 
+// regionStore returns the in-memory store of a region, creating it if needed
+func regionStore(code string) *RegionStore {
+	store, _ := regionStores.LoadOrStore(code, &RegionStore{DB: &sync.Map{}})
+	return store.(*RegionStore)
+}
+
 // GetInMemDataAccessor retrieves the in-memory data accessor for the specified factory.
 func GetInMemDataAccessor(d DataAccessor) *sync.Map {
 	return d.GetDB()
@@ -52,9 +55,9 @@ func getDB(factory string) *sync.Map {
 
 	switch factory {
 	case "daily":
-		return GetInMemDataAccessor(beijing)
+		return GetInMemDataAccessor(regionStore(beijingKey))
 	case "sh":
-		return GetInMemDataAccessor(shanghai)
+		return GetInMemDataAccessor(regionStore(shanghaiKey))
 	case "fortune":
 		return GetInMemDataAccessor(fortune)
 	}
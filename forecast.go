@@ -0,0 +1,333 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+)
+
+// Forecast methods
+const (
+	MethodSeasonalNaive = "seasonal_naive" // Repeat the last week
+	MethodHoltWinters   = "holt_winters"   // Additive Holt-Winters with weekly seasonality
+)
+
+const (
+	seasonLength       = 7 // Weekly seasonality, in days
+	minForecastHorizon = 7
+	maxForecastHorizon = 30
+	defaultHistoryDays = 120
+)
+
+// zScores are the normal quantiles of the supported confidence levels
+var zScores = map[int]float64{80: 1.2816, 90: 1.6449, 95: 1.96}
+
+// errShortHistory is returned when a series is too short for a method
+var errShortHistory = errors.New("not enough history")
+
+// ForecastPoint is a predicted day with its confidence interval
+type ForecastPoint struct {
+	Day     string  `json:"day"`
+	DayType string  `json:"day_type"`
+	Holiday string  `json:"holiday,omitempty"`
+	Value   float64 `json:"value"`
+	Lower   float64 `json:"lower"`
+	Upper   float64 `json:"upper"`
+}
+
+// HoltWintersParams are the smoothing parameters of the level, trend and season
+type HoltWintersParams struct {
+	Alpha float64 `json:"alpha"`
+	Beta  float64 `json:"beta"`
+	Gamma float64 `json:"gamma"`
+}
+
+// BacktestPoint compares a held-out day with its prediction
+type BacktestPoint struct {
+	Day       string  `json:"day"`
+	Actual    float64 `json:"actual"`
+	Predicted float64 `json:"predicted"`
+}
+
+// BacktestResult reports the errors of a method on held-out history
+type BacktestResult struct {
+	MAE    float64         `json:"mae"`
+	RMSE   float64         `json:"rmse"`
+	MAPE   *float64        `json:"mape"` // Percent; null when every actual value is 0
+	Points []BacktestPoint `json:"points"`
+}
+
+// forecastResult is a point forecast with the standard error of each step
+type forecastResult struct {
+	values []float64
+	stderr []float64
+	params *HoltWintersParams
+}
+
+// seasonalNaive predicts every day as the same weekday of the last week. The
+// standard error grows with the number of seasons ahead.
+func seasonalNaive(y []float64, horizon int) (forecastResult, error) {
+	n := len(y)
+	if n < 2*seasonLength {
+		return forecastResult{}, errShortHistory
+	}
+	var sse float64
+	for t := seasonLength; t < n; t++ {
+		e := y[t] - y[t-seasonLength]
+		sse += e * e
+	}
+	sigma := math.Sqrt(sse / float64(n-seasonLength))
+
+	res := forecastResult{values: make([]float64, horizon), stderr: make([]float64, horizon)}
+	for h := 1; h <= horizon; h++ {
+		k := (h - 1) / seasonLength
+		res.values[h-1] = y[n-seasonLength+(h-1)%seasonLength]
+		res.stderr[h-1] = sigma * math.Sqrt(float64(k+1))
+	}
+	return res, nil
+}
+
+// holtWintersFit runs additive Holt-Winters over y and returns the final
+// level, trend and seasonal components with the sum of squared one-step errors
+func holtWintersFit(y []float64, p HoltWintersParams) (level, trend float64, season []float64, sse float64) {
+	m := seasonLength
+	var first, second float64
+	for i := 0; i < m; i++ {
+		first += y[i]
+		second += y[m+i]
+	}
+	level = first / float64(m)
+	trend = (second - first) / float64(m*m)
+	season = make([]float64, m)
+	for i := 0; i < m; i++ {
+		season[i] = y[i] - level
+	}
+
+	for t := m; t < len(y); t++ {
+		s := season[t%m]
+		e := y[t] - (level + trend + s)
+		sse += e * e
+		prevLevel := level
+		level = p.Alpha*(y[t]-s) + (1-p.Alpha)*(level+trend)
+		trend = p.Beta*(level-prevLevel) + (1-p.Beta)*trend
+		season[t%m] = p.Gamma*(y[t]-level) + (1-p.Gamma)*s
+	}
+	return level, trend, season, sse
+}
+
+// holtWinters forecasts y with additive Holt-Winters, choosing the smoothing
+// parameters by grid search on the one-step errors
+func holtWinters(y []float64, horizon int) (forecastResult, error) {
+	n := len(y)
+	if n < 2*seasonLength+1 {
+		return forecastResult{}, errShortHistory
+	}
+
+	best := HoltWintersParams{Alpha: 0.5, Beta: 0.1, Gamma: 0.1}
+	bestSSE := math.Inf(1)
+	for a := 1; a <= 9; a++ {
+		for b := 0; b <= 5; b++ {
+			for g := 0; g <= 9; g++ {
+				p := HoltWintersParams{Alpha: float64(a) / 10, Beta: float64(b) / 10, Gamma: float64(g) / 10}
+				if _, _, _, sse := holtWintersFit(y, p); sse < bestSSE {
+					best, bestSSE = p, sse
+				}
+			}
+		}
+	}
+
+	level, trend, season, sse := holtWintersFit(y, best)
+	sigma2 := sse / float64(n-seasonLength)
+
+	// Prediction variance of the additive model (ETS(A,A,A))
+	res := forecastResult{values: make([]float64, horizon), stderr: make([]float64, horizon), params: &best}
+	variance := 1.0
+	for h := 1; h <= horizon; h++ {
+		if h > 1 {
+			j := h - 1
+			c := best.Alpha * (1 + float64(j)*best.Beta)
+			if j%seasonLength == 0 {
+				c += best.Gamma
+			}
+			variance += c * c
+		}
+		res.values[h-1] = level + float64(h)*trend + season[(n+h-1)%seasonLength]
+		res.stderr[h-1] = math.Sqrt(sigma2 * variance)
+	}
+	return res, nil
+}
+
+// runForecast forecasts y with method
+func runForecast(method string, y []float64, horizon int) (forecastResult, error) {
+	switch method {
+	case MethodSeasonalNaive:
+		return seasonalNaive(y, horizon)
+	case MethodHoltWinters:
+		return holtWinters(y, horizon)
+	}
+	return forecastResult{}, fmt.Errorf("unknown method %q", method)
+}
+
+// backtest holds out the last holdout values of y, forecasts them from the
+// rest and reports the errors; days are the dates of y
+func backtest(method string, y []float64, days []string, holdout int) (BacktestResult, error) {
+	train, actual := y[:len(y)-holdout], y[len(y)-holdout:]
+	res, err := runForecast(method, train, holdout)
+	if err != nil {
+		return BacktestResult{}, err
+	}
+
+	var result BacktestResult
+	var absSum, sqSum, pctSum, pctN float64
+	for i, a := range actual {
+		p := math.Max(res.values[i], 0)
+		e := a - p
+		absSum += math.Abs(e)
+		sqSum += e * e
+		if a != 0 {
+			pctSum += math.Abs(e / a)
+			pctN++
+		}
+		result.Points = append(result.Points, BacktestPoint{Day: days[len(train)+i], Actual: a, Predicted: round2(p)})
+	}
+	result.MAE = round2(absSum / float64(holdout))
+	result.RMSE = round2(math.Sqrt(sqSum / float64(holdout)))
+	if pctN > 0 {
+		mape := round2(pctSum / pctN * 100)
+		result.MAPE = &mape
+	}
+	return result, nil
+}
+
+// getForecast predicts metric (default total_count) for the next horizon days
+// (7-30, default 14) from the last history days (default 120). With
+// backtest=true it instead forecasts the last holdout days (default horizon)
+// from the days before them and reports the errors of both methods.
+func getForecast(c *gin.Context) {
+	region := requestRegion(c)
+	metric := c.DefaultQuery("metric", "total_count")
+	method := c.DefaultQuery("method", MethodHoltWinters)
+	horizon, horizonErr := strconv.Atoi(c.DefaultQuery("horizon", "14"))
+	historyDays, historyErr := strconv.Atoi(c.DefaultQuery("history", strconv.Itoa(defaultHistoryDays)))
+	level, levelErr := strconv.Atoi(c.DefaultQuery("level", "95"))
+	holdout, holdoutErr := strconv.Atoi(c.DefaultQuery("holdout", strconv.Itoa(horizon)))
+
+	var errs []FieldError
+	if !slices.Contains(houseMetrics, metric) {
+		errs = append(errs, FieldError{Field: "metric", Rule: "oneof", Message: "must be one of " + strings.Join(houseMetrics, ", ")})
+	}
+	if method != MethodSeasonalNaive && method != MethodHoltWinters {
+		errs = append(errs, FieldError{Field: "method", Rule: "oneof", Message: "must be seasonal_naive or holt_winters"})
+	}
+	if horizonErr != nil || horizon < minForecastHorizon || horizon > maxForecastHorizon {
+		errs = append(errs, FieldError{Field: "horizon", Rule: "range", Message: fmt.Sprintf("must be between %d and %d", minForecastHorizon, maxForecastHorizon)})
+	}
+	if historyErr != nil || historyDays < 3*seasonLength || historyDays > appConfig.MaxRangeDays {
+		errs = append(errs, FieldError{Field: "history", Rule: "range", Message: fmt.Sprintf("must be between %d and %d", 3*seasonLength, appConfig.MaxRangeDays)})
+	}
+	if _, ok := zScores[level]; levelErr != nil || !ok {
+		errs = append(errs, FieldError{Field: "level", Rule: "oneof", Message: "must be 80, 90 or 95"})
+	}
+	if holdoutErr != nil || holdout < 1 || holdout > maxForecastHorizon {
+		errs = append(errs, FieldError{Field: "holdout", Rule: "range", Message: fmt.Sprintf("must be between 1 and %d", maxForecastHorizon)})
+	}
+	if len(errs) > 0 {
+		respondValidationError(c, errs)
+		return
+	}
+
+	to, _ := time.Parse(dayLayout, getTodayDay())
+	from := to.AddDate(0, 0, -(historyDays - 1))
+	values, err := GetMetricValues(ctx, from, to.Add(24*time.Hour-time.Second), region, metric)
+	if err != nil {
+		log.Logger.Error().Err(err).Str("region", region).Msg("Failed to get house data for forecast")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get house data"})
+		return
+	}
+
+	// Holt-Winters needs a regular series, so gaps are interpolated
+	points := trendSeries(values, from, to, MissingInterpolate)
+	days := make([]string, len(points))
+	y := make([]float64, len(points))
+	var interpolated int
+	for i, p := range points {
+		days[i], y[i] = p.Day, p.Value
+		if p.Interpolated {
+			interpolated++
+		}
+	}
+	history := gin.H{"points": len(y), "interpolated": interpolated}
+	if len(y) > 0 {
+		history["from"], history["to"] = days[0], days[len(days)-1]
+	}
+
+	if c.Query("backtest") == "true" {
+		if len(y) <= holdout {
+			c.JSON(http.StatusUnprocessableEntity, gin.H{"error": errShortHistory.Error(), "history": history})
+			return
+		}
+		results := make(map[string]BacktestResult)
+		for _, m := range []string{MethodSeasonalNaive, MethodHoltWinters} {
+			result, err := backtest(m, y, days, holdout)
+			if err != nil {
+				continue
+			}
+			results[m] = result
+		}
+		if len(results) == 0 {
+			c.JSON(http.StatusUnprocessableEntity, gin.H{"error": errShortHistory.Error(), "history": history})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"region":  region,
+			"metric":  metric,
+			"holdout": holdout,
+			"history": history,
+			"methods": results,
+		})
+		return
+	}
+
+	res, err := runForecast(method, y, horizon)
+	if err != nil {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error(), "history": history})
+		return
+	}
+	last, _ := time.Parse(dayLayout, days[len(days)-1])
+	z := zScores[level]
+	forecast := make([]ForecastPoint, horizon)
+	for i, v := range res.values {
+		// Volumes, areas and prices cannot be negative
+		info := holidayCalendar.Info(last.AddDate(0, 0, i+1))
+		forecast[i] = ForecastPoint{
+			Day:     info.Day,
+			DayType: info.Type,
+			Holiday: info.Holiday,
+			Value:   round2(math.Max(v, 0)),
+			Lower:   round2(math.Max(v-z*res.stderr[i], 0)),
+			Upper:   round2(math.Max(v+z*res.stderr[i], 0)),
+		}
+	}
+
+	body := gin.H{
+		"region":   region,
+		"metric":   metric,
+		"method":   method,
+		"horizon":  horizon,
+		"level":    level,
+		"history":  history,
+		"forecast": forecast,
+	}
+	if res.params != nil {
+		body["params"] = res.params
+	}
+	c.JSON(http.StatusOK, body)
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"testing"
+)
+
+// weeklySeries returns n days of a weekly pattern on a linear trend
+func weeklySeries(n int, slope float64) []float64 {
+	pattern := []float64{120, 130, 125, 140, 160, 60, 50}
+	y := make([]float64, n)
+	for i := range y {
+		y[i] = pattern[i%seasonLength] + slope*float64(i)
+	}
+	return y
+}
+
+func TestSeasonalNaive(t *testing.T) {
+	y := weeklySeries(28, 0)
+	res, err := seasonalNaive(y, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for h, v := range res.values {
+		if want := y[(28+h)%seasonLength]; v != want {
+			t.Errorf("h=%d: got %v, want %v", h+1, v, want)
+		}
+	}
+	if _, err := seasonalNaive(y[:10], 7); err != errShortHistory {
+		t.Errorf("expected errShortHistory, got %v", err)
+	}
+}
+
+func TestHoltWintersFollowsTrendAndSeason(t *testing.T) {
+	y := weeklySeries(84, 1.5)
+	res, err := holtWinters(y, 14)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for h, v := range res.values {
+		want := weeklySeries(84+h+1, 1.5)[84+h]
+		if math.Abs(v-want) > 5 {
+			t.Errorf("h=%d: got %.2f, want about %.2f", h+1, v, want)
+		}
+		if h > 0 && res.stderr[h] < res.stderr[h-1] {
+			t.Errorf("h=%d: standard error should not shrink", h+1)
+		}
+	}
+}
+
+func TestBacktest(t *testing.T) {
+	y := weeklySeries(42, 0)
+	days := make([]string, len(y))
+	for i := range days {
+		days[i] = fmt.Sprintf("day-%02d", i)
+	}
+	result, err := backtest(MethodSeasonalNaive, y, days, 7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.MAE != 0 || result.RMSE != 0 || result.MAPE == nil || *result.MAPE != 0 || len(result.Points) != 7 {
+		t.Errorf("expected a perfect backtest of a periodic series, got %+v", result)
+	}
+}
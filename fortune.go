@@ -17,6 +17,7 @@ func dailyFortune(c *gin.Context) {
 		if err != nil {
 			log.Logger.Error().Err(err).Str("day", day).Msg("Error getting fortune data from Redis")
 		} else if found {
+			recordCacheLookup(c, sourceRedis)
 			c.JSON(http.StatusOK, poem)
 			return
 		}
@@ -25,18 +26,21 @@ func dailyFortune(c *gin.Context) {
 		if v, ok := db.Load(day); ok {
 			// Store in Redis for future use
 			poem, ok := v.(Poem)
-			if ok {
-				go func(day string, data Poem) {
-					if err := StoreFortuneData(ctx, day, data); err != nil {
+			if ok && !isDegraded() {
+				writeBacks.Go(func() {
+					if err := StoreFortuneData(writeBackCtx, day, poem); err != nil {
+						writeBackFailuresTotal.WithLabelValues("fortune").Inc()
 						log.Logger.Error().Err(err).Str("day", day).Msg("Failed to store fortune data in Redis")
 					}
-				}(day, poem)
+				})
 			}
+			recordCacheLookup(c, sourceMemory)
 			c.JSON(http.StatusOK, v)
 			return
 		}
 	}
 	log.Logger.Error().Str("msg", "daily data not found").Msg("Data not found")
+	recordCacheLookup(c, sourceMiss)
 	c.JSON(http.StatusNotFound, gin.H{"msg": "data not found"})
 }
 
@@ -45,15 +49,25 @@ func addDailyFortune(c *gin.Context) {
 	var req Poem
 	// Bind JSON body to struct
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		respondValidationError(c, bindingFieldErrors(err))
 		log.Logger.Error().Err(err).Msg("Failed to bind JSON")
 		return
 	}
+	if errs := checkDate("day", req.Day, dayLayout); len(errs) > 0 {
+		respondValidationError(c, errs)
+		return
+	}
+	wctx := requestContext(c)
 	log.Logger.Debug().Any("fortune", req).Msg("add data")
 
 	// Store in Redis
+	// Overwriting an existing poem requires the force scope
 	forceUpdate := false
-	if v, ok := c.GetQuery("force"); ok && v == "fortune" {
+	if v := c.Query("force"); v == "fortune" || v == "true" {
+		if !hasScope(c, ScopeForce) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "API key lacks scope " + ScopeForce})
+			return
+		}
 		forceUpdate = true
 	}
 
@@ -72,15 +86,16 @@ func addDailyFortune(c *gin.Context) {
 
 	if shouldStore {
 		// Store in Redis
-		if err := StoreFortuneData(ctx, req.Day, req); err != nil {
+		if err := StoreFortuneData(wctx, req.Day, req); err != nil {
 			log.Logger.Error().Err(err).Str("day", req.Day).Msg("Failed to store fortune data in Redis")
+			queueFortuneWrite(wctx, req.Day, req)
 		}
+		notifyFortune(req)
 	}
 
 	// Also store in memory for backward compatibility
 	db := GetInMemDataAccessor(fortune)
-	_, ok := db.Load(req.Day)
-	if forceUpdate || !ok {
+	if _, ok := db.Load(req.Day); shouldStore || !ok {
 		db.Store(req.Day, req)
 	}
 
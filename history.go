@@ -0,0 +1,219 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+)
+
+// Redis key prefix for revision history lists
+const HistoryKeyPrefix = "history" // history:{key} lists every revision of key
+
+// Sources of writes that do not come from an HTTP endpoint
+const (
+	sourceWriteBack = "write-back" // cache fill from the in-memory stores
+	sourceRollback  = "rollback"
+)
+
+// Revision is one write of a stored record
+type Revision struct {
+	Version   int             `json:"version,omitempty"` // position in the history list, set on read
+	Key       string          `json:"key"`
+	Previous  json.RawMessage `json:"previous,omitempty"` // absent for the first write
+	Current   json.RawMessage `json:"current"`
+	Timestamp time.Time       `json:"timestamp"`
+	Caller    string          `json:"caller"`
+	Source    string          `json:"source"`
+}
+
+// writeOrigin identifies who wrote a record and through which endpoint
+type writeOrigin struct {
+	Caller string
+	Source string
+}
+
+type writeOriginCtxKey struct{}
+
+// writeBackCtx is the context of background cache fills
+var writeBackCtx = withWriteOrigin(ctx, writeOrigin{Caller: "system", Source: sourceWriteBack})
+
+// withWriteOrigin returns a context recording the origin of the writes made with it
+func withWriteOrigin(ctx context.Context, origin writeOrigin) context.Context {
+	return context.WithValue(ctx, writeOriginCtxKey{}, origin)
+}
+
+// writeOriginFrom returns the write origin recorded in ctx
+func writeOriginFrom(ctx context.Context) writeOrigin {
+	if origin, ok := ctx.Value(writeOriginCtxKey{}).(writeOrigin); ok {
+		return origin
+	}
+	return writeOrigin{Caller: "anonymous", Source: "unknown"}
+}
+
+// requestContext returns the context for storage writes made by a request,
+// recording the API key and endpoint as their origin
+func requestContext(c *gin.Context) context.Context {
+	caller := "anonymous"
+	if v, ok := c.Get(apiKeyCtxKey); ok {
+		apiKey := v.(APIKey)
+		caller = fmt.Sprintf("%s (%s)", apiKey.Name, apiKey.ID)
+	}
+	return withWriteOrigin(ctx, writeOrigin{Caller: caller, Source: c.FullPath()})
+}
+
+func formatHistoryKey(key string) string {
+	return fmt.Sprintf("%s:%s", HistoryKeyPrefix, key)
+}
+
+// setWithHistory stores value at key and appends a revision holding the
+// previous and new values. Writing an unchanged value records nothing.
+func setWithHistory(ctx context.Context, key string, value []byte) error {
+	previous, found, err := storageFor(ctx).Get(ctx, key)
+	if err != nil {
+		return err
+	}
+	if found && previous == string(value) {
+		supersedePendingWrite(ctx, key)
+		return nil
+	}
+
+	if err := storageFor(ctx).Set(ctx, key, value); err != nil {
+		return err
+	}
+	supersedePendingWrite(ctx, key)
+
+	origin := writeOriginFrom(ctx)
+	revision := Revision{
+		Key:       key,
+		Current:   json.RawMessage(value),
+		Timestamp: time.Now(),
+		Caller:    origin.Caller,
+		Source:    origin.Source,
+	}
+	if found {
+		revision.Previous = json.RawMessage(previous)
+	}
+
+	// The value is stored; a failure to record history is logged, not returned
+	if version, err := appendRevision(ctx, revision); err != nil {
+		log.Logger.Error().Err(err).Str("key", key).Msg("Failed to record revision")
+	} else if version > 0 {
+		log.Logger.Debug().Str("key", key).Int64("version", version).Msg("Recorded revision")
+	}
+	return nil
+}
+
+// appendRevision pushes revision onto the history of its key and returns its
+// version: the length of the list after the push, so concurrent writers never
+// share a version. Batched pushes return 0.
+func appendRevision(ctx context.Context, revision Revision) (int64, error) {
+	revision.Version = 0
+	jsonData, err := json.Marshal(revision)
+	if err != nil {
+		return 0, err
+	}
+	return storageFor(ctx).RPush(ctx, formatHistoryKey(revision.Key), jsonData)
+}
+
+// GetHistory retrieves every revision of key, oldest first, numbered by their
+// position in the list
+func GetHistory(ctx context.Context, key string) ([]Revision, error) {
+	values, err := storageFor(ctx).LRange(ctx, formatHistoryKey(key), 0, -1)
+	if err != nil {
+		return nil, err
+	}
+
+	revisions := make([]Revision, 0, len(values))
+	for i, value := range values {
+		var revision Revision
+		if err := json.Unmarshal([]byte(value), &revision); err != nil {
+			log.Logger.Error().Err(err).Str("key", key).Msg("Failed to unmarshal revision")
+			continue
+		}
+		revision.Version = i + 1
+		revisions = append(revisions, revision)
+	}
+	return revisions, nil
+}
+
+// validHistoryKey reports whether key names a house or fortune record
+func validHistoryKey(key string) bool {
+	for _, prefix := range []string{HouseDailyKeyPrefix, HouseMonthlyKeyPrefix, FortuneDailyKeyPrefix} {
+		if strings.HasPrefix(key, prefix+":") {
+			return true
+		}
+	}
+	return false
+}
+
+// getHistory lists the revisions of a record: /admin/history?key=house:daily:beijing:2025-04-10
+func getHistory(c *gin.Context) {
+	key := c.Query("key")
+	if !validHistoryKey(key) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid key (must be a house:daily, house:monthly or fortune:day key)"})
+		return
+	}
+
+	revisions, err := GetHistory(ctx, key)
+	if err != nil {
+		log.Logger.Error().Err(err).Str("key", key).Msg("Failed to get history")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get history"})
+		return
+	}
+	if len(revisions) == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"msg": "no history found for key"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"key": key, "data": revisions})
+}
+
+// rollbackHistory restores a record to the value written by an earlier
+// revision: {"key": "...", "version": 3}. The rollback is itself recorded.
+func rollbackHistory(c *gin.Context) {
+	var req struct {
+		Key     string `json:"key" binding:"required"`
+		Version int    `json:"version" binding:"required,min=1"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !validHistoryKey(req.Key) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid key (must be a house:daily, house:monthly or fortune:day key)"})
+		return
+	}
+
+	revisions, err := GetHistory(ctx, req.Key)
+	if err != nil {
+		log.Logger.Error().Err(err).Str("key", req.Key).Msg("Failed to get history")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get history"})
+		return
+	}
+
+	for _, revision := range revisions {
+		if revision.Version != req.Version {
+			continue
+		}
+		origin := writeOriginFrom(requestContext(c))
+		origin.Source = sourceRollback + " to v" + strconv.Itoa(req.Version)
+		if err := setWithHistory(withWriteOrigin(ctx, origin), req.Key, revision.Current); err != nil {
+			log.Logger.Error().Err(err).Str("key", req.Key).Int("version", req.Version).Msg("Failed to roll back")
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to roll back"})
+			return
+		}
+		if err := refreshAggregatesForKey(ctx, req.Key); err != nil {
+			log.Logger.Error().Err(err).Str("key", req.Key).Msg("Failed to refresh house aggregates")
+		}
+		log.Logger.Info().Str("key", req.Key).Int("version", req.Version).Msg("Rolled back")
+		c.JSON(http.StatusOK, gin.H{"key": req.Key, "version": req.Version, "current": revision.Current})
+		return
+	}
+	c.JSON(http.StatusNotFound, gin.H{"msg": "revision not found"})
+}
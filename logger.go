@@ -5,24 +5,25 @@ import (
 	"path/filepath"
 	"time"
 
+	"github.com/LIUHUANUCAS/house/config"
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
 	"gopkg.in/natefinch/lumberjack.v2"
 )
 
-func setupLogger() zerolog.Logger {
+func setupLogger(logCfg *config.LogConfig, level string) zerolog.Logger {
 	// 创建日志目录
-	if err := os.MkdirAll("logs", 0755); err != nil {
+	if err := os.MkdirAll(logCfg.Dir, 0755); err != nil {
 		log.Fatal().Err(err).Msg("无法创建日志目录")
 	}
 
 	// 配置日志轮转
 	logFile := &lumberjack.Logger{
-		Filename:   filepath.Join("logs", "app.log"),
-		MaxSize:    100,  // MB
-		MaxBackups: 5,    // 保留的旧日志文件数量
-		MaxAge:     30,   // 天数
-		Compress:   true, // 压缩旧日志
+		Filename:   filepath.Join(logCfg.Dir, "app.log"),
+		MaxSize:    logCfg.MaxSize,    // MB
+		MaxBackups: logCfg.MaxBackups, // 保留的旧日志文件数量
+		MaxAge:     logCfg.MaxAge,     // 天数
+		Compress:   logCfg.Compress,   // 压缩旧日志
 	}
 
 	// 控制台输出配置
@@ -42,7 +43,11 @@ func setupLogger() zerolog.Logger {
 		Logger()
 
 	// 设置全局日志级别
-	zerolog.SetGlobalLevel(zerolog.DebugLevel)
+	logLevel, err := zerolog.ParseLevel(level)
+	if err != nil {
+		logLevel = zerolog.DebugLevel
+	}
+	zerolog.SetGlobalLevel(logLevel)
 
 	return logger
 }
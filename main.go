@@ -1,71 +1,153 @@
 package main
 
 import (
-	// "fmt"
+	"context"
+	"errors"
+	"fmt"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
 	"github.com/LIUHUANUCAS/house/config"
 	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/rs/zerolog/log"
 )
 
-// date to serve data
-var (
-	hours   []int = []int{-24 * previousDay, -24 * prePreviousDay}
-	shHours []int = []int{today, -24 * previousDay}
-)
-
-var monthScope []int = []int{currentMonth, -previousMonth, -prePreviousMonth}
+// appConfig is the configuration the server was started with
+var appConfig = config.GetConfig()
 
 func main() {
+	cfg, err := config.Load(os.Args[1:])
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	appConfig = cfg
 
-	logger := setupLogger()
+	logger := setupLogger(&cfg.LogConfig, cfg.LogLevel)
 	// replace global logger
 	log.Logger = logger
-	cfg := config.GetConfig()
+	// Logged without a level so that it is written whatever the log level
+	log.Logger.Log().Any("config", cfg.Redacted()).Msg("Effective configuration")
+
+	if err := InitCalendar(&cfg.CalendarConfig); err != nil {
+		log.Logger.Fatal().Err(err).Msg("Failed to load holiday calendar")
+	}
+
+	if err := InitRegions(cfg.Regions); err != nil {
+		log.Logger.Fatal().Err(err).Msg("Failed to load region registry")
+	}
+
 	InitInMemoryDB()
 
-	// Initialize Redis
-	redisDB = InitRedis(ctx, &cfg.RedisConfig)
+	// Background tasks (Redis reconnect loop) stop when appCtx is cancelled
+	appCtx, cancelApp := context.WithCancel(context.Background())
+	defer cancelApp()
+
+	// Initialize storage (Redis or embedded bolt file)
+	storage = InitStorage(appCtx, cfg)
+	ensureBootstrapKey(cfg.AuthConfig.BootstrapAdminKey)
+	InitEvents(appCtx, &cfg.EventsConfig)
+	InitWebhooks(appCtx, &cfg.WebhookConfig)
 
 	// Create a new Gin router
 	router := gin.New()
 	// Add middleware
-	router.Use(loggerMiddleware())
+	router.Use(loggerMiddleware(), metricsMiddleware())
 
-	router.GET("/health", func(ctx *gin.Context) {
-		ctx.JSON(http.StatusOK, gin.H{"msg": "success"})
-	})
+	router.GET("/health", health)
+	router.GET("/metrics", gin.WrapH(promhttp.Handler()))
+	router.GET("/events", authorize(), streamEvents)
 
 	//Beijing data API
-	v1 := router.Group("/v1")
+	v1 := router.Group("/v1", withRegion(beijingKey), authorize())
 	{
 		// Define routes
-		v1.GET("/daily_house", dailyHouse)
-		v1.GET("/daily_new_house", beijingNewDailyHouse)
-		v1.GET("/month_house", monthHouse)
+		v1.GET("/daily_house", latestRecord(beijingKey, config.DatasetOld))
+		v1.GET("/daily_new_house", latestRecord(beijingKey, config.DatasetNew))
+		v1.GET("/month_house", latestRecord(beijingKey, config.DatasetMonthly))
 		v1.POST("/add_daily_house", addDailyHouse)
-		v1.POST("/add_beijing_new_house", addBeijingNewHouse)
-		v1.POST("/force_house", forceAddHouse)
+		v1.POST("/add_beijing_new_house", addRecord(beijingKey, config.DatasetNew))
+		v1.POST("/force_house", requireScope(ScopeForce), forceAddHouse)
+		v1.POST("/bulk_daily_house", bulkRecords(beijingKey, config.DatasetOld))
+		v1.POST("/bulk_beijing_new_house", bulkRecords(beijingKey, config.DatasetNew))
 
 		// Time-based retrieval endpoints
 		v1.GET("/house_period/:days", getHousePeriod)
+		v1.GET("/house_range", getHouseRange)
+		v1.GET("/compare", compareHouse)
+		v1.GET("/aggregate", getAggregate)
+		v1.GET("/trend", getTrend)
+		v1.GET("/anomalies", getAnomalies)
+		v1.GET("/forecast", getForecast)
+		v1.GET("/rankings", getRankings)
+		v1.GET("/stats", getStats)
+		v1.GET("/cross_region", getCrossRegion)
+		v1.GET("/calendar", getCalendar)
+		v1.GET("/month_mismatches", getMonthMismatches)
+		v1.GET("/districts", getDistricts)
+		v1.GET("/districts/:district", getDistrict)
+		v1.GET("/district_rankings", getDistrictRankings)
+		v1.GET("/export", exportHouse)
 	}
 	// shanghai data API
-	v2 := router.Group("/v2/sh")
+	v2 := router.Group("/v2/sh", withRegion(shanghaiKey), authorize())
 	{
 		// Define routes
-		v2.GET("/new_daily_house", shNewDailyHouse)
-		v2.GET("/old_daily_house", shOldDailyHouse)
-		v2.POST("/add_new_daily_house", addShNewDailyHouse)
-		v2.POST("/add_old_daily_house", addShOldDailyHouse)
+		v2.GET("/new_daily_house", latestRecord(shanghaiKey, config.DatasetNew))
+		v2.GET("/old_daily_house", latestRecord(shanghaiKey, config.DatasetOld))
+		v2.POST("/add_new_daily_house", addAlias(shanghaiKey, config.DatasetNew))
+		v2.POST("/add_old_daily_house", addAlias(shanghaiKey, config.DatasetOld))
+		v2.POST("/bulk_new_daily_house", bulkRecords(shanghaiKey, config.DatasetNew))
+		v2.POST("/bulk_old_daily_house", bulkRecords(shanghaiKey, config.DatasetOld))
 
 		// Time-based retrieval endpoint
-		v2.GET("/house_period/:days", getShHousePeriod)
+		v2.GET("/house_period/:days", getHousePeriod)
+		v2.GET("/house_range", getHouseRange)
+		v2.GET("/compare", compareHouse)
+		v2.GET("/month_house", latestRecord(shanghaiKey, config.DatasetMonthly))
+		v2.GET("/aggregate", getAggregate)
+		v2.GET("/trend", getTrend)
+		v2.GET("/anomalies", getAnomalies)
+		v2.GET("/forecast", getForecast)
+		v2.GET("/rankings", getRankings)
+		v2.GET("/stats", getStats)
+		v2.GET("/month_mismatches", getMonthMismatches)
+		v2.GET("/districts", getDistricts)
+		v2.GET("/districts/:district", getDistrict)
+		v2.GET("/district_rankings", getDistrictRankings)
+		v2.GET("/export", exportHouse)
 	}
 
-	v3 := router.Group("/v3/fortune")
+	// Generic API of every registered region; /v1 and /v2/sh are aliases
+	router.GET("/regions", authorize(), listRegions)
+	regions := router.Group("/regions/:region", regionParam(), authorize())
+	{
+		regions.GET("/:dataset", latestRecord("", ""))
+		regions.POST("/:dataset", addRecord("", ""))
+		regions.POST("/:dataset/bulk", bulkRecords("", ""))
+
+		regions.GET("/house_period/:days", getHousePeriod)
+		regions.GET("/house_range", getHouseRange)
+		regions.GET("/compare", compareHouse)
+		regions.GET("/aggregate", getAggregate)
+		regions.GET("/trend", getTrend)
+		regions.GET("/anomalies", getAnomalies)
+		regions.GET("/forecast", getForecast)
+		regions.GET("/rankings", getRankings)
+		regions.GET("/stats", getStats)
+		regions.GET("/month_mismatches", getMonthMismatches)
+		regions.GET("/districts", getDistricts)
+		regions.GET("/districts/:district", getDistrict)
+		regions.GET("/district_rankings", getDistrictRankings)
+		regions.GET("/export", exportHouse)
+		regions.GET("/events", streamEvents)
+	}
+
+	v3 := router.Group("/v3/fortune", authorize())
 	{
 		// Define routes
 		v3.GET("/daily", dailyFortune)
@@ -73,72 +155,68 @@ func main() {
 
 	}
 
-	// Run the server
-	router.Run(":8080")
-}
+	admin := router.Group("/admin", authorize(), requireScope(ScopeAdmin))
+	{
+		// API key management
+		admin.POST("/keys", createAPIKey)
+		admin.GET("/keys", listAPIKeysHandler)
+		admin.DELETE("/keys/:id", revokeAPIKey)
+
+		// Revision history of house and fortune records
+		admin.GET("/history", getHistory)
+		admin.POST("/history/rollback", rollbackHistory)
+		admin.POST("/aggregates/rebuild", rebuildAggregates)
+		admin.GET("/quarantine", listQuarantine)
+		admin.POST("/quarantine/release", releaseQuarantine)
+
+		// Outbound webhooks
+		admin.POST("/webhooks", createWebhook)
+		admin.GET("/webhooks", listWebhooksHandler)
+		admin.DELETE("/webhooks/:id", deleteWebhook)
+		admin.GET("/webhooks/:id/deliveries", getWebhookDeliveries)
+		admin.GET("/webhooks/dead_letters", getDeadLetters)
+	}
 
-func dailyHouse(c *gin.Context) {
-	// Try to get data from Redis first
-	m := GetInMemDataAccessor(beijing)
-	for _, h := range hours {
-		previousDate := getPreviousDay(-h)
-		houseData, found, err := GetHouseData(ctx, previousDate, beijingKey)
-		if err != nil {
-			log.Logger.Error().Err(err).Str("day", previousDate).Msg("Error getting house data from Redis")
-		} else if found {
-			c.JSON(http.StatusOK, houseData)
-			return
+	// Run the server until SIGINT/SIGTERM
+	srv := &http.Server{
+		Addr:    fmt.Sprintf(":%d", cfg.Port),
+		Handler: router,
+	}
+	// Event streams never end on their own
+	srv.RegisterOnShutdown(events.closeAll)
+	sigCtx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	go func() {
+		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Logger.Fatal().Err(err).Msg("Server failed")
 		}
+	}()
+	log.Logger.Info().Int("port", cfg.Port).Msg("Server started")
 
-		// Fallback to in-memory if Redis fails or data not found in Redis
-		if v, ok := m.Load(previousDate); ok {
-			// Store in Redis for future use
-			dailyResp, ok := v.(DailyHouseResp)
-			if ok {
-				go func(day string, data DailyHouseResp) {
-					if err := StoreHouseData(ctx, day, data, beijingKey); err != nil {
-						log.Logger.Error().Err(err).Str("day", day).Msg("Failed to store house data in Redis")
-					}
-				}(previousDate, dailyResp)
-			}
-			c.JSON(http.StatusOK, v)
-			return
-		}
-	}
-	log.Logger.Error().Str("msg", "daily data not found").Msg("Data not found")
-	c.JSON(http.StatusNotFound, gin.H{"msg": "data not found"})
-}
+	<-sigCtx.Done()
+	log.Logger.Info().Msg("Shutting down")
 
-func monthHouse(c *gin.Context) {
-	m := GetInMemDataAccessor(beijing)
-	// Try to get data from Redis first
-	for _, mon := range monthScope {
-		previousDate := getPreviousMonth(mon)
-		monthData, found, err := GetMonthHouseData(ctx, previousDate, beijingKey)
-		if err != nil {
-			log.Logger.Error().Err(err).Str("month", previousDate).Msg("Error getting month house data from Redis")
-		} else if found {
-			c.JSON(http.StatusOK, monthData)
-			return
-		}
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), time.Duration(cfg.ShutdownTimeout)*time.Second)
+	defer cancel()
 
-		// Fallback to in-memory if Redis fails or data not found in Redis
-		if v, ok := m.Load(previousDate); ok {
-			// Store in Redis for future use
-			monthResp, ok := v.(MonthHouseResp)
-			if ok {
-				go func(month string, data MonthHouseResp) {
-					if err := StoreMonthHouseData(ctx, month, data, beijingKey); err != nil {
-						log.Logger.Error().Err(err).Str("month", month).Msg("Failed to store month house data in Redis")
-					}
-				}(previousDate, monthResp)
-			}
-			c.JSON(http.StatusOK, v)
-			return
-		}
+	// Drain in-flight requests, then the background writes they started
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		log.Logger.Error().Err(err).Msg("Failed to drain requests")
+	}
+	if err := writeBacks.Flush(shutdownCtx); err != nil {
+		log.Logger.Error().Err(err).Msg("Failed to flush pending writes")
+	}
+	drainPendingWrites(shutdownCtx)
+
+	cancelApp()
+	if webhooks != nil {
+		webhooks.wait()
 	}
-	log.Logger.Error().Str("msg", "month data not found").Msg("Data not found")
-	c.JSON(http.StatusNotFound, gin.H{"msg": "data not found"})
+	if err := storage.Close(); err != nil {
+		log.Logger.Error().Err(err).Msg("Failed to close storage")
+	}
+	log.Logger.Info().Msg("Server stopped")
 }
 
 // AddDailyHouse add daily house data
@@ -146,15 +224,21 @@ func addDailyHouse(c *gin.Context) {
 	var req DailyHouse
 	// Bind JSON body to struct
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		respondValidationError(c, bindingFieldErrors(err))
 		log.Logger.Error().Err(err).Msg("Failed to bind JSON")
 		return
 	}
+	if errs := validateDailyHouse(req, dayLayout); len(errs) > 0 {
+		respondValidationError(c, errs)
+		return
+	}
+	wctx := requestContext(c)
 
 	// Create daily house response
 	dailyResp := DailyHouseResp{
 		Day:       req.Day,
 		DailyData: req.DailyData,
+		Districts: req.Districts,
 	}
 
 	// Create monthly house response
@@ -166,29 +250,40 @@ func addDailyHouse(c *gin.Context) {
 	var dailyInMem bool
 	var monthInMem bool
 
-	m := GetInMemDataAccessor(beijing)
-	// Store in Redis
-	if err := StoreHouseData(ctx, req.Day, dailyResp, beijingKey); err != nil {
-		log.Logger.Error().Err(err).Str("day", req.Day).Msg("Failed to store house data in Redis")
+	m := GetInMemDataAccessor(regionStore(beijingKey))
+	// Store in Redis; a quarantined day is answered once the month is stored
+	dailyErr := StoreHouseData(wctx, req.Day, dailyResp, beijingKey)
+	if dailyErr != nil && !errors.Is(dailyErr, ErrQuarantined) {
+		log.Logger.Error().Err(dailyErr).Str("day", req.Day).Msg("Failed to store house data in Redis")
+		queueHouseWrite(wctx, req.Day, dailyResp, beijingKey)
 		dailyInMem = true
 	}
 
-	if err := StoreMonthHouseData(ctx, req.Month, monthResp, beijingKey); err != nil {
-		log.Logger.Error().Err(err).Str("month", req.Month).Msg("Failed to store month house data in Redis")
-		monthInMem = true
+	// Monthly data is optional
+	if req.Month != "" {
+		if err := StoreMonthHouseData(wctx, req.Month, monthResp, beijingKey); err != nil {
+			log.Logger.Error().Err(err).Str("month", req.Month).Msg("Failed to store month house data in Redis")
+			queueMonthHouseWrite(wctx, req.Month, monthResp, beijingKey)
+			monthInMem = true
+		}
 	}
 
 	// Also store in memory for backward compatibility
 	if dailyInMem {
-		if _, ok := m.Load(req.Day); !ok {
-			m.Store(req.Day, dailyResp)
-		}
+		m.Store(req.Day, dailyResp)
 	}
 	if monthInMem {
-		if _, ok := m.Load(req.Month); !ok {
-			m.Store(req.Month, monthResp)
-		}
+		m.Store(req.Month, monthResp)
+	}
+
+	if req.Month != "" {
+		publishRecord(beijingKey, config.DatasetMonthly, req.Month, eventSourceAdd, monthResp)
+	}
+	if errors.Is(dailyErr, ErrQuarantined) {
+		respondQuarantined(c, dailyErr)
+		return
 	}
+	publishRecord(beijingKey, config.DatasetOld, req.Day, eventSourceAdd, dailyResp)
 
 	log.Logger.Debug().Str("day", req.Day).Msg("Data added successfully")
 	c.JSON(http.StatusOK, req)
@@ -199,19 +294,20 @@ func forceAddHouse(c *gin.Context) {
 	var req DailyHouse
 	// Bind JSON body to struct
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		respondValidationError(c, bindingFieldErrors(err))
+		log.Logger.Error().Err(err).Msg("Failed to bind JSON")
 		return
 	}
-	key, ok := c.GetQuery("key")
-	if !ok || key != "huan_house" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid key"})
+	if errs := validateDailyHouse(req, dayLayout); len(errs) > 0 {
+		respondValidationError(c, errs)
 		return
 	}
-
+	wctx := requestContext(c)
 	// Create daily house response
 	dailyResp := DailyHouseResp{
 		Day:       req.Day,
 		DailyData: req.DailyData,
+		Districts: req.Districts,
 	}
 
 	// Create monthly house response
@@ -219,24 +315,51 @@ func forceAddHouse(c *gin.Context) {
 		Month:     req.Month,
 		MonthData: req.MonthData,
 	}
-	m := GetInMemDataAccessor(beijing)
+	m := GetInMemDataAccessor(regionStore(beijingKey))
 
-	// Store in Redis (force overwrite)
-	if err := StoreHouseData(ctx, req.Day, dailyResp, beijingKey); err != nil {
+	// Store in Redis (force overwrite); anomalies are flagged but not quarantined
+	fctx := withAnomalyMode(wctx, anomalyFlagOnly)
+	if err := StoreHouseData(fctx, req.Day, dailyResp, beijingKey); err != nil {
 		log.Logger.Error().Err(err).Str("day", req.Day).Msg("Failed to force store house data in Redis")
+		queueHouseWrite(fctx, req.Day, dailyResp, beijingKey)
 	}
 
-	if err := StoreMonthHouseData(ctx, req.Month, monthResp, beijingKey); err != nil {
-		log.Logger.Error().Err(err).Str("month", req.Month).Msg("Failed to force store month house data in Redis")
+	// Monthly data is optional
+	if req.Month != "" {
+		if err := StoreMonthHouseData(wctx, req.Month, monthResp, beijingKey); err != nil {
+			log.Logger.Error().Err(err).Str("month", req.Month).Msg("Failed to force store month house data in Redis")
+			queueMonthHouseWrite(wctx, req.Month, monthResp, beijingKey)
+		}
 	}
 
 	// Always store in memory
 	m.Store(req.Day, dailyResp)
-	m.Store(req.Month, monthResp)
+	if req.Month != "" {
+		m.Store(req.Month, monthResp)
+	}
+
+	publishRecord(beijingKey, config.DatasetOld, req.Day, eventSourceForce, dailyResp)
+	if req.Month != "" {
+		publishRecord(beijingKey, config.DatasetMonthly, req.Month, eventSourceForce, monthResp)
+	}
 
 	c.JSON(http.StatusOK, req)
 }
 
+// health reports whether the service is backed by Redis or degraded to the
+// in-memory stores
+func health(c *gin.Context) {
+	if isDegraded() {
+		c.JSON(http.StatusOK, gin.H{
+			"msg":            "success",
+			"status":         "degraded",
+			"pending_writes": pendingWriteCount(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"msg": "success", "status": "ok"})
+}
+
 // getHousePeriod retrieves house data for a specific period (1, 7, or 30 days)
 func getHousePeriod(c *gin.Context) {
 	// Get period from URL parameter
@@ -256,8 +379,7 @@ func getHousePeriod(c *gin.Context) {
 		return
 	}
 
-	// Get region from query parameter (default to beijing)
-	region := c.DefaultQuery("region", beijingKey)
+	region := requestRegion(c)
 
 	// Get data for the specified period
 	data, err := GetHouseDataForPeriod(ctx, period, region)
@@ -273,51 +395,68 @@ func getHousePeriod(c *gin.Context) {
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{
+	body := gin.H{
 		"period": period,
 		"region": region,
 		"data":   data,
-	})
+	}
+	if !legacyResponse(c) {
+		body["summary"] = summarizeRecords(data)
+	}
+	c.JSON(http.StatusOK, withDerived(c, body))
 }
 
-// getShHousePeriod retrieves Shanghai house data for a specific period (1, 7, or 30 days)
-func getShHousePeriod(c *gin.Context) {
-	// Get period from URL parameter
-	daysParam := c.Param("days")
-	var period int
-
-	// Parse period
-	switch daysParam {
-	case "1":
-		period = 1
-	case "7":
-		period = 7
-	case "30":
-		period = 30
-	default:
-		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid period (must be 1, 7, or 30)"})
+// getHouseRange retrieves every house record stored between the from and to
+// query parameters (YYYY-MM-DD or YYYY-MM-DD-HH), sorted chronologically
+func getHouseRange(c *gin.Context) {
+	fromParam, ok := c.GetQuery("from")
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "missing from (YYYY-MM-DD or YYYY-MM-DD-HH)"})
+		return
+	}
+	from, err := parseRangeBound(fromParam, false)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid from (must be YYYY-MM-DD or YYYY-MM-DD-HH)"})
+		return
+	}
+	toParam := c.DefaultQuery("to", getTodayDay())
+	to, err := parseRangeBound(toParam, true)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid to (must be YYYY-MM-DD or YYYY-MM-DD-HH)"})
+		return
+	}
+	if err := validateRange(from, to); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	// Get data for the specified period
-	data, err := GetHouseDataForPeriod(ctx, period, shanghaiKey)
+	region := requestRegion(c)
+
+	// Get data for the specified range
+	data, err := GetHouseDataForRange(ctx, from, to, region)
 	if err != nil {
-		log.Logger.Error().Err(err).Int("period", period).Msg("Failed to get Shanghai house data for period")
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get Shanghai house data"})
+		log.Logger.Error().Err(err).Str("from", fromParam).Str("to", toParam).Str("region", region).Msg("Failed to get house data for range")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get house data"})
 		return
 	}
 
 	// Return data
 	if len(data) == 0 {
-		c.JSON(http.StatusNotFound, gin.H{"msg": "no Shanghai house data found for the specified period"})
+		c.JSON(http.StatusNotFound, gin.H{"msg": "no data found for the specified range"})
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{
-		"period": period,
-		"region": shanghaiKey,
+	body := gin.H{
+		"from":   fromParam,
+		"to":     toParam,
+		"region": region,
+		"count":  len(data),
 		"data":   data,
-	})
+	}
+	if !legacyResponse(c) {
+		body["summary"] = summarizeRecords(data)
+	}
+	c.JSON(http.StatusOK, withDerived(c, body))
 }
 
 // Middleware
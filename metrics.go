@@ -0,0 +1,102 @@
+package main
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-redis/redis/v8"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Cache lookup sources for the read handlers
+const (
+	sourceRedis  = "redis"  // served from storage
+	sourceMemory = "memory" // served from the in-memory fallback
+	sourceMiss   = "miss"   // not found anywhere
+)
+
+var (
+	httpRequestsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "house_http_requests_total",
+		Help: "HTTP requests by route, method and status.",
+	}, []string{"route", "method", "status"})
+
+	httpRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "house_http_request_duration_seconds",
+		Help:    "HTTP request latency by route, method and status.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"route", "method", "status"})
+
+	cacheLookupsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "house_cache_lookups_total",
+		Help: "Read handler lookups by route and source (redis, memory or miss).",
+	}, []string{"route", "source"})
+
+	redisErrorsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "house_redis_errors_total",
+		Help: "Failed Redis commands by operation.",
+	}, []string{"operation"})
+
+	writeBackFailuresTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "house_write_back_failures_total",
+		Help: "Failed background writes to storage by kind.",
+	}, []string{"kind"})
+
+	anomaliesTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "house_anomalies_total",
+		Help: "Ingested daily records flagged as anomalous by region and whether they were quarantined.",
+	}, []string{"region", "quarantined"})
+
+	webhookDeliveriesTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "house_webhook_deliveries_total",
+		Help: "Webhook delivery outcomes (delivered, retried or dead_lettered).",
+	}, []string{"result"})
+
+	_ = promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "house_event_streams",
+		Help: "Open Server-Sent Events streams.",
+	}, func() float64 {
+		return float64(events.subscriberCount())
+	})
+
+	_ = promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "house_degraded",
+		Help: "1 while Redis is unreachable and data is served from memory.",
+	}, func() float64 {
+		if isDegraded() {
+			return 1
+		}
+		return 0
+	})
+)
+
+// metricsMiddleware records request counts and latency per route and status
+func metricsMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+
+		c.Next()
+
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+		status := strconv.Itoa(c.Writer.Status())
+		httpRequestsTotal.WithLabelValues(route, c.Request.Method, status).Inc()
+		httpRequestDuration.WithLabelValues(route, c.Request.Method, status).Observe(time.Since(start).Seconds())
+	}
+}
+
+// recordCacheLookup counts where a read handler found its data
+func recordCacheLookup(c *gin.Context, source string) {
+	cacheLookupsTotal.WithLabelValues(c.FullPath(), source).Inc()
+}
+
+// recordRedisError counts a failed Redis command; a missing key is not an error
+func recordRedisError(operation string, err error) {
+	if err != nil && err != redis.Nil {
+		redisErrorsTotal.WithLabelValues(operation).Inc()
+	}
+}
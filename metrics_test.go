@@ -0,0 +1,51 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-redis/redis/v8"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestMetricsMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(metricsMiddleware())
+	router.GET("/v1/house/:day", func(c *gin.Context) {
+		recordCacheLookup(c, sourceMemory)
+		c.Status(http.StatusNotFound)
+	})
+
+	requests := httpRequestsTotal.WithLabelValues("/v1/house/:day", http.MethodGet, "404")
+	lookups := cacheLookupsTotal.WithLabelValues("/v1/house/:day", sourceMemory)
+	unmatched := httpRequestsTotal.WithLabelValues("unmatched", http.MethodGet, "404")
+	before := []float64{testutil.ToFloat64(requests), testutil.ToFloat64(lookups), testutil.ToFloat64(unmatched)}
+
+	for _, path := range []string{"/v1/house/2025-06-09", "/v1/house/2025-06-10", "/nowhere"} {
+		router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+
+	// Requests are labelled by route, not by path
+	after := []float64{testutil.ToFloat64(requests), testutil.ToFloat64(lookups), testutil.ToFloat64(unmatched)}
+	if after[0]-before[0] != 2 || after[1]-before[1] != 2 || after[2]-before[2] != 1 {
+		t.Errorf("got counter increments %v -> %v", before, after)
+	}
+	if n := testutil.CollectAndCount(httpRequestDuration, "house_http_request_duration_seconds"); n < 2 {
+		t.Errorf("want a latency histogram per route and status, got %d", n)
+	}
+}
+
+func TestRecordRedisError(t *testing.T) {
+	errorsTotal := redisErrorsTotal.WithLabelValues("get")
+	before := testutil.ToFloat64(errorsTotal)
+	recordRedisError("get", nil)
+	recordRedisError("get", redis.Nil)
+	recordRedisError("get", errors.New("connection refused"))
+	if n := testutil.ToFloat64(errorsTotal) - before; n != 1 {
+		t.Errorf("want only the failed command counted, got %v", n)
+	}
+}
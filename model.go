@@ -2,10 +2,11 @@ package main
 
 // DailyHouse  daily house req data
 type DailyHouse struct {
-	MonthData MonthData `json:"month_data"`
-	Month     string    `json:"month"`
-	Day       string    `json:"day"`
-	DailyData DailyData `json:"daily_data"`
+	MonthData MonthData            `json:"month_data"`
+	Month     string               `json:"month"`
+	Day       string               `json:"day" binding:"required"`
+	DailyData DailyData            `json:"daily_data"`
+	Districts map[string]DailyData `json:"districts,omitempty" binding:"omitempty,dive"` // Optional breakdown adding up to DailyData
 }
 
 // // BeijingHouseRequest request model for Beijing house data without month-related fields
@@ -16,32 +17,81 @@ type DailyHouse struct {
 
 // DailyHouseResp  daily house resp data
 type DailyHouseResp struct {
-	Day       string    `json:"day"`
-	DailyData DailyData `json:"daily_data"`
+	Day       string               `json:"day" binding:"required"`
+	DailyData DailyData            `json:"daily_data"`
+	Districts map[string]DailyData `json:"districts,omitempty" binding:"omitempty,dive"` // Stored under their own keys, see storeDistricts
+	Derived   *DerivedMetrics      `json:"derived,omitempty"`                            // Filled on read, never stored
+	DayType   string               `json:"day_type,omitempty"`                           // Filled on read, never stored
+	Holiday   string               `json:"holiday,omitempty"`                            // Filled on read, never stored
 }
 
 // MonthHouseResp HouseResp  month house resp data
 type MonthHouseResp struct {
-	MonthData MonthData `json:"month_data"`
-	Month     string    `json:"month"`
+	MonthData MonthData       `json:"month_data"`
+	Month     string          `json:"month"`
+	Derived   *DerivedMetrics `json:"derived,omitempty"` // Filled on read, never stored
 }
 
 // MonthData month house data
 type MonthData struct {
-	TotalCount float64 `json:"total_count"`
-	TotalArea  float64 `json:"total_area"`
-	HouseCount float64 `json:"house_count"`
-	HouseArea  float64 `json:"house_area"`
+	TotalCount float64 `json:"total_count" binding:"gte=0"`
+	TotalArea  float64 `json:"total_area" binding:"gte=0"`
+	HouseCount float64 `json:"house_count" binding:"gte=0"`
+	HouseArea  float64 `json:"house_area" binding:"gte=0"`
 }
 
 // DailyData daily house data
 type DailyData struct {
-	TotalCount float64 `json:"total_count"`
-	TotalArea  float64 `json:"total_area"`
-	HouseCount float64 `json:"house_count"`
-	HouseArea  float64 `json:"house_area"`
-	HousePrice float64 `json:"house_price"`
-	TotalPrice float64 `json:"total_price"`
+	TotalCount float64 `json:"total_count" binding:"gte=0"`
+	TotalArea  float64 `json:"total_area" binding:"gte=0"`
+	HouseCount float64 `json:"house_count" binding:"gte=0"`
+	HouseArea  float64 `json:"house_area" binding:"gte=0"`
+	HousePrice float64 `json:"house_price" binding:"gte=0"`
+	TotalPrice float64 `json:"total_price" binding:"gte=0"`
+}
+
+// houseMetrics are the DailyData fields by JSON name, usable as the metric
+// query parameter of the analysis endpoints
+var houseMetrics = []string{"total_count", "total_area", "house_count", "house_area", "house_price", "total_price"}
+
+// Metrics returns the fields of d by JSON name
+func (d DailyData) Metrics() map[string]float64 {
+	return map[string]float64{
+		"total_count": d.TotalCount,
+		"total_area":  d.TotalArea,
+		"house_count": d.HouseCount,
+		"house_area":  d.HouseArea,
+		"house_price": d.HousePrice,
+		"total_price": d.TotalPrice,
+	}
+}
+
+// Metric returns the field of d with the given JSON name
+func (d DailyData) Metric(name string) (float64, bool) {
+	v, ok := d.Metrics()[name]
+	return v, ok
+}
+
+// dailyDataFromMetrics builds DailyData from fields by JSON name
+func dailyDataFromMetrics(m map[string]float64) DailyData {
+	return DailyData{
+		TotalCount: m["total_count"],
+		TotalArea:  m["total_area"],
+		HouseCount: m["house_count"],
+		HouseArea:  m["house_area"],
+		HousePrice: m["house_price"],
+		TotalPrice: m["total_price"],
+	}
+}
+
+// Metrics returns the fields of d by JSON name
+func (d MonthData) Metrics() map[string]float64 {
+	return map[string]float64{
+		"total_count": d.TotalCount,
+		"total_area":  d.TotalArea,
+		"house_count": d.HouseCount,
+		"house_area":  d.HouseArea,
+	}
 }
 
 func getDefaultDailyHouse() DailyHouse {
@@ -67,7 +117,7 @@ func getDefaultDailyHouse() DailyHouse {
 
 // Poem model
 type Poem struct {
-	Day     string   `json:"day"`
+	Day     string   `json:"day" binding:"required"`
 	Name    string   `json:"name"`
 	Author  string   `json:"author"`
 	Content []string `json:"content"`
@@ -0,0 +1,271 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	defaultRankingSize = 10
+	maxRankingSize     = 100
+	defaultRecordDays  = 30 // N of the N-day high and low
+)
+
+// RankedDay is a day of a ranking
+type RankedDay struct {
+	Rank    int     `json:"rank"`
+	Day     string  `json:"day"`
+	Value   float64 `json:"value"`
+	DayType string  `json:"day_type"`
+	Holiday string  `json:"holiday,omitempty"`
+}
+
+// Streak is the run of consecutive changes, all in the same direction, that
+// ends at the latest record. Days without a record are skipped.
+type Streak struct {
+	Direction string `json:"direction"` // up, down or flat
+	Length    int    `json:"length"`    // number of changes
+	Since     string `json:"since"`     // the record the run started from
+}
+
+// RecordStatus tells whether the latest record is a high or a low. HighDays
+// counts the days from the day after the last higher record to the latest
+// one; HighestSince is that higher record, null when there is none (an
+// all-time high). Lows work the same way.
+type RecordStatus struct {
+	Day          string  `json:"day"`
+	Value        float64 `json:"value"`
+	Window       int     `json:"window"`
+	NewHigh      bool    `json:"new_high"` // highest of the last Window days
+	NewLow       bool    `json:"new_low"`  // lowest of the last Window days
+	HighDays     int     `json:"high_days"`
+	LowDays      int     `json:"low_days"`
+	HighestSince *string `json:"highest_since"`
+	LowestSince  *string `json:"lowest_since"`
+	AllTimeHigh  bool    `json:"all_time_high"`
+	AllTimeLow   bool    `json:"all_time_low"`
+}
+
+// rankDays returns the n highest (desc) or lowest days of values; ties go to
+// the earlier day
+func rankDays(values map[string]float64, n int, desc bool) []RankedDay {
+	days := make([]string, 0, len(values))
+	for day := range values {
+		days = append(days, day)
+	}
+	sort.Slice(days, func(i, j int) bool {
+		a, b := values[days[i]], values[days[j]]
+		if a != b {
+			return (a > b) == desc
+		}
+		return days[i] < days[j]
+	})
+	if len(days) > n {
+		days = days[:n]
+	}
+	ranked := make([]RankedDay, len(days))
+	for i, day := range days {
+		ranked[i] = RankedDay{Rank: i + 1, Day: day, Value: values[day]}
+		if info := dayInfo(day); info != nil {
+			ranked[i].DayType, ranked[i].Holiday = info.Type, info.Holiday
+		}
+	}
+	return ranked
+}
+
+// recordScan walks the records of a region from the newest to the oldest and
+// works out the current streak and how far back the latest value is a high
+// or a low
+type recordScan struct {
+	latestDay   string
+	latest      float64
+	higherDay   string
+	lowerDay    string
+	streak      Streak
+	streakDone  bool
+	prevDay     string
+	prev        float64
+	initialized bool
+}
+
+// feed adds the next older record and reports whether the scan is complete
+func (s *recordScan) feed(day string, v float64) bool {
+	if !s.initialized {
+		s.latestDay, s.latest = day, v
+		s.prevDay, s.prev = day, v
+		s.streak = Streak{Direction: "flat", Since: day}
+		s.initialized = true
+		return false
+	}
+
+	if s.higherDay == "" && v > s.latest {
+		s.higherDay = day
+	}
+	if s.lowerDay == "" && v < s.latest {
+		s.lowerDay = day
+	}
+
+	if !s.streakDone {
+		// s.prev is the newer of the two records
+		direction := "flat"
+		if s.prev > v {
+			direction = "up"
+		} else if s.prev < v {
+			direction = "down"
+		}
+		if s.streak.Length == 0 || direction == s.streak.Direction {
+			s.streak.Direction = direction
+			s.streak.Length++
+			s.streak.Since = day
+		} else {
+			s.streakDone = true
+		}
+	}
+	s.prevDay, s.prev = day, v
+
+	return s.streakDone && s.higherDay != "" && s.lowerDay != ""
+}
+
+// status reports the latest record against the history scanned so far; the
+// oldest day scanned bounds the all-time counts
+func (s *recordScan) status(window int) RecordStatus {
+	latest, _ := time.Parse(dayLayout, s.latestDay)
+	daysSince := func(other string) int {
+		t, _ := time.Parse(dayLayout, other)
+		return int(latest.Sub(t).Hours()/24) + 1
+	}
+
+	st := RecordStatus{Day: s.latestDay, Value: s.latest, Window: window}
+	if s.higherDay != "" {
+		higher := s.higherDay
+		st.HighestSince = &higher
+		st.HighDays = daysSince(higher) - 1
+	} else {
+		st.AllTimeHigh = true
+		st.HighDays = daysSince(s.prevDay)
+	}
+	if s.lowerDay != "" {
+		lower := s.lowerDay
+		st.LowestSince = &lower
+		st.LowDays = daysSince(lower) - 1
+	} else {
+		st.AllTimeLow = true
+		st.LowDays = daysSince(s.prevDay)
+	}
+	st.NewHigh = st.HighDays >= window
+	st.NewLow = st.LowDays >= window
+	return st
+}
+
+// scanRecords feeds the daily records of region to scan, newest first, one
+// max_range_days window of the day index at a time. It stops when the scan is
+// complete or a window holds no record at all, which is taken as the start of
+// the data. It returns false when the region has no daily record.
+func scanRecords(ctx context.Context, region, metric string, scan *recordScan) (bool, error) {
+	span := appConfig.MaxRangeDays
+	today, _ := time.Parse(dayLayout, getTodayDay())
+	to := today.Add(24*time.Hour - time.Second)
+	for {
+		from := to.AddDate(0, 0, -span).Add(time.Second)
+		days, err := GetHouseDaysInRange(ctx, from, to, region)
+		if err != nil {
+			return false, err
+		}
+		if len(days) == 0 {
+			return scan.initialized, nil
+		}
+		values, err := GetMetricValues(ctx, from, to, region, metric)
+		if err != nil {
+			return false, err
+		}
+		ordered := make([]string, 0, len(values))
+		for day := range values {
+			ordered = append(ordered, day)
+		}
+		sort.Sort(sort.Reverse(sort.StringSlice(ordered)))
+		for _, day := range ordered {
+			if scan.feed(day, values[day]) {
+				return true, nil
+			}
+		}
+		to = from.Add(-time.Second)
+	}
+}
+
+// getRankings returns the n (default 10) highest and lowest days of metric
+// (default total_count) between from (default 90 days ago) and to (default
+// today), the current streak and whether the latest record is a new
+// window-day (default 30) high or low
+func getRankings(c *gin.Context) {
+	region := requestRegion(c)
+	metric := c.DefaultQuery("metric", "total_count")
+	n, nErr := strconv.Atoi(c.DefaultQuery("n", strconv.Itoa(defaultRankingSize)))
+	window, windowErr := strconv.Atoi(c.DefaultQuery("window", strconv.Itoa(defaultRecordDays)))
+	toParam := c.DefaultQuery("to", getTodayDay())
+	to, toErr := time.Parse(dayLayout, toParam)
+	fromParam := c.DefaultQuery("from", to.AddDate(0, 0, -89).Format(dayLayout))
+
+	var errs []FieldError
+	if !slices.Contains(houseMetrics, metric) {
+		errs = append(errs, FieldError{Field: "metric", Rule: "oneof", Message: "must be one of " + strings.Join(houseMetrics, ", ")})
+	}
+	if nErr != nil || n < 1 || n > maxRankingSize {
+		errs = append(errs, FieldError{Field: "n", Rule: "range", Message: fmt.Sprintf("must be between 1 and %d", maxRankingSize)})
+	}
+	if windowErr != nil || window < 2 {
+		errs = append(errs, FieldError{Field: "window", Rule: "min", Message: "must be at least 2"})
+	}
+	errs = append(errs, checkDate("from", fromParam, dayLayout)...)
+	if toErr != nil {
+		errs = append(errs, checkDate("to", toParam, dayLayout)...)
+	}
+	if len(errs) > 0 {
+		respondValidationError(c, errs)
+		return
+	}
+	from, _ := time.Parse(dayLayout, fromParam)
+	if err := validateRange(from, to); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	values, err := GetMetricValues(ctx, from, to.Add(24*time.Hour-time.Second), region, metric)
+	if err != nil {
+		log.Logger.Error().Err(err).Str("from", fromParam).Str("to", toParam).Str("region", region).Msg("Failed to get house data for rankings")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get house data"})
+		return
+	}
+
+	var scan recordScan
+	found, err := scanRecords(ctx, region, metric, &scan)
+	if err != nil {
+		log.Logger.Error().Err(err).Str("region", region).Msg("Failed to scan house records")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get house data"})
+		return
+	}
+	if !found {
+		c.JSON(http.StatusNotFound, gin.H{"msg": "data not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"region": region,
+		"metric": metric,
+		"from":   fromParam,
+		"to":     toParam,
+		"days":   len(values),
+		"top":    rankDays(values, n, true),
+		"bottom": rankDays(values, n, false),
+		"streak": scan.streak,
+		"latest": scan.status(window),
+	})
+}
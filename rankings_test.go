@@ -0,0 +1,60 @@
+package main
+
+import "testing"
+
+func TestRankDays(t *testing.T) {
+	values := map[string]float64{"2025-04-01": 5, "2025-04-02": 9, "2025-04-03": 5, "2025-04-04": 1}
+	top := rankDays(values, 2, true)
+	if len(top) != 2 || top[0].Day != "2025-04-02" || top[1].Day != "2025-04-01" || top[1].Rank != 2 {
+		t.Errorf("unexpected top %+v", top)
+	}
+	bottom := rankDays(values, 10, false)
+	if len(bottom) != 4 || bottom[0].Day != "2025-04-04" || bottom[3].Day != "2025-04-02" {
+		t.Errorf("unexpected bottom %+v", bottom)
+	}
+}
+
+func TestRecordScan(t *testing.T) {
+	// Newest first: three increases in a row, a higher day ten days back
+	history := []struct {
+		day   string
+		value float64
+	}{
+		{"2025-04-20", 50},
+		{"2025-04-19", 40},
+		{"2025-04-17", 30},
+		{"2025-04-16", 20},
+		{"2025-04-15", 25},
+		{"2025-04-10", 60},
+		{"2025-04-01", 10},
+	}
+	var scan recordScan
+	done := false
+	for _, h := range history {
+		done = scan.feed(h.day, h.value)
+	}
+	if !done {
+		t.Error("scan should be complete once a higher and a lower record are found")
+	}
+	if s := scan.streak; s.Direction != "up" || s.Length != 3 || s.Since != "2025-04-16" {
+		t.Errorf("unexpected streak %+v", s)
+	}
+	st := scan.status(7)
+	if st.HighestSince == nil || *st.HighestSince != "2025-04-10" || st.HighDays != 10 || !st.NewHigh {
+		t.Errorf("unexpected high %+v", st)
+	}
+	if st.LowestSince == nil || *st.LowestSince != "2025-04-19" || st.LowDays != 1 || st.NewLow {
+		t.Errorf("unexpected low %+v", st)
+	}
+}
+
+func TestRecordScanAllTime(t *testing.T) {
+	var scan recordScan
+	scan.feed("2025-04-03", 30)
+	scan.feed("2025-04-02", 20)
+	scan.feed("2025-04-01", 10)
+	st := scan.status(30)
+	if !st.AllTimeHigh || st.HighestSince != nil || st.HighDays != 3 || st.NewHigh {
+		t.Errorf("unexpected status %+v", st)
+	}
+}
@@ -25,6 +25,13 @@ const (
 
 // StoreFortuneData stores fortune data in Redis permanently (no expiration)
 func StoreFortuneData(ctx context.Context, day string, data Poem) error {
+	// The day is the sorted set score, so it must parse
+	t, err := parseDay(day)
+	if err != nil {
+		log.Logger.Error().Err(err).Str("day", day).Msg("Invalid fortune day")
+		return err
+	}
+
 	// Key format: fortune:day:{day}
 	key := formatFortuneKey(day)
 
@@ -36,7 +43,7 @@ func StoreFortuneData(ctx context.Context, day string, data Poem) error {
 	}
 
 	// Store in Redis permanently (no expiration)
-	err = redisDB.Set(ctx, key, jsonData, NoExpiration).Err()
+	err = setWithHistory(ctx, key, jsonData)
 	if err != nil {
 		log.Logger.Error().Err(err).Str("key", key).Msg("Failed to store fortune data in Redis")
 		return err
@@ -44,14 +51,9 @@ func StoreFortuneData(ctx context.Context, day string, data Poem) error {
 
 	// Add the day to a sorted set for easy retrieval of recent days
 	// Score is Unix timestamp for that day (start of day)
-	t, _ := parseDay(day)
 	score := float64(t.Unix())
 
-	err = redisDB.ZAdd(ctx, FortuneDaysSetKey, &redis.Z{
-		Score:  score,
-		Member: day,
-	}).Err()
-
+	err = storageFor(ctx).ZAdd(ctx, FortuneDaysSetKey, score, day)
 	if err != nil {
 		log.Logger.Error().Err(err).Str("day", day).Msg("Failed to add day to sorted set")
 		return err
@@ -69,13 +71,13 @@ func GetFortuneData(ctx context.Context, day string) (Poem, bool, error) {
 	key := formatFortuneKey(day)
 
 	// Get data from Redis
-	jsonData, err := redisDB.Get(ctx, key).Result()
-	if err == redis.Nil {
-		// Key does not exist
-		return poem, false, nil
-	} else if err != nil {
+	jsonData, found, err := storageFor(ctx).Get(ctx, key)
+	if err != nil {
 		log.Logger.Error().Err(err).Str("key", key).Msg("Failed to get fortune data from Redis")
 		return poem, false, err
+	} else if !found {
+		// Key does not exist
+		return poem, false, nil
 	}
 
 	// Unmarshal JSON data
@@ -100,10 +102,7 @@ func GetRecentFortuneDays(ctx context.Context, days int) ([]string, error) {
 	maxScore := float64(now.Unix())
 
 	// Get days from sorted set
-	result, err := redisDB.ZRangeByScore(ctx, FortuneDaysSetKey, &redis.ZRangeBy{
-		Min: fmt.Sprintf("%f", minScore),
-		Max: fmt.Sprintf("%f", maxScore),
-	}).Result()
+	result, err := storageFor(ctx).ZRangeByScore(ctx, FortuneDaysSetKey, minScore, maxScore)
 
 	if err != nil {
 		log.Logger.Error().Err(err).Int("days", days).Msg("Failed to get recent fortune days")
@@ -155,8 +154,14 @@ const (
 type RedisDB interface {
 	Set(ctx context.Context, key string, value interface{}, ttl time.Duration) *redis.StatusCmd
 	Get(ctx context.Context, key string) *redis.StringCmd
+	MGet(ctx context.Context, keys ...string) *redis.SliceCmd
+	Del(ctx context.Context, keys ...string) *redis.IntCmd
 	ZAdd(ctx context.Context, key string, members ...*redis.Z) *redis.IntCmd
+	ZRem(ctx context.Context, key string, members ...interface{}) *redis.IntCmd
 	ZRangeByScore(ctx context.Context, key string, opt *redis.ZRangeBy) *redis.StringSliceCmd
+	RPush(ctx context.Context, key string, values ...interface{}) *redis.IntCmd
+	LRange(ctx context.Context, key string, start, stop int64) *redis.StringSliceCmd
+	LTrim(ctx context.Context, key string, start, stop int64) *redis.StatusCmd
 }
 
 // ProductionRedisDB Production Redis client that implements RedisDB
@@ -166,22 +171,72 @@ type ProductionRedisDB struct {
 
 // Set stores a value in Redis with a specified key and TTL
 func (db *ProductionRedisDB) Set(ctx context.Context, key string, value interface{}, ttl time.Duration) *redis.StatusCmd {
-	return db.client.Set(ctx, key, value, ttl)
+	cmd := db.client.Set(ctx, key, value, ttl)
+	recordRedisError("set", cmd.Err())
+	return cmd
 }
 
 // Get retrieves a value from Redis by key
 func (db *ProductionRedisDB) Get(ctx context.Context, key string) *redis.StringCmd {
-	return db.client.Get(ctx, key)
+	cmd := db.client.Get(ctx, key)
+	recordRedisError("get", cmd.Err())
+	return cmd
+}
+
+// MGet retrieves the values of several keys
+func (db *ProductionRedisDB) MGet(ctx context.Context, keys ...string) *redis.SliceCmd {
+	cmd := db.client.MGet(ctx, keys...)
+	recordRedisError("mget", cmd.Err())
+	return cmd
+}
+
+// Del removes keys
+func (db *ProductionRedisDB) Del(ctx context.Context, keys ...string) *redis.IntCmd {
+	cmd := db.client.Del(ctx, keys...)
+	recordRedisError("del", cmd.Err())
+	return cmd
 }
 
 // ZAdd adds members to a sorted set
 func (db *ProductionRedisDB) ZAdd(ctx context.Context, key string, members ...*redis.Z) *redis.IntCmd {
-	return db.client.ZAdd(ctx, key, members...)
+	cmd := db.client.ZAdd(ctx, key, members...)
+	recordRedisError("zadd", cmd.Err())
+	return cmd
+}
+
+// ZRem removes members from a sorted set
+func (db *ProductionRedisDB) ZRem(ctx context.Context, key string, members ...interface{}) *redis.IntCmd {
+	cmd := db.client.ZRem(ctx, key, members...)
+	recordRedisError("zrem", cmd.Err())
+	return cmd
 }
 
 // ZRangeByScore retrieves members in a sorted set by score
 func (db *ProductionRedisDB) ZRangeByScore(ctx context.Context, key string, opt *redis.ZRangeBy) *redis.StringSliceCmd {
-	return db.client.ZRangeByScore(ctx, key, opt)
+	cmd := db.client.ZRangeByScore(ctx, key, opt)
+	recordRedisError("zrangebyscore", cmd.Err())
+	return cmd
+}
+
+// RPush appends values to a list
+func (db *ProductionRedisDB) RPush(ctx context.Context, key string, values ...interface{}) *redis.IntCmd {
+	cmd := db.client.RPush(ctx, key, values...)
+	recordRedisError("rpush", cmd.Err())
+	return cmd
+}
+
+// LRange retrieves a range of list elements
+func (db *ProductionRedisDB) LRange(ctx context.Context, key string, start, stop int64) *redis.StringSliceCmd {
+	cmd := db.client.LRange(ctx, key, start, stop)
+	recordRedisError("lrange", cmd.Err())
+	return cmd
+}
+
+// LTrim trims a list to the elements between start and stop
+func (db *ProductionRedisDB) LTrim(ctx context.Context, key string, start, stop int64) *redis.StatusCmd {
+	cmd := db.client.LTrim(ctx, key, start, stop)
+	recordRedisError("ltrim", cmd.Err())
+	return cmd
 }
 
 // Global Redis DB instance
@@ -195,26 +250,55 @@ func InitRedis(ctx context.Context, redisCfg *config.RedisConfig) RedisDB {
 		DB:       redisCfg.DB,
 	})
 
-	// Test the connection
+	// Initialize the production Redis DB
+	redisDB = &ProductionRedisDB{client: redisClient}
+
+	// Test the connection, starting in degraded mode if Redis is down
 	pong, err := redisClient.Ping(ctx).Result()
 	if err != nil {
-		log.Logger.Error().Err(err).Msg("Failed to connect to Redis")
-		panic(fmt.Sprintf("Failed to connect to Redis: %v", err))
+		log.Logger.Error().Err(err).Msg("Failed to connect to Redis, starting in degraded mode")
+		redisDegraded.Store(true)
+	} else {
+		log.Logger.Info().Str("pong", pong).Msg("Connected to Redis")
 	}
 
-	// Initialize the production Redis DB
-	redisDB = &ProductionRedisDB{client: redisClient}
+	// Keep checking the connection in the background
+	interval := time.Duration(redisCfg.ReconnectInterval) * time.Second
+	go watchRedis(ctx, interval)
 
-	log.Logger.Info().Str("pong", pong).Msg("Connected to Redis")
 	return redisDB
 }
 
 // StoreHouseData stores house data in Redis permanently (no expiration)
 func StoreHouseData(ctx context.Context, day string, data DailyHouseResp, region string) error {
+	// The day is the sorted set score, so it must parse
+	t, err := parseDay(day)
+	if err != nil {
+		log.Logger.Error().Err(err).Str("day", day).Msg("Invalid house day")
+		return err
+	}
+
+	// Score new records against the recent history; scoring problems do not
+	// block the write
+	anomaly, err := checkAnomaly(ctx, region, day, data)
+	if err != nil {
+		log.Logger.Error().Err(err).Str("day", day).Str("region", region).Msg("Failed to score house data")
+	} else if anomaly != nil {
+		if err := recordAnomaly(ctx, anomaly, data); err != nil {
+			return err
+		}
+		if anomaly.Quarantined {
+			return &QuarantinedError{Anomaly: *anomaly}
+		}
+	}
+
 	// Key format: house:daily:{region}:{day}
 	key := formatDailyKey(region, day)
 
-	// Convert data to JSON
+	// Convert data to JSON; derived metrics and day types are computed on read
+	// and districts have their own keys
+	districts := data.Districts
+	data.Districts, data.Derived, data.DayType, data.Holiday = nil, nil, "", ""
 	jsonData, err := json.Marshal(data)
 	if err != nil {
 		log.Logger.Error().Err(err).Msg("Failed to marshal house data")
@@ -222,26 +306,32 @@ func StoreHouseData(ctx context.Context, day string, data DailyHouseResp, region
 	}
 
 	// Store in Redis permanently (no expiration)
-	if err := redisDB.Set(ctx, key, jsonData, NoExpiration).Err(); err != nil {
+	if err := setWithHistory(ctx, key, jsonData); err != nil {
 		log.Logger.Error().Err(err).Str("key", key).Msg("Failed to store house data in Redis")
 		return err
 	}
 
 	// Add the day to a sorted set for easy retrieval of recent days
 	// Score is Unix timestamp for that day (start of day)
-	t, _ := parseDay(day)
 	score := float64(t.Unix())
 
 	// Use region-specific sorted set
 	daysSetKey := formatDaysSetKey(region)
-	if err := redisDB.ZAdd(ctx, daysSetKey, &redis.Z{
-		Score:  score,
-		Member: day,
-	}).Err(); err != nil {
+	if err := storageFor(ctx).ZAdd(ctx, daysSetKey, score, day); err != nil {
 		log.Logger.Error().Err(err).Str("day", day).Msg("Failed to add day to sorted set")
 		return err
 	}
 
+	if err := storeDistricts(ctx, region, day, t, districts); err != nil {
+		log.Logger.Error().Err(err).Str("day", day).Str("region", region).Msg("Failed to store district data")
+		return err
+	}
+
+	// Aggregates are derived data; a failed refresh does not fail the write
+	if err := refreshAggregates(ctx, region, day); err != nil {
+		log.Logger.Error().Err(err).Str("day", day).Str("region", region).Msg("Failed to refresh house aggregates")
+	}
+
 	log.Logger.Debug().Str("key", key).Msg("House data stored in Redis")
 	return nil
 }
@@ -251,7 +341,8 @@ func StoreMonthHouseData(ctx context.Context, month string, data MonthHouseResp,
 	// Key format: house:monthly:{region}:{month}
 	key := formatMonthlyKey(region, month)
 
-	// Convert data to JSON
+	// Convert data to JSON; derived metrics are computed on read
+	data.Derived = nil
 	jsonData, err := json.Marshal(data)
 	if err != nil {
 		log.Logger.Error().Err(err).Msg("Failed to marshal month house data")
@@ -259,11 +350,18 @@ func StoreMonthHouseData(ctx context.Context, month string, data MonthHouseResp,
 	}
 
 	// Store in Redis permanently (no expiration)
-	if err := redisDB.Set(ctx, key, jsonData, NoExpiration).Err(); err != nil {
+	if err := setWithHistory(ctx, key, jsonData); err != nil {
 		log.Logger.Error().Err(err).Str("key", key).Msg("Failed to store month house data in Redis")
 		return err
 	}
 
+	// Re-check the derived month against the posted data
+	if t, err := time.Parse(monthLayout, month); err == nil && !aggregatesDeferred(ctx) {
+		if _, err := refreshAggregate(ctx, region, PeriodMonth, t); err != nil {
+			log.Logger.Error().Err(err).Str("month", month).Str("region", region).Msg("Failed to refresh month aggregate")
+		}
+	}
+
 	log.Logger.Debug().Str("key", key).Msg("Month house data stored in Redis")
 	return nil
 }
@@ -276,13 +374,13 @@ func GetHouseData(ctx context.Context, day string, region string) (DailyHouseRes
 	key := formatDailyKey(region, day)
 
 	// Get data from Redis
-	jsonData, err := redisDB.Get(ctx, key).Result()
-	if err == redis.Nil {
-		// Key does not exist
-		return houseData, false, nil
-	} else if err != nil {
+	jsonData, found, err := storageFor(ctx).Get(ctx, key)
+	if err != nil {
 		log.Logger.Error().Err(err).Str("key", key).Msg("Failed to get house data from Redis")
 		return houseData, false, err
+	} else if !found {
+		// Key does not exist
+		return houseData, false, nil
 	}
 
 	// Unmarshal JSON data
@@ -295,6 +393,55 @@ func GetHouseData(ctx context.Context, day string, region string) (DailyHouseRes
 	return houseData, true, nil
 }
 
+// storedHouseDays reports which of days have house data stored, in one read
+func storedHouseDays(ctx context.Context, region string, days []string) (map[string]bool, error) {
+	keys := make([]string, len(days))
+	for i, day := range days {
+		keys[i] = formatDailyKey(region, day)
+	}
+	values, err := storageFor(ctx).MGet(ctx, keys...)
+	if err != nil {
+		log.Logger.Error().Err(err).Str("region", region).Int("days", len(days)).Msg("Failed to get house data from Redis")
+		return nil, err
+	}
+	stored := make(map[string]bool, len(values))
+	for i, day := range days {
+		if _, ok := values[keys[i]]; ok {
+			stored[day] = true
+		}
+	}
+	return stored, nil
+}
+
+// getHouseRecords retrieves the house data stored for days in one read,
+// leaving out the days without data
+func getHouseRecords(ctx context.Context, region string, days []string) ([]DailyHouseResp, error) {
+	keys := make([]string, len(days))
+	for i, day := range days {
+		keys[i] = formatDailyKey(region, day)
+	}
+	values, err := storageFor(ctx).MGet(ctx, keys...)
+	if err != nil {
+		log.Logger.Error().Err(err).Str("region", region).Int("days", len(days)).Msg("Failed to get house data from Redis")
+		return nil, err
+	}
+
+	var records []DailyHouseResp
+	for _, key := range keys {
+		value, ok := values[key]
+		if !ok {
+			continue
+		}
+		var record DailyHouseResp
+		if err := json.Unmarshal([]byte(value), &record); err != nil {
+			log.Logger.Error().Err(err).Str("key", key).Msg("Failed to unmarshal house data")
+			return nil, err
+		}
+		records = append(records, record)
+	}
+	return records, nil
+}
+
 // GetMonthHouseData retrieves monthly house data
 func GetMonthHouseData(ctx context.Context, month string, region string) (MonthHouseResp, bool, error) {
 	var monthData MonthHouseResp
@@ -303,13 +450,13 @@ func GetMonthHouseData(ctx context.Context, month string, region string) (MonthH
 	key := formatMonthlyKey(region, month)
 
 	// Get data from Redis
-	jsonData, err := redisDB.Get(ctx, key).Result()
-	if err == redis.Nil {
-		// Key does not exist
-		return monthData, false, nil
-	} else if err != nil {
+	jsonData, found, err := storageFor(ctx).Get(ctx, key)
+	if err != nil {
 		log.Logger.Error().Err(err).Str("key", key).Msg("Failed to get month house data from Redis")
 		return monthData, false, err
+	} else if !found {
+		// Key does not exist
+		return monthData, false, nil
 	}
 
 	// Unmarshal JSON data
@@ -337,10 +484,7 @@ func GetRecentHouseDays(ctx context.Context, days int, region string) ([]string,
 	daysSetKey := formatDaysSetKey(region)
 
 	// Get days from sorted set
-	result, err := redisDB.ZRangeByScore(ctx, daysSetKey, &redis.ZRangeBy{
-		Min: fmt.Sprintf("%f", minScore),
-		Max: fmt.Sprintf("%f", maxScore),
-	}).Result()
+	result, err := storageFor(ctx).ZRangeByScore(ctx, daysSetKey, minScore, maxScore)
 
 	if err != nil {
 		log.Logger.Error().Err(err).Int("days", days).Msg("Failed to get recent house days")
@@ -385,6 +529,60 @@ func GetHouseDataForPeriod(ctx context.Context, period int, region string) ([]Da
 	return GetHouseDataForRecentDays(ctx, period, region)
 }
 
+// GetHouseDaysInRange retrieves the days stored for a region whose timestamp
+// falls within [from, to], ordered chronologically
+func GetHouseDaysInRange(ctx context.Context, from, to time.Time, region string) ([]string, error) {
+	// Use region-specific sorted set
+	daysSetKey := formatDaysSetKey(region)
+
+	// Sorted set members are returned in score order, i.e. chronologically
+	result, err := storageFor(ctx).ZRangeByScore(ctx, daysSetKey, float64(from.Unix()), float64(to.Unix()))
+	if err != nil {
+		log.Logger.Error().Err(err).Time("from", from).Time("to", to).Msg("Failed to get house days in range")
+		return nil, err
+	}
+	return result, nil
+}
+
+// GetHouseDataForRange retrieves every house record stored for a region
+// between from and to (inclusive)
+func GetHouseDataForRange(ctx context.Context, from, to time.Time, region string) ([]DailyHouseResp, error) {
+	// Validate range
+	if err := validateRange(from, to); err != nil {
+		return nil, err
+	}
+
+	days, err := GetHouseDaysInRange(ctx, from, to, region)
+	if err != nil {
+		return nil, err
+	}
+
+	var houseDataList []DailyHouseResp
+	for _, day := range days {
+		houseData, found, err := GetHouseData(ctx, day, region)
+		if err != nil {
+			log.Logger.Error().Err(err).Str("day", day).Msg("Error getting house data")
+			continue
+		}
+		if found {
+			houseDataList = append(houseDataList, houseData)
+		}
+	}
+
+	return houseDataList, nil
+}
+
+func validateRange(from, to time.Time) error {
+	if to.Before(from) {
+		return fmt.Errorf("invalid range: to %s is before from %s", to.Format(time.DateTime), from.Format(time.DateTime))
+	}
+	maxSpan := time.Duration(appConfig.MaxRangeDays) * 24 * time.Hour
+	if to.Sub(from) >= maxSpan {
+		return fmt.Errorf("invalid range: span exceeds %d days", appConfig.MaxRangeDays)
+	}
+	return nil
+}
+
 func validatePeriod(period int) error {
 
 	if period != oneDay && period != sevenDay && period != aMonth {
@@ -4,6 +4,7 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"sort"
 	"sync"
 	"time"
 
@@ -15,6 +16,7 @@ import (
 type MockRedisDB struct {
 	data       map[string]string
 	sortedSets map[string]map[string]float64
+	lists      map[string][]string
 	mu         sync.RWMutex
 }
 
@@ -23,6 +25,7 @@ func NewMockRedisDB() *MockRedisDB {
 	return &MockRedisDB{
 		data:       make(map[string]string),
 		sortedSets: make(map[string]map[string]float64),
+		lists:      make(map[string][]string),
 	}
 }
 
@@ -63,6 +66,35 @@ func (m *MockRedisDB) Get(ctx context.Context, key string) *redis.StringCmd {
 	return redis.NewStringResult("", redis.Nil)
 }
 
+// MGet implements RedisDB.MGet for the mock
+func (m *MockRedisDB) MGet(ctx context.Context, keys ...string) *redis.SliceCmd {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	values := make([]interface{}, len(keys))
+	for i, key := range keys {
+		if value, ok := m.data[key]; ok {
+			values[i] = value
+		}
+	}
+	return redis.NewSliceResult(values, nil)
+}
+
+// Del implements RedisDB.Del for the mock
+func (m *MockRedisDB) Del(ctx context.Context, keys ...string) *redis.IntCmd {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var n int64
+	for _, key := range keys {
+		if _, ok := m.data[key]; ok {
+			delete(m.data, key)
+			n++
+		}
+	}
+	return redis.NewIntResult(n, nil)
+}
+
 // ZAdd implements RedisDB.ZAdd for the mock
 func (m *MockRedisDB) ZAdd(ctx context.Context, key string, members ...*redis.Z) *redis.IntCmd {
 	m.mu.Lock()
@@ -84,6 +116,22 @@ func (m *MockRedisDB) ZAdd(ctx context.Context, key string, members ...*redis.Z)
 	return redis.NewIntResult(int64(len(members)), nil)
 }
 
+// ZRem implements RedisDB.ZRem for the mock
+func (m *MockRedisDB) ZRem(ctx context.Context, key string, members ...interface{}) *redis.IntCmd {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var n int64
+	for _, member := range members {
+		memberStr := fmt.Sprintf("%v", member)
+		if _, ok := m.sortedSets[key][memberStr]; ok {
+			delete(m.sortedSets[key], memberStr)
+			n++
+		}
+	}
+	return redis.NewIntResult(n, nil)
+}
+
 // ZRangeByScore implements RedisDB.ZRangeByScore for the mock
 func (m *MockRedisDB) ZRangeByScore(ctx context.Context, key string, opt *redis.ZRangeBy) *redis.StringSliceCmd {
 	m.mu.RLock()
@@ -103,15 +151,63 @@ func (m *MockRedisDB) ZRangeByScore(ctx context.Context, key string, opt *redis.
 			}
 		}
 
+		// Order by score, then lexicographically, as Redis does
+		sort.Slice(result, func(i, j int) bool {
+			if set[result[i]] != set[result[j]] {
+				return set[result[i]] < set[result[j]]
+			}
+			return result[i] < result[j]
+		})
+
 		return redis.NewStringSliceResult(result, nil)
 	}
 
 	return redis.NewStringSliceResult([]string{}, nil)
 }
 
-// EnableMockRedisForTesting replaces the global redisDB with a mock implementation for testing
+// RPush implements RedisDB.RPush for the mock
+func (m *MockRedisDB) RPush(ctx context.Context, key string, values ...interface{}) *redis.IntCmd {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, value := range values {
+		switch v := value.(type) {
+		case string:
+			m.lists[key] = append(m.lists[key], v)
+		case []byte:
+			m.lists[key] = append(m.lists[key], string(v))
+		default:
+			m.lists[key] = append(m.lists[key], fmt.Sprintf("%v", v))
+		}
+	}
+	return redis.NewIntResult(int64(len(m.lists[key])), nil)
+}
+
+// LRange implements RedisDB.LRange for the mock
+func (m *MockRedisDB) LRange(ctx context.Context, key string, start, stop int64) *redis.StringSliceCmd {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	list := m.lists[key]
+	from, to := listBounds(int64(len(list)), start, stop)
+	return redis.NewStringSliceResult(append([]string{}, list[from:to]...), nil)
+}
+
+// LTrim implements RedisDB.LTrim for the mock
+func (m *MockRedisDB) LTrim(ctx context.Context, key string, start, stop int64) *redis.StatusCmd {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	list := m.lists[key]
+	from, to := listBounds(int64(len(list)), start, stop)
+	m.lists[key] = append([]string{}, list[from:to]...)
+	return redis.NewStatusResult("OK", nil)
+}
+
+// EnableMockRedisForTesting replaces the global redisDB and storage with a mock implementation for testing
 func EnableMockRedisForTesting() *MockRedisDB {
 	mockDB := NewMockRedisDB()
 	redisDB = mockDB
+	storage = &RedisStorage{db: mockDB}
 	return mockDB
 }
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestGetHouseDataForRange(t *testing.T) {
+	EnableMockRedisForTesting()
+	for _, day := range []string{"2025-04-12", "2025-04-10", "2025-04-11-00", "2025-05-01"} {
+		if err := StoreHouseData(ctx, day, DailyHouseResp{Day: day}, beijingKey); err != nil {
+			t.Fatalf("store %s: %v", day, err)
+		}
+	}
+
+	from, _ := parseRangeBound("2025-04-10", false)
+	to, _ := parseRangeBound("2025-04-12", true)
+	data, err := GetHouseDataForRange(ctx, from, to, beijingKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"2025-04-10", "2025-04-11-00", "2025-04-12"}
+	if len(data) != len(want) {
+		t.Fatalf("got %d records, want %d", len(data), len(want))
+	}
+	for i, d := range data {
+		if d.Day != want[i] {
+			t.Errorf("record %d: got %s, want %s", i, d.Day, want[i])
+		}
+	}
+
+	to, _ = parseRangeBound("2026-05-01", true)
+	if _, err := GetHouseDataForRange(ctx, from, to, beijingKey); err == nil {
+		t.Error("expected error for range exceeding max span")
+	}
+}
+
+func TestStoreHouseDataHistory(t *testing.T) {
+	EnableMockRedisForTesting()
+	wctx := withWriteOrigin(ctx, writeOrigin{Caller: "tester", Source: "/v1/force_house"})
+	for _, count := range []float64{10, 10, 20} {
+		data := DailyHouseResp{Day: "2025-04-10", DailyData: DailyData{TotalCount: count}}
+		if err := StoreHouseData(wctx, data.Day, data, beijingKey); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	revisions, err := GetHistory(ctx, formatDailyKey(beijingKey, "2025-04-10"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Rewriting an unchanged value records no revision
+	if len(revisions) != 2 {
+		t.Fatalf("got %d revisions, want 2", len(revisions))
+	}
+	if revisions[0].Previous != nil || revisions[1].Previous == nil {
+		t.Error("previous value should only be set on the second revision")
+	}
+	if revisions[1].Version != 2 || revisions[1].Caller != "tester" || revisions[1].Source != "/v1/force_house" {
+		t.Errorf("unexpected revision %+v", revisions[1])
+	}
+}
+
+func TestAppendRevisionConcurrent(t *testing.T) {
+	EnableMockRedisForTesting()
+	const writers = 20
+	versions := make(chan int64, writers)
+	var wg sync.WaitGroup
+	for i := 0; i < writers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			version, err := appendRevision(ctx, Revision{Key: "house:daily:beijing:2025-04-10"})
+			if err != nil {
+				t.Error(err)
+			}
+			versions <- version
+		}()
+	}
+	wg.Wait()
+	close(versions)
+
+	seen := make(map[int64]bool)
+	for v := range versions {
+		if v < 1 || v > writers || seen[v] {
+			t.Errorf("duplicate or out of range version %d", v)
+		}
+		seen[v] = true
+	}
+}
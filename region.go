@@ -0,0 +1,452 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/LIUHUANUCAS/house/config"
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+)
+
+// regionCtxKey is the gin context key holding the default region of a route group
+const regionCtxKey = "region"
+
+// Region is a city of the registry
+type Region struct {
+	config.RegionConfig
+	location *time.Location
+	datasets map[string]*Dataset
+}
+
+// Dataset is a dataset of a region with its key layout resolved
+type Dataset struct {
+	config.DatasetConfig
+	layout string
+}
+
+// RegionRegistry holds the regions served, in configuration order
+type RegionRegistry struct {
+	regions map[string]*Region
+	codes   []string
+}
+
+// regionRegistry is the registry in use; InitRegions replaces it
+var regionRegistry = mustNewRegistry(config.DefaultRegions())
+
+func mustNewRegistry(cfgs []config.RegionConfig) *RegionRegistry {
+	registry, err := newRegistry(cfgs)
+	if err != nil {
+		panic(fmt.Sprintf("invalid built-in regions: %v", err))
+	}
+	return registry
+}
+
+func newRegistry(cfgs []config.RegionConfig) (*RegionRegistry, error) {
+	registry := &RegionRegistry{regions: make(map[string]*Region)}
+	for _, rc := range cfgs {
+		loc, err := time.LoadLocation(rc.Timezone)
+		if err != nil {
+			return nil, fmt.Errorf("region %s: %w", rc.Code, err)
+		}
+		region := &Region{RegionConfig: rc, location: loc, datasets: make(map[string]*Dataset)}
+		for _, dc := range rc.Datasets {
+			for stored, posted := range dc.Fields {
+				if !slices.Contains(houseMetrics, stored) || !slices.Contains(houseMetrics, posted) {
+					return nil, fmt.Errorf("region %s dataset %s: invalid field mapping %s <- %s", rc.Code, dc.Name, stored, posted)
+				}
+			}
+			ds := &Dataset{DatasetConfig: dc, layout: dayLayout}
+			switch dc.Granularity {
+			case config.GranularityHour:
+				ds.layout = dayHourLayout
+			case config.GranularityMonth:
+				ds.layout = monthLayout
+			}
+			region.datasets[dc.Name] = ds
+		}
+		registry.regions[rc.Code] = region
+		registry.codes = append(registry.codes, rc.Code)
+	}
+	return registry, nil
+}
+
+// InitRegions replaces the built-in registry with the configured regions
+func InitRegions(cfgs []config.RegionConfig) error {
+	registry, err := newRegistry(cfgs)
+	if err != nil {
+		return err
+	}
+	regionRegistry = registry
+	log.Logger.Info().Strs("regions", registry.codes).Msg("Loaded region registry")
+	return nil
+}
+
+// lookupRegion returns the registered region with the given code
+func lookupRegion(code string) (*Region, bool) {
+	region, ok := regionRegistry.regions[code]
+	return region, ok
+}
+
+// regionCodes returns the codes of the registered regions
+func regionCodes() []string {
+	return regionRegistry.codes
+}
+
+// Dataset returns the dataset of r with the given name
+func (r *Region) Dataset(name string) (*Dataset, bool) {
+	ds, ok := r.datasets[name]
+	return ds, ok
+}
+
+// now is the current time in the timezone of r
+func (r *Region) now() time.Time {
+	return time.Now().In(r.location)
+}
+
+// recentPeriods returns the keys (days, hours or months) of the periods whose
+// data should be published by now, newest first
+func (ds *Dataset) recentPeriods(now time.Time) []string {
+	periods := make([]string, 0, ds.Lookback)
+	for i := ds.Delay; i < ds.Delay+ds.Lookback; i++ {
+		switch ds.Granularity {
+		case config.GranularityHour:
+			periods = append(periods, now.Add(-time.Duration(i)*time.Hour).Format(ds.layout)+ds.KeySuffix)
+		case config.GranularityMonth:
+			first := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, now.Location())
+			periods = append(periods, first.AddDate(0, -i, 0).Format(ds.layout))
+		default:
+			periods = append(periods, now.AddDate(0, 0, -i).Format(ds.layout)+ds.KeySuffix)
+		}
+	}
+	return periods
+}
+
+// recordDay validates a posted day and returns its storage key; the key
+// suffix of the dataset may be given or left out. Datasets with a key suffix
+// also take YYYY-MM-DD-HH days, stored as posted, as the Beijing new-house
+// routes always did.
+func (ds *Dataset) recordDay(day string) (string, []FieldError) {
+	if ds.KeySuffix != "" && len(checkDate("day", day, dayHourLayout)) == 0 {
+		return day, nil
+	}
+	base := strings.TrimSuffix(day, ds.KeySuffix)
+	if errs := checkDate("day", base, ds.layout); len(errs) > 0 {
+		return "", errs
+	}
+	return base + ds.KeySuffix, nil
+}
+
+// mapData maps posted figures to the stored ones through the field mapping
+func (ds *Dataset) mapData(d DailyData) DailyData {
+	if len(ds.Fields) == 0 {
+		return d
+	}
+	posted := d.Metrics()
+	stored := make(map[string]float64, len(ds.Fields))
+	for field, from := range ds.Fields {
+		stored[field] = posted[from]
+	}
+	return dailyDataFromMetrics(stored)
+}
+
+// withRegion sets the default region for the handlers of a route group
+func withRegion(region string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Set(regionCtxKey, region)
+		c.Next()
+	}
+}
+
+// regionParam resolves the :region path parameter of the generic routes
+func regionParam() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		code := c.Param("region")
+		if _, ok := lookupRegion(code); !ok {
+			c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": "unknown region " + code})
+			return
+		}
+		c.Set(regionCtxKey, code)
+		c.Next()
+	}
+}
+
+// requestRegion returns the region of the path (/regions/:region), else the
+// one requested through the query string, falling back to the default region
+// of the route group (beijing if none is set)
+func requestRegion(c *gin.Context) string {
+	if region := c.Param("region"); region != "" {
+		return region
+	}
+	if region := c.Query("region"); region != "" {
+		return region
+	}
+	if region := c.GetString(regionCtxKey); region != "" {
+		return region
+	}
+	return beijingKey
+}
+
+// resolveDataset returns the region and dataset a handler serves, answering
+// 404 when either is not registered
+func resolveDataset(c *gin.Context, code, name string) (*Region, *Dataset, bool) {
+	region, ok := lookupRegion(code)
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "unknown region " + code})
+		return nil, nil, false
+	}
+	ds, ok := region.Dataset(name)
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("region %s has no %s dataset", code, name)})
+		return nil, nil, false
+	}
+	return region, ds, true
+}
+
+// listRegions returns the registry
+func listRegions(c *gin.Context) {
+	list := make([]config.RegionConfig, 0, len(regionCodes()))
+	for _, code := range regionCodes() {
+		region, _ := lookupRegion(code)
+		list = append(list, region.RegionConfig)
+	}
+	c.JSON(http.StatusOK, gin.H{"regions": list})
+}
+
+// latestRecord returns a handler serving the latest record of a dataset;
+// empty code and name are taken from the path
+func latestRecord(code, name string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		region, ds, ok := resolveDataset(c, orParam(c, code, "region"), orParam(c, name, "dataset"))
+		if !ok {
+			return
+		}
+		switch {
+		case ds.Name != config.DatasetMonthly:
+			latestDaily(c, region, ds)
+		case ds.Derived:
+			latestDerivedMonth(c, region, ds)
+		default:
+			latestMonth(c, region, ds)
+		}
+	}
+}
+
+// addRecord returns a handler storing a posted record of a dataset; empty
+// code and name are taken from the path
+func addRecord(code, name string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		region, ds, ok := resolveDataset(c, orParam(c, code, "region"), orParam(c, name, "dataset"))
+		if !ok {
+			return
+		}
+		if ds.Name == config.DatasetMonthly {
+			addMonth(c, region, ds)
+			return
+		}
+		addDaily(c, region, ds, false)
+	}
+}
+
+// addAlias returns the handler of a /v2/sh add route. Like the handlers the
+// registry replaced, it echoes the posted request and keeps a memory copy of
+// every record it accepts.
+func addAlias(code, name string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		region, ds, ok := resolveDataset(c, code, name)
+		if !ok {
+			return
+		}
+		addDaily(c, region, ds, true)
+	}
+}
+
+// bulkRecords returns a handler importing many records of a daily dataset;
+// empty code and name are taken from the path
+func bulkRecords(code, name string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		region, ds, ok := resolveDataset(c, orParam(c, code, "region"), orParam(c, name, "dataset"))
+		if !ok {
+			return
+		}
+		if ds.Name == config.DatasetMonthly {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "bulk import only accepts daily datasets"})
+			return
+		}
+		bulkImport(c, region.Code, ds)
+	}
+}
+
+func orParam(c *gin.Context, value, param string) string {
+	if value != "" {
+		return value
+	}
+	return c.Param(param)
+}
+
+// latestDaily serves the newest record of ds within its lookback, falling
+// back to the in-memory store when storage has nothing
+func latestDaily(c *gin.Context, region *Region, ds *Dataset) {
+	m := GetInMemDataAccessor(regionStore(region.Code))
+	for _, day := range ds.recentPeriods(region.now()) {
+		houseData, found, err := GetHouseData(ctx, day, region.Code)
+		if err != nil {
+			log.Logger.Error().Err(err).Str("day", day).Str("region", region.Code).Msg("Error getting house data from Redis")
+		} else if found {
+			recordCacheLookup(c, sourceRedis)
+			c.JSON(http.StatusOK, withDerived(c, houseData))
+			return
+		}
+
+		// Fallback to in-memory if Redis fails or data not found in Redis
+		if v, ok := m.Load(day); ok {
+			// Store in Redis for future use
+			dailyResp, ok := v.(DailyHouseResp)
+			if ok && !isDegraded() {
+				writeBacks.Go(func() {
+					if err := StoreHouseData(writeBackCtx, day, dailyResp, region.Code); err != nil {
+						writeBackFailuresTotal.WithLabelValues("house").Inc()
+						log.Logger.Error().Err(err).Str("day", day).Str("region", region.Code).Msg("Failed to store house data in Redis")
+					}
+				})
+			}
+			recordCacheLookup(c, sourceMemory)
+			c.JSON(http.StatusOK, withDerived(c, v))
+			return
+		}
+	}
+	log.Logger.Error().Str("region", region.Code).Str("dataset", ds.Name).Msg("Data not found")
+	recordCacheLookup(c, sourceMiss)
+	c.JSON(http.StatusNotFound, gin.H{"msg": "data not found"})
+}
+
+// latestMonth serves the newest posted month of ds within its lookback
+func latestMonth(c *gin.Context, region *Region, ds *Dataset) {
+	m := GetInMemDataAccessor(regionStore(region.Code))
+	for _, month := range ds.recentPeriods(region.now()) {
+		monthData, found, err := GetMonthHouseData(ctx, month, region.Code)
+		if err != nil {
+			log.Logger.Error().Err(err).Str("month", month).Str("region", region.Code).Msg("Error getting month house data from Redis")
+		} else if found {
+			recordCacheLookup(c, sourceRedis)
+			c.JSON(http.StatusOK, withDerived(c, monthData))
+			return
+		}
+
+		// Fallback to in-memory if Redis fails or data not found in Redis
+		if v, ok := m.Load(month); ok {
+			// Store in Redis for future use
+			monthResp, ok := v.(MonthHouseResp)
+			if ok && !isDegraded() {
+				writeBacks.Go(func() {
+					if err := StoreMonthHouseData(writeBackCtx, month, monthResp, region.Code); err != nil {
+						writeBackFailuresTotal.WithLabelValues("month").Inc()
+						log.Logger.Error().Err(err).Str("month", month).Str("region", region.Code).Msg("Failed to store month house data in Redis")
+					}
+				})
+			}
+			recordCacheLookup(c, sourceMemory)
+			c.JSON(http.StatusOK, withDerived(c, v))
+			return
+		}
+	}
+	log.Logger.Error().Str("region", region.Code).Msg("Month data not found")
+	recordCacheLookup(c, sourceMiss)
+	c.JSON(http.StatusNotFound, gin.H{"msg": "data not found"})
+}
+
+// latestDerivedMonth serves the newest month aggregated from the daily records
+func latestDerivedMonth(c *gin.Context, region *Region, ds *Dataset) {
+	for _, month := range ds.recentPeriods(region.now()) {
+		agg, found, err := GetAggregate(ctx, region.Code, PeriodMonth, month)
+		if err != nil {
+			log.Logger.Error().Err(err).Str("month", month).Str("region", region.Code).Msg("Error getting month aggregate")
+			continue
+		}
+		if found && agg.Days > 0 {
+			recordCacheLookup(c, sourceRedis)
+			c.JSON(http.StatusOK, withDerived(c, agg.MonthHouse()))
+			return
+		}
+	}
+	recordCacheLookup(c, sourceMiss)
+	c.JSON(http.StatusNotFound, gin.H{"msg": "data not found"})
+}
+
+// addDaily stores a posted record of a daily dataset; alias selects the
+// behavior of the /v2/sh routes, see addAlias
+func addDaily(c *gin.Context, region *Region, ds *Dataset, alias bool) {
+	var req DailyHouse
+	// Bind JSON body to struct
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondValidationError(c, bindingFieldErrors(err))
+		log.Logger.Error().Err(err).Msg("Failed to bind JSON")
+		return
+	}
+	day, errs := ds.recordDay(req.Day)
+	dailyResp := DailyHouseResp{Day: day, DailyData: ds.mapData(req.DailyData), Districts: mapDistricts(req.Districts, ds.mapData)}
+	errs = append(errs, checkDailyData("daily_data", dailyResp.DailyData)...)
+	if errs = append(errs, checkDistricts(dailyResp.DailyData, dailyResp.Districts)...); len(errs) > 0 {
+		respondValidationError(c, errs)
+		return
+	}
+	wctx := requestContext(c)
+	m := GetInMemDataAccessor(regionStore(region.Code))
+
+	// Store in Redis
+	if err := StoreHouseData(wctx, day, dailyResp, region.Code); errors.Is(err, ErrQuarantined) {
+		respondQuarantined(c, err)
+		return
+	} else if err != nil {
+		log.Logger.Error().Err(err).Str("day", day).Str("region", region.Code).Msg("Failed to store house data in Redis")
+		queueHouseWrite(wctx, day, dailyResp, region.Code)
+		// Serve it from memory until Redis is back
+		m.Store(day, dailyResp)
+	} else if alias {
+		m.Store(day, dailyResp)
+	}
+
+	publishRecord(region.Code, ds.Name, day, eventSourceAdd, dailyResp)
+	log.Logger.Debug().Str("day", day).Str("region", region.Code).Str("dataset", ds.Name).Msg("House data added successfully")
+	if alias {
+		c.JSON(http.StatusOK, req)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"day": req.Day, "daily_data": req.DailyData})
+}
+
+// addMonth stores a posted month of a monthly dataset
+func addMonth(c *gin.Context, region *Region, ds *Dataset) {
+	if ds.Derived {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("months of %s are derived from the daily records", region.Code)})
+		return
+	}
+	var req MonthHouseResp
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondValidationError(c, bindingFieldErrors(err))
+		log.Logger.Error().Err(err).Msg("Failed to bind JSON")
+		return
+	}
+	d := req.MonthData
+	errs := checkDate("month", req.Month, monthLayout)
+	if errs = append(errs, checkHouseTotals("month_data", d.HouseCount, d.TotalCount, d.HouseArea, d.TotalArea)...); len(errs) > 0 {
+		respondValidationError(c, errs)
+		return
+	}
+	wctx := requestContext(c)
+	monthResp := MonthHouseResp{Month: req.Month, MonthData: req.MonthData}
+
+	if err := StoreMonthHouseData(wctx, req.Month, monthResp, region.Code); err != nil {
+		log.Logger.Error().Err(err).Str("month", req.Month).Str("region", region.Code).Msg("Failed to store month house data in Redis")
+		queueMonthHouseWrite(wctx, req.Month, monthResp, region.Code)
+		GetInMemDataAccessor(regionStore(region.Code)).Store(req.Month, monthResp)
+	}
+
+	publishRecord(region.Code, ds.Name, req.Month, eventSourceAdd, monthResp)
+	log.Logger.Debug().Str("month", req.Month).Str("region", region.Code).Msg("Month house data added successfully")
+	c.JSON(http.StatusOK, monthResp)
+}
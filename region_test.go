@@ -0,0 +1,124 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/LIUHUANUCAS/house/config"
+	"github.com/gin-gonic/gin"
+)
+
+func TestRecentPeriods(t *testing.T) {
+	now := time.Date(2025, 3, 1, 8, 30, 0, 0, time.UTC)
+	bj, _ := lookupRegion(beijingKey)
+	sh, _ := lookupRegion(shanghaiKey)
+	cases := []struct {
+		region  *Region
+		dataset string
+		want    []string
+	}{
+		{bj, config.DatasetOld, []string{"2025-02-28", "2025-02-27"}},
+		{bj, config.DatasetNew, []string{"2025-02-28-00", "2025-02-27-00"}},
+		{bj, config.DatasetMonthly, []string{"2025-03", "2025-02", "2025-01"}},
+		{sh, config.DatasetNew, []string{"2025-03-01-08", "2025-03-01-07", "2025-03-01-06"}},
+	}
+	for _, tc := range cases {
+		ds, _ := tc.region.Dataset(tc.dataset)
+		if got := ds.recentPeriods(now); !slices.Equal(got, tc.want) {
+			t.Errorf("%s %s: got %v, want %v", tc.region.Code, tc.dataset, got, tc.want)
+		}
+	}
+}
+
+func TestDatasetRecordDay(t *testing.T) {
+	bj, _ := lookupRegion(beijingKey)
+	ds, _ := bj.Dataset(config.DatasetNew)
+	for _, day := range []string{"2025-04-10", "2025-04-10-00"} {
+		if key, errs := ds.recordDay(day); len(errs) > 0 || key != "2025-04-10-00" {
+			t.Errorf("%s: got %q %v", day, key, errs)
+		}
+	}
+	// Hour-form days are kept as posted
+	if key, errs := ds.recordDay("2025-04-10-08"); len(errs) > 0 || key != "2025-04-10-08" {
+		t.Errorf("2025-04-10-08: got %q %v", key, errs)
+	}
+	if _, errs := ds.recordDay("2025-04-10-24"); len(errs) == 0 {
+		t.Error("expected an error for an invalid hour")
+	}
+}
+
+func TestDatasetMapData(t *testing.T) {
+	sh, _ := lookupRegion(shanghaiKey)
+	ds, _ := sh.Dataset(config.DatasetOld)
+	got := ds.mapData(DailyData{TotalCount: 1, HouseCount: 5, HouseArea: 400, HousePrice: 900})
+	want := DailyData{TotalCount: 5, TotalArea: 400, HouseCount: 5, HouseArea: 400, HousePrice: 900}
+	if got != want {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+	// The house price is not copied into the total price
+	if got := ds.mapData(DailyData{HousePrice: 900, TotalPrice: 1200}); got.TotalPrice != 1200 {
+		t.Errorf("want the posted total price, got %+v", got)
+	}
+
+	regions := config.DefaultRegions()
+	regions[0].Datasets[0].Fields = map[string]string{"total_count": "units"}
+	if _, err := newRegistry(regions); err == nil {
+		t.Error("expected an error for a mapping from an unknown field")
+	}
+}
+
+func TestAliasRoutes(t *testing.T) {
+	db, err := OpenBoltStorage(filepath.Join(t.TempDir(), "house.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	saved := storage
+	storage = db
+	defer func() { storage = saved }()
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.POST("/v1/add_beijing_new_house", addRecord(beijingKey, config.DatasetNew))
+	router.POST("/v2/sh/add_new_daily_house", addAlias(shanghaiKey, config.DatasetNew))
+	router.POST("/v2/sh/add_old_daily_house", addAlias(shanghaiKey, config.DatasetOld))
+
+	cases := []struct {
+		path, body, region, key, want string
+	}{
+		{
+			"/v1/add_beijing_new_house",
+			`{"day":"2025-06-09-08","daily_data":{"total_count":10,"total_area":900,"house_count":8,"house_area":700}}`,
+			beijingKey, "2025-06-09-08",
+			`{"daily_data":{"total_count":10,"total_area":900,"house_count":8,"house_area":700,"house_price":0,"total_price":0},"day":"2025-06-09-08"}`,
+		},
+		{
+			"/v2/sh/add_new_daily_house",
+			`{"day":"2025-06-09-08","daily_data":{"house_count":8,"house_area":700}}`,
+			shanghaiKey, "2025-06-09-08",
+			`{"month_data":{"total_count":0,"total_area":0,"house_count":0,"house_area":0},"month":"","day":"2025-06-09-08","daily_data":{"total_count":0,"total_area":0,"house_count":8,"house_area":700,"house_price":0,"total_price":0}}`,
+		},
+		{
+			"/v2/sh/add_old_daily_house",
+			`{"day":"2025-06-09","daily_data":{"house_count":8,"house_area":700,"house_price":50000}}`,
+			shanghaiKey, "2025-06-09",
+			`{"month_data":{"total_count":0,"total_area":0,"house_count":0,"house_area":0},"month":"","day":"2025-06-09","daily_data":{"total_count":0,"total_area":0,"house_count":8,"house_area":700,"house_price":50000,"total_price":0}}`,
+		},
+	}
+	for _, tc := range cases {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, tc.path, strings.NewReader(tc.body)))
+		if w.Code != http.StatusOK || w.Body.String() != tc.want {
+			t.Errorf("%s: got %d %s", tc.path, w.Code, w.Body.String())
+			continue
+		}
+		if _, found, err := GetHouseData(ctx, tc.key, tc.region); err != nil || !found {
+			t.Errorf("%s: %s not stored (%v)", tc.path, tc.key, err)
+		}
+	}
+}
@@ -0,0 +1,254 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	defaultHistogramBuckets = 10
+	maxHistogramBuckets     = 100
+)
+
+// Distribution describes the values of a metric over a range. Std is the
+// sample standard deviation, null with fewer than two values.
+type Distribution struct {
+	Count  int      `json:"count"`
+	Mean   float64  `json:"mean"`
+	Median float64  `json:"median"`
+	Std    *float64 `json:"std"`
+	Min    float64  `json:"min"`
+	Max    float64  `json:"max"`
+	P10    float64  `json:"p10"`
+	P25    float64  `json:"p25"`
+	P75    float64  `json:"p75"`
+	P90    float64  `json:"p90"`
+}
+
+// HistogramBucket counts the values in [From, To); the last bucket includes To
+type HistogramBucket struct {
+	From  float64 `json:"from"`
+	To    float64 `json:"to"`
+	Count int     `json:"count"`
+}
+
+// Histogram counts values per bucket. With explicit edges, values outside
+// them are counted in Below and Above.
+type Histogram struct {
+	Buckets []HistogramBucket `json:"buckets"`
+	Below   int               `json:"below"`
+	Above   int               `json:"above"`
+}
+
+// percentile interpolates linearly between the closest ranks of sorted
+func percentile(sorted []float64, p float64) float64 {
+	pos := p / 100 * float64(len(sorted)-1)
+	lower := int(math.Floor(pos))
+	if lower >= len(sorted)-1 {
+		return sorted[len(sorted)-1]
+	}
+	return sorted[lower] + (pos-float64(lower))*(sorted[lower+1]-sorted[lower])
+}
+
+// percentileRank is the percentage of values below x, counting values equal
+// to x as half below
+func percentileRank(values []float64, x float64) float64 {
+	var below, equal int
+	for _, v := range values {
+		if v < x {
+			below++
+		} else if v == x {
+			equal++
+		}
+	}
+	return round2((float64(below) + float64(equal)/2) / float64(len(values)) * 100)
+}
+
+// describe computes the distribution of values, which must not be empty
+func describe(values []float64) Distribution {
+	sorted := slices.Clone(values)
+	slices.Sort(sorted)
+
+	var sum float64
+	for _, v := range sorted {
+		sum += v
+	}
+	mean := sum / float64(len(sorted))
+	d := Distribution{
+		Count:  len(sorted),
+		Mean:   round2(mean),
+		Median: round2(percentile(sorted, 50)),
+		Min:    sorted[0],
+		Max:    sorted[len(sorted)-1],
+		P10:    round2(percentile(sorted, 10)),
+		P25:    round2(percentile(sorted, 25)),
+		P75:    round2(percentile(sorted, 75)),
+		P90:    round2(percentile(sorted, 90)),
+	}
+	if len(sorted) > 1 {
+		var ss float64
+		for _, v := range sorted {
+			ss += (v - mean) * (v - mean)
+		}
+		std := round2(math.Sqrt(ss / float64(len(sorted)-1)))
+		d.Std = &std
+	}
+	return d
+}
+
+// histogram counts values between ascending edges
+func histogram(values, edges []float64) Histogram {
+	h := Histogram{Buckets: make([]HistogramBucket, len(edges)-1)}
+	for i := range h.Buckets {
+		h.Buckets[i] = HistogramBucket{From: round2(edges[i]), To: round2(edges[i+1])}
+	}
+	last := edges[len(edges)-1]
+	for _, v := range values {
+		switch {
+		case v < edges[0]:
+			h.Below++
+		case v > last:
+			h.Above++
+		case v == last:
+			h.Buckets[len(h.Buckets)-1].Count++
+		default:
+			// The first edge above v closes its bucket
+			i := sort.SearchFloat64s(edges, v)
+			if i < len(edges) && edges[i] == v {
+				i++
+			}
+			h.Buckets[i-1].Count++
+		}
+	}
+	return h
+}
+
+// equalWidthEdges splits [lo, hi] into n buckets of the same width
+func equalWidthEdges(lo, hi float64, n int) []float64 {
+	if lo == hi {
+		return []float64{lo, hi + 1}
+	}
+	edges := make([]float64, n+1)
+	width := (hi - lo) / float64(n)
+	for i := range edges {
+		edges[i] = lo + float64(i)*width
+	}
+	edges[n] = hi
+	return edges
+}
+
+// parseEdges parses comma-separated, strictly ascending bucket edges
+func parseEdges(param string) ([]float64, error) {
+	parts := strings.Split(param, ",")
+	if len(parts) < 2 || len(parts) > maxHistogramBuckets+1 {
+		return nil, fmt.Errorf("must list between 2 and %d edges", maxHistogramBuckets+1)
+	}
+	edges := make([]float64, len(parts))
+	for i, p := range parts {
+		v, err := strconv.ParseFloat(strings.TrimSpace(p), 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid edge %q", p)
+		}
+		if i > 0 && v <= edges[i-1] {
+			return nil, fmt.Errorf("must be strictly ascending")
+		}
+		edges[i] = v
+	}
+	return edges, nil
+}
+
+// getStats describes metric (default total_count) over the daily records
+// (dataset=hourly for the hourly ones) between from (default 90 days ago) and
+// to (default today): summary statistics, a histogram with equal-width
+// buckets (buckets, default 10) or explicit edges, and the percentile rank of
+// the latest value
+func getStats(c *gin.Context) {
+	region := requestRegion(c)
+	metric := c.DefaultQuery("metric", "total_count")
+	dataset := c.DefaultQuery("dataset", "daily")
+	buckets, bucketsErr := strconv.Atoi(c.DefaultQuery("buckets", strconv.Itoa(defaultHistogramBuckets)))
+	toParam := c.DefaultQuery("to", getTodayDay())
+	to, toErr := time.Parse(dayLayout, toParam)
+	fromParam := c.DefaultQuery("from", to.AddDate(0, 0, -89).Format(dayLayout))
+
+	var errs []FieldError
+	if !slices.Contains(houseMetrics, metric) {
+		errs = append(errs, FieldError{Field: "metric", Rule: "oneof", Message: "must be one of " + strings.Join(houseMetrics, ", ")})
+	}
+	if dataset != "daily" && dataset != "hourly" {
+		errs = append(errs, FieldError{Field: "dataset", Rule: "oneof", Message: "must be daily or hourly"})
+	}
+	if bucketsErr != nil || buckets < 1 || buckets > maxHistogramBuckets {
+		errs = append(errs, FieldError{Field: "buckets", Rule: "range", Message: fmt.Sprintf("must be between 1 and %d", maxHistogramBuckets)})
+	}
+	var edges []float64
+	if param, ok := c.GetQuery("edges"); ok {
+		var err error
+		if edges, err = parseEdges(param); err != nil {
+			errs = append(errs, FieldError{Field: "edges", Rule: "edges", Message: err.Error()})
+		}
+	}
+	errs = append(errs, checkDate("from", fromParam, dayLayout)...)
+	if toErr != nil {
+		errs = append(errs, checkDate("to", toParam, dayLayout)...)
+	}
+	if len(errs) > 0 {
+		respondValidationError(c, errs)
+		return
+	}
+	from, _ := time.Parse(dayLayout, fromParam)
+	if err := validateRange(from, to); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	records, err := GetHouseDataForRange(ctx, from, to.Add(24*time.Hour-time.Second), region)
+	if err != nil {
+		log.Logger.Error().Err(err).Str("from", fromParam).Str("to", toParam).Str("region", region).Msg("Failed to get house data for stats")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get house data"})
+		return
+	}
+	var values []float64
+	var latest DailyHouseResp
+	for _, r := range records {
+		if isDayRecord(r.Day) != (dataset == "daily") {
+			continue
+		}
+		v, _ := r.DailyData.Metric(metric)
+		values = append(values, v)
+		latest = r // records are in day order
+	}
+	if len(values) == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"msg": "no data found for the specified range"})
+		return
+	}
+
+	dist := describe(values)
+	if edges == nil {
+		edges = equalWidthEdges(dist.Min, dist.Max, buckets)
+	}
+	latestValue, _ := latest.DailyData.Metric(metric)
+	c.JSON(http.StatusOK, gin.H{
+		"region":    region,
+		"metric":    metric,
+		"dataset":   dataset,
+		"from":      fromParam,
+		"to":        toParam,
+		"stats":     dist,
+		"histogram": histogram(values, edges),
+		"latest": gin.H{
+			"day":             latest.Day,
+			"value":           latestValue,
+			"percentile_rank": percentileRank(values, latestValue),
+		},
+	})
+}
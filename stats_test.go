@@ -0,0 +1,44 @@
+package main
+
+import "testing"
+
+func TestDescribe(t *testing.T) {
+	d := describe([]float64{4, 1, 3, 2, 5})
+	if d.Count != 5 || d.Mean != 3 || d.Median != 3 || d.Min != 1 || d.Max != 5 {
+		t.Errorf("unexpected distribution %+v", d)
+	}
+	if d.P10 != 1.4 || d.P25 != 2 || d.P75 != 4 || d.P90 != 4.6 {
+		t.Errorf("unexpected percentiles %+v", d)
+	}
+	if d.Std == nil || *d.Std != 1.58 {
+		t.Errorf("std = %v, want 1.58", d.Std)
+	}
+	if one := describe([]float64{7}); one.Std != nil || one.P90 != 7 {
+		t.Errorf("unexpected single-value distribution %+v", one)
+	}
+}
+
+func TestHistogram(t *testing.T) {
+	values := []float64{0, 5, 10, 15, 20, 25}
+	h := histogram(values, []float64{5, 10, 20})
+	if h.Below != 1 || h.Above != 1 || h.Buckets[0].Count != 1 || h.Buckets[1].Count != 3 {
+		t.Errorf("unexpected histogram %+v", h)
+	}
+
+	h = histogram(values, equalWidthEdges(0, 25, 5))
+	for i, b := range h.Buckets {
+		want := 1
+		if i == 4 {
+			want = 2 // the last bucket includes its upper edge
+		}
+		if b.Count != want {
+			t.Errorf("bucket %d: count %d, want %d", i, b.Count, want)
+		}
+	}
+}
+
+func TestPercentileRank(t *testing.T) {
+	if r := percentileRank([]float64{1, 2, 3, 4}, 3); r != 62.5 {
+		t.Errorf("rank = %v, want 62.5", r)
+	}
+}
@@ -0,0 +1,286 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/LIUHUANUCAS/house/config"
+	"github.com/go-redis/redis/v8"
+	"github.com/rs/zerolog/log"
+)
+
+// Storage backends selectable through config.StorageConfig
+const (
+	StorageBackendRedis = "redis"
+	StorageBackendBolt  = "bolt"
+)
+
+// Storage is the persistence layer behind house and fortune data. Every backend
+// uses the Redis key layout (house:daily:{region}:{day}, house:days:{region}, ...)
+// so data can be moved between them without translation.
+type Storage interface {
+	// Get returns the value stored at key and whether the key exists
+	Get(ctx context.Context, key string) (string, bool, error)
+	// MGet returns the values stored at keys, leaving out the keys that do
+	// not exist, in one round trip
+	MGet(ctx context.Context, keys ...string) (map[string]string, error)
+	// Set stores value at key permanently
+	Set(ctx context.Context, key string, value []byte) error
+	// Del removes the values stored with Set at keys
+	Del(ctx context.Context, keys ...string) error
+	// ZAdd adds member to the sorted set at key, updating its score if present
+	ZAdd(ctx context.Context, key string, score float64, member string) error
+	// ZRem removes member from the sorted set at key
+	ZRem(ctx context.Context, key string, member string) error
+	// ZRangeByScore returns the members of the sorted set at key with
+	// min <= score <= max, ordered by score
+	ZRangeByScore(ctx context.Context, key string, min, max float64) ([]string, error)
+	// RPush appends value to the list at key and returns the length of the
+	// list, or 0 when the push is batched
+	RPush(ctx context.Context, key string, value []byte) (int64, error)
+	// LRange returns the list elements at key between start and stop
+	// (inclusive); negative indexes count from the end, as in Redis
+	LRange(ctx context.Context, key string, start, stop int64) ([]string, error)
+	// LTrim keeps the list elements at key between start and stop
+	// (inclusive), indexed as in LRange
+	LTrim(ctx context.Context, key string, start, stop int64) error
+	// Batch calls fn with a context whose writes (see storageFor) are sent to
+	// the backend together when fn returns; reads are not batched
+	Batch(ctx context.Context, fn func(ctx context.Context) error) error
+	// Close releases the resources held by the backend
+	Close() error
+}
+
+// Global storage instance
+var storage Storage
+
+type batchCtxKey struct{}
+
+// storageFor returns the storage the data functions should use with ctx: the
+// batch started by Storage.Batch if any, else the global storage
+func storageFor(ctx context.Context) Storage {
+	if s, ok := ctx.Value(batchCtxKey{}).(Storage); ok {
+		return s
+	}
+	return storage
+}
+
+// InitStorage initializes the storage backend selected in the configuration
+func InitStorage(ctx context.Context, cfg *config.Config) Storage {
+	switch cfg.StorageConfig.Backend {
+	case StorageBackendBolt:
+		db, err := OpenBoltStorage(cfg.StorageConfig.Path)
+		if err != nil {
+			log.Logger.Error().Err(err).Str("path", cfg.StorageConfig.Path).Msg("Failed to open bolt storage")
+			panic(fmt.Sprintf("Failed to open bolt storage: %v", err))
+		}
+		log.Logger.Info().Str("path", cfg.StorageConfig.Path).Msg("Using bolt storage")
+		return db
+	default:
+		return &RedisStorage{db: InitRedis(ctx, &cfg.RedisConfig)}
+	}
+}
+
+// RedisStorage implements Storage on top of RedisDB
+type RedisStorage struct {
+	db RedisDB
+}
+
+// Get retrieves a value from Redis by key
+func (s *RedisStorage) Get(ctx context.Context, key string) (string, bool, error) {
+	if isDegraded() {
+		return "", false, ErrStorageUnavailable
+	}
+	value, err := s.db.Get(ctx, key).Result()
+	if err == redis.Nil {
+		return "", false, nil
+	} else if err != nil {
+		return "", false, err
+	}
+	return value, true, nil
+}
+
+// MGet retrieves the values of several keys from Redis
+func (s *RedisStorage) MGet(ctx context.Context, keys ...string) (map[string]string, error) {
+	if isDegraded() {
+		return nil, ErrStorageUnavailable
+	}
+	values := make(map[string]string, len(keys))
+	if len(keys) == 0 {
+		return values, nil
+	}
+	result, err := s.db.MGet(ctx, keys...).Result()
+	if err != nil {
+		return nil, err
+	}
+	for i, v := range result {
+		if v, ok := v.(string); ok {
+			values[keys[i]] = v
+		}
+	}
+	return values, nil
+}
+
+// Set stores a value in Redis with no expiration
+func (s *RedisStorage) Set(ctx context.Context, key string, value []byte) error {
+	if isDegraded() {
+		return ErrStorageUnavailable
+	}
+	return s.db.Set(ctx, key, value, NoExpiration).Err()
+}
+
+// Del removes keys from Redis
+func (s *RedisStorage) Del(ctx context.Context, keys ...string) error {
+	if isDegraded() {
+		return ErrStorageUnavailable
+	}
+	return s.db.Del(ctx, keys...).Err()
+}
+
+// ZAdd adds a member to a Redis sorted set
+func (s *RedisStorage) ZAdd(ctx context.Context, key string, score float64, member string) error {
+	if isDegraded() {
+		return ErrStorageUnavailable
+	}
+	return s.db.ZAdd(ctx, key, &redis.Z{
+		Score:  score,
+		Member: member,
+	}).Err()
+}
+
+// ZRem removes a member from a Redis sorted set
+func (s *RedisStorage) ZRem(ctx context.Context, key string, member string) error {
+	if isDegraded() {
+		return ErrStorageUnavailable
+	}
+	return s.db.ZRem(ctx, key, member).Err()
+}
+
+// ZRangeByScore retrieves members of a Redis sorted set by score
+func (s *RedisStorage) ZRangeByScore(ctx context.Context, key string, min, max float64) ([]string, error) {
+	if isDegraded() {
+		return nil, ErrStorageUnavailable
+	}
+	return s.db.ZRangeByScore(ctx, key, &redis.ZRangeBy{
+		Min: fmt.Sprintf("%f", min),
+		Max: fmt.Sprintf("%f", max),
+	}).Result()
+}
+
+// RPush appends a value to a Redis list
+func (s *RedisStorage) RPush(ctx context.Context, key string, value []byte) (int64, error) {
+	if isDegraded() {
+		return 0, ErrStorageUnavailable
+	}
+	return s.db.RPush(ctx, key, value).Result()
+}
+
+// LRange retrieves a range of Redis list elements
+func (s *RedisStorage) LRange(ctx context.Context, key string, start, stop int64) ([]string, error) {
+	if isDegraded() {
+		return nil, ErrStorageUnavailable
+	}
+	return s.db.LRange(ctx, key, start, stop).Result()
+}
+
+// LTrim trims a Redis list
+func (s *RedisStorage) LTrim(ctx context.Context, key string, start, stop int64) error {
+	if isDegraded() {
+		return ErrStorageUnavailable
+	}
+	return s.db.LTrim(ctx, key, start, stop).Err()
+}
+
+// Batch pipelines the writes made by fn into a single round trip
+func (s *RedisStorage) Batch(ctx context.Context, fn func(ctx context.Context) error) error {
+	if isDegraded() {
+		return ErrStorageUnavailable
+	}
+	db, ok := s.db.(*ProductionRedisDB)
+	if !ok {
+		// Test doubles have no pipeline; write directly
+		return fn(context.WithValue(ctx, batchCtxKey{}, Storage(s)))
+	}
+
+	pipe := db.client.Pipeline()
+	if err := fn(context.WithValue(ctx, batchCtxKey{}, Storage(&redisPipeStorage{RedisStorage: s, pipe: pipe}))); err != nil {
+		pipe.Discard()
+		return err
+	}
+	cmds, err := pipe.Exec(ctx)
+	for _, cmd := range cmds {
+		recordRedisError("pipeline_"+cmd.Name(), cmd.Err())
+	}
+	return err
+}
+
+// Close closes the underlying Redis client
+func (s *RedisStorage) Close() error {
+	if redisClient == nil {
+		return nil
+	}
+	return redisClient.Close()
+}
+
+// listBounds converts Redis LRANGE start/stop indexes into slice bounds for a
+// list of length n
+func listBounds(n, start, stop int64) (int64, int64) {
+	if start < 0 {
+		start += n
+	}
+	if stop < 0 {
+		stop += n
+	}
+	start = max(start, 0)
+	stop = min(stop, n-1)
+	if start > stop {
+		return 0, 0
+	}
+	return start, stop + 1
+}
+
+// redisPipeStorage queues writes on a pipeline and reads through RedisStorage
+type redisPipeStorage struct {
+	*RedisStorage
+	pipe redis.Pipeliner
+}
+
+// Set queues a SET on the pipeline
+func (s *redisPipeStorage) Set(ctx context.Context, key string, value []byte) error {
+	s.pipe.Set(ctx, key, value, NoExpiration)
+	return nil
+}
+
+// Del queues a DEL on the pipeline
+func (s *redisPipeStorage) Del(ctx context.Context, keys ...string) error {
+	s.pipe.Del(ctx, keys...)
+	return nil
+}
+
+// ZAdd queues a ZADD on the pipeline
+func (s *redisPipeStorage) ZAdd(ctx context.Context, key string, score float64, member string) error {
+	s.pipe.ZAdd(ctx, key, &redis.Z{
+		Score:  score,
+		Member: member,
+	})
+	return nil
+}
+
+// ZRem queues a ZREM on the pipeline
+func (s *redisPipeStorage) ZRem(ctx context.Context, key string, member string) error {
+	s.pipe.ZRem(ctx, key, member)
+	return nil
+}
+
+// RPush queues an RPUSH on the pipeline; the length is not known until the
+// pipeline is sent
+func (s *redisPipeStorage) RPush(ctx context.Context, key string, value []byte) (int64, error) {
+	s.pipe.RPush(ctx, key, value)
+	return 0, nil
+}
+
+// LTrim queues an LTRIM on the pipeline
+func (s *redisPipeStorage) LTrim(ctx context.Context, key string, start, stop int64) error {
+	s.pipe.LTrim(ctx, key, start, stop)
+	return nil
+}
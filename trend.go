@@ -0,0 +1,263 @@
+package main
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+)
+
+// How the trend series handles days without data
+const (
+	MissingSkip        = "skip"        // Leave the day out; averages use the days present in the window
+	MissingInterpolate = "interpolate" // Fill the day linearly between its neighbours
+)
+
+// Moving average windows, in days
+const (
+	shortWindow = 7
+	longWindow  = 30
+)
+
+// trendFlatPct is the gap between the short and long averages, in percent,
+// below which the trend is reported as flat
+const trendFlatPct = 1.0
+
+// TrendPoint is one day of a trend series. Averages are null until their
+// window has data.
+type TrendPoint struct {
+	Day          string   `json:"day"`
+	Value        float64  `json:"value"`
+	Interpolated bool     `json:"interpolated,omitempty"`
+	DayType      string   `json:"day_type"`
+	Holiday      string   `json:"holiday,omitempty"`
+	SMA7         *float64 `json:"sma7"`
+	SMA30        *float64 `json:"sma30"`
+	EMA7         *float64 `json:"ema7"`
+	EMA30        *float64 `json:"ema30"`
+}
+
+// Trend summarizes the direction of the series at its last point
+type Trend struct {
+	Direction string   `json:"direction"`  // up, down or flat
+	ChangePct *float64 `json:"change_pct"` // sma7 relative to sma30
+}
+
+// trendSeries builds the series of values (by day) from from to to. Values
+// before from only warm up the averages.
+func trendSeries(values map[string]float64, from, to time.Time, missing string) []TrendPoint {
+	var known []time.Time
+	for day := range values {
+		if t, err := time.Parse(dayLayout, day); err == nil && !t.After(to) {
+			known = append(known, t)
+		}
+	}
+	if len(known) == 0 {
+		return nil
+	}
+	slices.SortFunc(known, func(a, b time.Time) int { return a.Compare(b) })
+
+	// One slot per calendar day from the first known day, nil when missing
+	start := known[0]
+	days := int(to.Sub(start).Hours()/24) + 1
+	slots := make([]*TrendPoint, days)
+	for _, t := range known {
+		day := t.Format(dayLayout)
+		slots[int(t.Sub(start).Hours()/24)] = &TrendPoint{Day: day, Value: values[day]}
+	}
+	if missing == MissingInterpolate {
+		interpolateGaps(slots, start)
+	}
+
+	var points []TrendPoint
+	var ema7, ema30 *float64
+	for i, p := range slots {
+		if p == nil {
+			continue
+		}
+		p.SMA7 = windowMean(slots, i, shortWindow, missing)
+		p.SMA30 = windowMean(slots, i, longWindow, missing)
+		ema7 = nextEMA(ema7, p.Value, shortWindow)
+		ema30 = nextEMA(ema30, p.Value, longWindow)
+		p.EMA7, p.EMA30 = roundPtr(ema7), roundPtr(ema30)
+		if t := start.AddDate(0, 0, i); !t.Before(from) {
+			info := holidayCalendar.Info(t)
+			p.DayType, p.Holiday = info.Type, info.Holiday
+			points = append(points, *p)
+		}
+	}
+	return points
+}
+
+// interpolateGaps fills the empty slots between two known days linearly
+func interpolateGaps(slots []*TrendPoint, start time.Time) {
+	prev := -1
+	for i, p := range slots {
+		if p == nil {
+			continue
+		}
+		if prev >= 0 && i-prev > 1 {
+			step := (p.Value - slots[prev].Value) / float64(i-prev)
+			for j := prev + 1; j < i; j++ {
+				slots[j] = &TrendPoint{
+					Day:          start.AddDate(0, 0, j).Format(dayLayout),
+					Value:        round2(slots[prev].Value + step*float64(j-prev)),
+					Interpolated: true,
+				}
+			}
+		}
+		prev = i
+	}
+}
+
+// windowMean returns the mean of the window days ending at slot i. With
+// interpolation every day of the window must be present; when skipping,
+// missing days are left out.
+func windowMean(slots []*TrendPoint, i, window int, missing string) *float64 {
+	if missing == MissingInterpolate && i+1 < window {
+		return nil
+	}
+	var sum, n float64
+	for j := max(0, i-window+1); j <= i; j++ {
+		if slots[j] == nil {
+			if missing == MissingInterpolate {
+				return nil
+			}
+			continue
+		}
+		sum += slots[j].Value
+		n++
+	}
+	if n == 0 {
+		return nil
+	}
+	mean := round2(sum / n)
+	return &mean
+}
+
+// nextEMA folds value into the exponential moving average of the window,
+// seeded with the first value
+func nextEMA(prev *float64, value float64, window int) *float64 {
+	if prev == nil {
+		return &value
+	}
+	alpha := 2 / float64(window+1)
+	next := alpha*value + (1-alpha)**prev
+	return &next
+}
+
+// trendOf compares the short and long averages of the last point
+func trendOf(points []TrendPoint) Trend {
+	trend := Trend{Direction: "flat"}
+	if len(points) == 0 {
+		return trend
+	}
+	last := points[len(points)-1]
+	if last.SMA7 == nil || last.SMA30 == nil || *last.SMA30 == 0 {
+		return trend
+	}
+	pct := round2((*last.SMA7 - *last.SMA30) / *last.SMA30 * 100)
+	trend.ChangePct = &pct
+	switch {
+	case pct > trendFlatPct:
+		trend.Direction = "up"
+	case pct < -trendFlatPct:
+		trend.Direction = "down"
+	}
+	return trend
+}
+
+func round2(v float64) float64 {
+	return math.Round(v*100) / 100
+}
+
+func roundPtr(v *float64) *float64 {
+	if v == nil {
+		return nil
+	}
+	r := round2(*v)
+	return &r
+}
+
+// GetMetricValues returns metric of the daily records between from and to by day
+func GetMetricValues(ctx context.Context, from, to time.Time, region, metric string) (map[string]float64, error) {
+	days, err := GetHouseDaysInRange(ctx, from, to, region)
+	if err != nil {
+		return nil, err
+	}
+	values := make(map[string]float64, len(days))
+	for _, day := range days {
+		if !isDayRecord(day) {
+			continue
+		}
+		houseData, found, err := GetHouseData(ctx, day, region)
+		if err != nil {
+			return nil, err
+		}
+		if found {
+			values[day], _ = houseData.DailyData.Metric(metric)
+		}
+	}
+	return values, nil
+}
+
+// getTrend returns metric (default total_count) between from (default 90
+// days ago) and to (default today) with its moving averages
+func getTrend(c *gin.Context) {
+	region := requestRegion(c)
+	metric := c.DefaultQuery("metric", "total_count")
+	missing := c.DefaultQuery("missing", MissingSkip)
+	toParam := c.DefaultQuery("to", getTodayDay())
+	to, toErr := time.Parse(dayLayout, toParam)
+	fromParam := c.DefaultQuery("from", to.AddDate(0, 0, -89).Format(dayLayout))
+
+	var errs []FieldError
+	if !slices.Contains(houseMetrics, metric) {
+		errs = append(errs, FieldError{Field: "metric", Rule: "oneof", Message: "must be one of " + strings.Join(houseMetrics, ", ")})
+	}
+	if missing != MissingSkip && missing != MissingInterpolate {
+		errs = append(errs, FieldError{Field: "missing", Rule: "oneof", Message: "must be skip or interpolate"})
+	}
+	errs = append(errs, checkDate("from", fromParam, dayLayout)...)
+	if toErr != nil {
+		errs = append(errs, checkDate("to", toParam, dayLayout)...)
+	}
+	if len(errs) > 0 {
+		respondValidationError(c, errs)
+		return
+	}
+	from, _ := time.Parse(dayLayout, fromParam)
+	if err := validateRange(from, to); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Read a long window before from so the averages start warmed up
+	warmup := from.AddDate(0, 0, -(longWindow - 1))
+	values, err := GetMetricValues(ctx, warmup, to.Add(24*time.Hour-time.Second), region, metric)
+	if err != nil {
+		log.Logger.Error().Err(err).Str("from", fromParam).Str("to", toParam).Str("region", region).Msg("Failed to get house data for trend")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get house data"})
+		return
+	}
+
+	points := trendSeries(values, from, to, missing)
+	if len(points) == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"msg": "no data found for the specified range"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"region":  region,
+		"metric":  metric,
+		"from":    fromParam,
+		"to":      toParam,
+		"missing": missing,
+		"points":  points,
+		"trend":   trendOf(points),
+	})
+}
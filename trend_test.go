@@ -0,0 +1,50 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTrendSeries(t *testing.T) {
+	values := map[string]float64{
+		"2025-04-01": 10,
+		"2025-04-02": 20,
+		"2025-04-05": 50,
+	}
+	from, _ := time.Parse(dayLayout, "2025-04-02")
+	to, _ := time.Parse(dayLayout, "2025-04-05")
+
+	skipped := trendSeries(values, from, to, MissingSkip)
+	if len(skipped) != 2 || skipped[0].Day != "2025-04-02" || skipped[1].Day != "2025-04-05" {
+		t.Fatalf("skip: unexpected points %+v", skipped)
+	}
+	// The 7-day window of 04-05 holds 10, 20 and 50
+	if sma := skipped[1].SMA7; sma == nil || *sma != 26.67 {
+		t.Errorf("skip: sma7 = %v, want 26.67", sma)
+	}
+	// ema7: 10 -> 12.5 -> 21.88
+	if ema := skipped[1].EMA7; ema == nil || *ema != 21.88 {
+		t.Errorf("skip: ema7 = %v, want 21.88", ema)
+	}
+
+	filled := trendSeries(values, from, to, MissingInterpolate)
+	if len(filled) != 4 {
+		t.Fatalf("interpolate: got %d points, want 4", len(filled))
+	}
+	if p := filled[1]; p.Day != "2025-04-03" || p.Value != 30 || !p.Interpolated {
+		t.Errorf("interpolate: unexpected point %+v", p)
+	}
+	if filled[3].SMA7 != nil {
+		t.Errorf("interpolate: sma7 should be null before a full window, got %v", *filled[3].SMA7)
+	}
+}
+
+func TestTrendOf(t *testing.T) {
+	sma7, sma30 := 110.0, 100.0
+	if trend := trendOf([]TrendPoint{{SMA7: &sma7, SMA30: &sma30}}); trend.Direction != "up" || *trend.ChangePct != 10 {
+		t.Errorf("unexpected trend %+v", trend)
+	}
+	if trend := trendOf([]TrendPoint{{SMA7: &sma7}}); trend.Direction != "flat" || trend.ChangePct != nil {
+		t.Errorf("expected flat trend without long average, got %+v", trend)
+	}
+}
@@ -1,6 +1,9 @@
 package main
 
 import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
 	"time"
 )
 
@@ -49,9 +52,9 @@ func getPreviousHour(hours int) string {
 }
 
 func parseDay(day string) (time.Time, error) {
-	format := "2006-01-02"
-	if len(day) == 13 { // if it includes hour}
-		format = "2006-01-02-15"
+	format := dayLayout
+	if len(day) == len(dayHourLayout) { // if it includes hour
+		format = dayHourLayout
 	}
 	return time.Parse(format, day)
 }
@@ -59,3 +62,28 @@ func parseDay(day string) (time.Time, error) {
 func getBeijingNewHouseDayKey(day string) string {
 	return day + "-00"
 }
+
+// parseRangeBound parses a from/to query value in YYYY-MM-DD or YYYY-MM-DD-HH
+// format. An end bound covers the whole day (or hour) it names.
+func parseRangeBound(value string, end bool) (time.Time, error) {
+	t, err := parseDay(value)
+	if err != nil {
+		return t, err
+	}
+	if !end {
+		return t, nil
+	}
+	if len(value) == 13 {
+		return t.Add(time.Hour - time.Second), nil
+	}
+	return t.Add(24*time.Hour - time.Second), nil
+}
+
+// randomHex returns n random bytes, hex-encoded, for identifiers
+func randomHex(n int) string {
+	buf := make([]byte, n)
+	if _, err := rand.Read(buf); err != nil {
+		panic(fmt.Sprintf("failed to read random bytes: %v", err))
+	}
+	return hex.EncodeToString(buf)
+}
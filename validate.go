@@ -0,0 +1,146 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/go-playground/validator/v10"
+)
+
+// Date formats of the day and month fields
+const (
+	dayLayout     = "2006-01-02"    // daily records
+	dayHourLayout = "2006-01-02-15" // hourly records (Beijing and Shanghai new houses)
+	monthLayout   = "2006-01"
+)
+
+// FieldError describes one invalid field of a request
+type FieldError struct {
+	Field   string `json:"field"` // JSON path, e.g. daily_data.total_count
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+func init() {
+	// Report JSON field names instead of Go field names
+	if v, ok := binding.Validator.Engine().(*validator.Validate); ok {
+		v.RegisterTagNameFunc(func(field reflect.StructField) string {
+			name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+			if name == "-" {
+				return ""
+			}
+			return name
+		})
+	}
+}
+
+// respondValidationError rejects a request with the list of invalid fields
+func respondValidationError(c *gin.Context, errs []FieldError) {
+	c.JSON(http.StatusBadRequest, gin.H{"error": "validation failed", "fields": errs})
+}
+
+// bindingFieldErrors converts an error from ShouldBindJSON into field errors
+func bindingFieldErrors(err error) []FieldError {
+	var validationErrs validator.ValidationErrors
+	var typeErr *json.UnmarshalTypeError
+	switch {
+	case errors.As(err, &validationErrs):
+		errs := make([]FieldError, 0, len(validationErrs))
+		for _, fe := range validationErrs {
+			// Drop the struct name from the namespace (DailyHouse.daily_data.total_count)
+			_, field, _ := strings.Cut(fe.Namespace(), ".")
+			errs = append(errs, FieldError{Field: field, Rule: fe.Tag(), Message: ruleMessage(fe)})
+		}
+		return errs
+	case errors.As(err, &typeErr):
+		return []FieldError{{Field: typeErr.Field, Rule: "type", Message: "must be a " + jsonTypeName(typeErr.Type)}}
+	}
+	return []FieldError{{Rule: "json", Message: err.Error()}}
+}
+
+func jsonTypeName(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.Float32, reflect.Float64, reflect.Int, reflect.Int64:
+		return "number"
+	case reflect.Slice:
+		return "array"
+	case reflect.Struct, reflect.Map:
+		return "object"
+	}
+	return t.String()
+}
+
+func ruleMessage(fe validator.FieldError) string {
+	switch fe.Tag() {
+	case "required":
+		return "is required"
+	case "gte":
+		return "must be greater than or equal to " + fe.Param()
+	}
+	return fmt.Sprintf("failed %s validation", fe.Tag())
+}
+
+// validateStruct runs the binding tags of v, for data not decoded by gin
+func validateStruct(v interface{}) []FieldError {
+	if err := binding.Validator.ValidateStruct(v); err != nil {
+		return bindingFieldErrors(err)
+	}
+	return nil
+}
+
+// checkDate validates that value is a date in layout
+func checkDate(field, value, layout string) []FieldError {
+	if t, err := time.Parse(layout, value); err != nil || t.Format(layout) != value {
+		return []FieldError{{Field: field, Rule: "date", Message: "must be a valid date in " + layoutName(layout) + " format"}}
+	}
+	return nil
+}
+
+func layoutName(layout string) string {
+	return strings.NewReplacer("2006", "YYYY", "01", "MM", "02", "DD", "15", "HH").Replace(layout)
+}
+
+// validateDailyHouse checks a posted DailyHouse whose day is in layout
+func validateDailyHouse(req DailyHouse, layout string) []FieldError {
+	errs := checkDate("day", req.Day, layout)
+	errs = append(errs, checkDailyData("daily_data", req.DailyData)...)
+	errs = append(errs, checkDistricts(req.DailyData, req.Districts)...)
+	return append(errs, checkMonth(req)...)
+}
+
+// checkDailyData validates the internal consistency of daily figures: the
+// residential part cannot exceed the total
+func checkDailyData(prefix string, d DailyData) []FieldError {
+	return checkHouseTotals(prefix, d.HouseCount, d.TotalCount, d.HouseArea, d.TotalArea)
+}
+
+// checkMonth validates the month fields of a DailyHouse request
+func checkMonth(req DailyHouse) []FieldError {
+	if req.Month == "" {
+		if req.MonthData != (MonthData{}) {
+			return []FieldError{{Field: "month", Rule: "required_with", Message: "is required when month_data is set"}}
+		}
+		return nil
+	}
+	errs := checkDate("month", req.Month, monthLayout)
+	d := req.MonthData
+	return append(errs, checkHouseTotals("month_data", d.HouseCount, d.TotalCount, d.HouseArea, d.TotalArea)...)
+}
+
+func checkHouseTotals(prefix string, houseCount, totalCount, houseArea, totalArea float64) []FieldError {
+	var errs []FieldError
+	if houseCount > totalCount {
+		errs = append(errs, FieldError{Field: prefix + ".house_count", Rule: "lte_field", Message: "must not exceed total_count"})
+	}
+	if houseArea > totalArea {
+		errs = append(errs, FieldError{Field: prefix + ".house_area", Rule: "lte_field", Message: "must not exceed total_area"})
+	}
+	return errs
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestValidateDailyHouse(t *testing.T) {
+	valid := DailyHouse{
+		Day:       "2025-04-10",
+		DailyData: DailyData{TotalCount: 744, TotalArea: 64840, HouseCount: 619, HouseArea: 58754.18},
+	}
+	if errs := validateDailyHouse(valid, dayLayout); len(errs) != 0 {
+		t.Errorf("valid request rejected: %+v", errs)
+	}
+
+	invalid := valid
+	invalid.Day = "2025-13-45"
+	invalid.DailyData.HouseCount = 800
+	invalid.MonthData = MonthData{TotalCount: 1}
+	errs := validateDailyHouse(invalid, dayLayout)
+	fields := make(map[string]string)
+	for _, e := range errs {
+		fields[e.Field] = e.Rule
+	}
+	for field, rule := range map[string]string{"day": "date", "daily_data.house_count": "lte_field", "month": "required_with"} {
+		if fields[field] != rule {
+			t.Errorf("field %s: got rule %q, want %q", field, fields[field], rule)
+		}
+	}
+
+	if errs := checkDate("day", "2025-04-10", dayHourLayout); len(errs) != 1 {
+		t.Error("day accepted where day-hour is required")
+	}
+
+	negative := DailyHouseResp{Day: "2025-04-10", DailyData: DailyData{TotalArea: -1}}
+	errs = validateStruct(&negative)
+	if len(errs) != 1 || errs[0].Field != "daily_data.total_area" || errs[0].Rule != "gte" {
+		t.Errorf("negative area: got %+v", errs)
+	}
+}
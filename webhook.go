@@ -0,0 +1,819 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/LIUHUANUCAS/house/config"
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+)
+
+// Redis key prefixes and structures for webhooks
+const (
+	WebhookKeyPrefix           = "webhook"              // webhook:{id}
+	WebhooksSetKey             = "webhooks"             // Sorted set of webhook IDs by creation time
+	WebhookDeliveriesKeyPrefix = "webhook_deliveries"   // webhook_deliveries:{id}, the latest attempts, oldest first
+	WebhookDeadLettersKey      = "webhook_dead_letters" // The latest deliveries given up on, oldest first
+)
+
+// Webhook event types
+const (
+	WebhookEventRecord    = "record"    // a house record was accepted
+	WebhookEventFortune   = "fortune"   // a fortune poem was stored
+	WebhookEventThreshold = "threshold" // a metric crossed a threshold
+)
+
+// Threshold directions
+const (
+	thresholdUp   = "up"
+	thresholdDown = "down"
+	thresholdBoth = "both"
+)
+
+const (
+	webhookQueueSize       = 1000
+	webhookCacheTTL        = 30 * time.Second // Subscriptions changed on another instance are seen after this
+	webhookSecretPrefix    = "whsec_"
+	defaultWebhookLogLimit = 100
+	maxWebhookLogLimit     = 1000
+)
+
+// Webhook is a subscription to events. Region and dataset filters apply to
+// record and threshold events; empty filters match everything.
+type Webhook struct {
+	ID         string             `json:"id"`
+	URL        string             `json:"url"`
+	Secret     string             `json:"secret,omitempty"`
+	Events     []string           `json:"events"`
+	Regions    []string           `json:"regions,omitempty"`
+	Datasets   []string           `json:"datasets,omitempty"`
+	Thresholds []WebhookThreshold `json:"thresholds,omitempty"`
+	CreatedAt  time.Time          `json:"created_at"`
+	Deleted    bool               `json:"deleted"`
+	DeletedAt  *time.Time         `json:"deleted_at,omitempty"`
+}
+
+// WebhookThreshold fires when metric crosses value between two consecutive
+// records of a dataset
+type WebhookThreshold struct {
+	Metric    string  `json:"metric"`
+	Value     float64 `json:"value"`
+	Direction string  `json:"direction"` // up, down or both
+}
+
+// ThresholdCrossing is the crossing reported by a threshold event
+type ThresholdCrossing struct {
+	Metric      string  `json:"metric"`
+	Threshold   float64 `json:"threshold"`
+	Direction   string  `json:"direction"` // up or down
+	Previous    float64 `json:"previous"`
+	PreviousDay string  `json:"previous_day"`
+	Current     float64 `json:"current"`
+}
+
+// WebhookEvent is the body posted to the receivers
+type WebhookEvent struct {
+	ID       string             `json:"id"`
+	Type     string             `json:"type"`
+	Time     time.Time          `json:"time"`
+	Region   string             `json:"region,omitempty"`
+	Dataset  string             `json:"dataset,omitempty"`
+	Day      string             `json:"day"`
+	Data     json.RawMessage    `json:"data"`
+	Crossing *ThresholdCrossing `json:"crossing,omitempty"`
+}
+
+// WebhookDelivery is one attempt to deliver an event
+type WebhookDelivery struct {
+	ID         string    `json:"id"` // Same for every attempt of a delivery
+	Webhook    string    `json:"webhook"`
+	Event      string    `json:"event"`
+	Type       string    `json:"type"`
+	Attempt    int       `json:"attempt"`
+	StatusCode int       `json:"status_code,omitempty"`
+	Error      string    `json:"error,omitempty"`
+	DurationMs int64     `json:"duration_ms"`
+	Time       time.Time `json:"time"`
+}
+
+// DeadLetter is a delivery given up on after its last attempt
+type DeadLetter struct {
+	Delivery string       `json:"delivery"`
+	Webhook  string       `json:"webhook"`
+	URL      string       `json:"url"`
+	Event    WebhookEvent `json:"event"`
+	Attempts int          `json:"attempts"`
+	Error    string       `json:"error"`
+	Time     time.Time    `json:"time"`
+}
+
+// redacted returns hook without its secret
+func (hook Webhook) redacted() Webhook {
+	hook.Secret = ""
+	return hook
+}
+
+// wants reports whether hook subscribes to events of the type, region and
+// dataset given
+func (hook Webhook) wants(eventType, region, dataset string) bool {
+	if hook.Deleted || !slices.Contains(hook.Events, eventType) {
+		return false
+	}
+	if eventType == WebhookEventFortune {
+		return true
+	}
+	return (len(hook.Regions) == 0 || slices.Contains(hook.Regions, region)) &&
+		(len(hook.Datasets) == 0 || slices.Contains(hook.Datasets, dataset))
+}
+
+// crossThreshold returns the direction in which the move from prev to cur
+// crosses th, if it does in a direction th watches
+func crossThreshold(th WebhookThreshold, prev, cur float64) (string, bool) {
+	var direction string
+	switch {
+	case prev < th.Value && cur >= th.Value:
+		direction = thresholdUp
+	case prev >= th.Value && cur < th.Value:
+		direction = thresholdDown
+	default:
+		return "", false
+	}
+	return direction, th.Direction == thresholdBoth || th.Direction == direction
+}
+
+// signWebhook signs a body sent at timestamp (Unix seconds) with HMAC-SHA256;
+// receivers recompute it over "{timestamp}.{body}"
+func signWebhook(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%d.", timestamp)
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func formatWebhookKey(id string) string {
+	return fmt.Sprintf("%s:%s", WebhookKeyPrefix, id)
+}
+
+func formatWebhookDeliveriesKey(id string) string {
+	return fmt.Sprintf("%s:%s", WebhookDeliveriesKeyPrefix, id)
+}
+
+func saveWebhook(hook Webhook) error {
+	jsonData, err := json.Marshal(hook)
+	if err != nil {
+		return err
+	}
+	return storage.Set(ctx, formatWebhookKey(hook.ID), jsonData)
+}
+
+// listWebhooks returns every stored webhook by creation time, including
+// deleted ones
+func listWebhooks() ([]Webhook, error) {
+	ids, err := storage.ZRangeByScore(ctx, WebhooksSetKey, math.Inf(-1), math.Inf(1))
+	if err != nil {
+		return nil, err
+	}
+	hooks := []Webhook{}
+	for _, id := range ids {
+		jsonData, found, err := storage.Get(ctx, formatWebhookKey(id))
+		if err != nil {
+			return nil, err
+		}
+		if !found {
+			continue
+		}
+		var hook Webhook
+		if err := json.Unmarshal([]byte(jsonData), &hook); err != nil {
+			log.Logger.Error().Err(err).Str("id", id).Msg("Failed to unmarshal webhook")
+			continue
+		}
+		hooks = append(hooks, hook)
+	}
+	return hooks, nil
+}
+
+// webhookJob is a delivery waiting for its next attempt
+type webhookJob struct {
+	hook     Webhook
+	event    WebhookEvent
+	body     []byte
+	delivery string
+	attempt  int
+}
+
+// thresholdCheck is a record waiting to be compared with the thresholds of
+// hooks
+type thresholdCheck struct {
+	event RecordEvent
+	hooks []Webhook
+}
+
+// webhookDispatcher delivers the events raised by this instance. Events
+// relayed from other instances are dispatched there.
+type webhookDispatcher struct {
+	cfg    config.WebhookConfig
+	ctx    context.Context
+	client *http.Client
+	queue  chan webhookJob
+	checks chan thresholdCheck
+
+	workers sync.WaitGroup
+
+	mu       sync.Mutex
+	hooks    []Webhook
+	loadedAt time.Time
+	retries  map[*time.Timer]webhookJob // jobs waiting for their next attempt
+}
+
+// webhooks is nil until InitWebhooks starts the workers
+var webhooks *webhookDispatcher
+
+// InitWebhooks starts the delivery workers, which stop when ctx is done
+func InitWebhooks(ctx context.Context, cfg *config.WebhookConfig) {
+	d := &webhookDispatcher{
+		cfg:    *cfg,
+		ctx:    ctx,
+		client: &http.Client{Timeout: time.Duration(cfg.Timeout) * time.Second},
+		queue:  make(chan webhookJob, webhookQueueSize),
+		checks: make(chan thresholdCheck, webhookQueueSize),
+	}
+	for range cfg.Workers {
+		d.workers.Add(2)
+		go func() {
+			defer d.workers.Done()
+			d.work()
+		}()
+		go func() {
+			defer d.workers.Done()
+			d.checkWork()
+		}()
+	}
+	webhooks = d
+}
+
+// wait blocks until the workers have stopped and dead-lettered the jobs left
+func (d *webhookDispatcher) wait() {
+	d.workers.Wait()
+}
+
+// subscriptions returns the stored webhooks, reloaded every webhookCacheTTL;
+// the last list is kept while storage is unreachable
+func (d *webhookDispatcher) subscriptions() []Webhook {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if time.Since(d.loadedAt) < webhookCacheTTL {
+		return d.hooks
+	}
+	hooks, err := listWebhooks()
+	if err != nil {
+		log.Logger.Error().Err(err).Msg("Failed to load webhooks")
+		return d.hooks
+	}
+	d.hooks, d.loadedAt = hooks, time.Now()
+	return hooks
+}
+
+// invalidate makes the next event reload the subscriptions
+func (d *webhookDispatcher) invalidate() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.loadedAt = time.Time{}
+}
+
+// matching returns the webhooks subscribed to events of the type, region and
+// dataset given
+func (d *webhookDispatcher) matching(eventType, region, dataset string) []Webhook {
+	var hooks []Webhook
+	for _, hook := range d.subscriptions() {
+		if hook.wants(eventType, region, dataset) {
+			hooks = append(hooks, hook)
+		}
+	}
+	return hooks
+}
+
+// dispatch queues the delivery of ev to hooks
+func (d *webhookDispatcher) dispatch(ev WebhookEvent, hooks []Webhook) {
+	if len(hooks) == 0 {
+		return
+	}
+	body, err := json.Marshal(ev)
+	if err != nil {
+		log.Logger.Error().Err(err).Str("event", ev.ID).Msg("Failed to marshal webhook event")
+		return
+	}
+	for _, hook := range hooks {
+		d.enqueue(webhookJob{hook: hook, event: ev, body: body, delivery: randomHex(8), attempt: 1})
+	}
+}
+
+// enqueue queues job, dead-lettering it when the queue is full or the
+// dispatcher is stopping
+func (d *webhookDispatcher) enqueue(job webhookJob) {
+	if d.ctx.Err() != nil {
+		d.deadLetter(job, "shutting down")
+		return
+	}
+	select {
+	case d.queue <- job:
+	default:
+		d.deadLetter(job, "delivery queue full")
+	}
+}
+
+// work delivers queued jobs until the dispatcher stops, then dead-letters
+// the jobs left
+func (d *webhookDispatcher) work() {
+	for {
+		select {
+		case <-d.ctx.Done():
+			d.drain()
+			return
+		case job := <-d.queue:
+			if d.ctx.Err() != nil {
+				d.deadLetter(job, "shutting down")
+				continue
+			}
+			d.attempt(job)
+		}
+	}
+}
+
+// drain dead-letters the jobs still queued or waiting for a retry
+func (d *webhookDispatcher) drain() {
+	d.mu.Lock()
+	retries := d.retries
+	d.retries = nil
+	d.mu.Unlock()
+	for timer, job := range retries {
+		// A timer that already fired enqueues its job, which dead-letters it
+		if timer.Stop() {
+			d.deadLetter(job, "shutting down")
+		}
+	}
+	for {
+		select {
+		case job := <-d.queue:
+			d.deadLetter(job, "shutting down")
+		default:
+			return
+		}
+	}
+}
+
+// retry queues job again after delay
+func (d *webhookDispatcher) retry(job webhookJob, delay time.Duration) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.retries == nil {
+		d.retries = make(map[*time.Timer]webhookJob)
+	}
+	var timer *time.Timer
+	timer = time.AfterFunc(delay, func() {
+		d.mu.Lock()
+		delete(d.retries, timer)
+		d.mu.Unlock()
+		d.enqueue(job)
+	})
+	d.retries[timer] = job
+}
+
+// attempt delivers job once, logs the attempt and schedules the next one
+// with exponential backoff, or dead-letters the job after the last attempt
+func (d *webhookDispatcher) attempt(job webhookJob) {
+	start := time.Now()
+	status, err := d.send(job)
+	attempt := WebhookDelivery{
+		ID:         job.delivery,
+		Webhook:    job.hook.ID,
+		Event:      job.event.ID,
+		Type:       job.event.Type,
+		Attempt:    job.attempt,
+		StatusCode: status,
+		DurationMs: time.Since(start).Milliseconds(),
+		Time:       start,
+	}
+	if err != nil {
+		attempt.Error = err.Error()
+	}
+	if jsonData, err := json.Marshal(attempt); err == nil {
+		if err := d.pushLog(formatWebhookDeliveriesKey(job.hook.ID), jsonData); err != nil {
+			log.Logger.Error().Err(err).Str("webhook", job.hook.ID).Msg("Failed to log webhook delivery")
+		}
+	}
+
+	if err == nil {
+		webhookDeliveriesTotal.WithLabelValues("delivered").Inc()
+		return
+	}
+	log.Logger.Warn().Err(err).Str("webhook", job.hook.ID).Str("delivery", job.delivery).Int("attempt", job.attempt).Msg("Webhook delivery failed")
+	if job.attempt >= d.cfg.MaxAttempts || d.ctx.Err() != nil {
+		d.deadLetter(job, err.Error())
+		return
+	}
+	webhookDeliveriesTotal.WithLabelValues("retried").Inc()
+	delay := time.Duration(d.cfg.Backoff) * time.Second << (job.attempt - 1)
+	job.attempt++
+	d.retry(job, delay)
+}
+
+// send posts the event of job to its webhook and returns the response status
+func (d *webhookDispatcher) send(job webhookJob) (int, error) {
+	req, err := http.NewRequestWithContext(d.ctx, http.MethodPost, job.hook.URL, bytes.NewReader(job.body))
+	if err != nil {
+		return 0, err
+	}
+	timestamp := time.Now().Unix()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "house-webhook")
+	req.Header.Set("X-House-Event", job.event.Type)
+	req.Header.Set("X-House-Delivery", job.delivery)
+	req.Header.Set("X-House-Timestamp", strconv.FormatInt(timestamp, 10))
+	req.Header.Set("X-House-Signature", signWebhook(job.hook.Secret, timestamp, job.body))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	// Drain a little of the body so the connection can be reused
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	return resp.StatusCode, nil
+}
+
+// pushLog appends value to a delivery log, keeping its latest LogSize entries
+func (d *webhookDispatcher) pushLog(key string, value []byte) error {
+	n, err := storage.RPush(ctx, key, value)
+	if err != nil || n <= int64(d.cfg.LogSize) {
+		return err
+	}
+	return storage.LTrim(ctx, key, -int64(d.cfg.LogSize), -1)
+}
+
+// deadLetter records a delivery given up on
+func (d *webhookDispatcher) deadLetter(job webhookJob, reason string) {
+	webhookDeliveriesTotal.WithLabelValues("dead_lettered").Inc()
+	letter := DeadLetter{
+		Delivery: job.delivery,
+		Webhook:  job.hook.ID,
+		URL:      job.hook.URL,
+		Event:    job.event,
+		Attempts: job.attempt,
+		Error:    reason,
+		Time:     time.Now(),
+	}
+	jsonData, err := json.Marshal(letter)
+	if err == nil {
+		err = d.pushLog(WebhookDeadLettersKey, jsonData)
+	}
+	if err != nil {
+		log.Logger.Error().Err(err).Str("webhook", job.hook.ID).Str("delivery", job.delivery).Msg("Failed to dead-letter webhook delivery")
+	}
+}
+
+// notifyRecord raises the record event of a record accepted by this
+// instance, then queues the check of the thresholds watching its dataset
+func notifyRecord(e RecordEvent) {
+	if webhooks == nil {
+		return
+	}
+	webhooks.dispatch(WebhookEvent{
+		ID:      randomHex(8),
+		Type:    WebhookEventRecord,
+		Time:    e.Time,
+		Region:  e.Region,
+		Dataset: e.Dataset,
+		Day:     e.Day,
+		Data:    e.Data,
+	}, webhooks.matching(WebhookEventRecord, e.Region, e.Dataset))
+
+	if e.Dataset == config.DatasetMonthly {
+		return
+	}
+	if hooks := webhooks.matching(WebhookEventThreshold, e.Region, e.Dataset); len(hooks) > 0 {
+		webhooks.queueCheck(thresholdCheck{event: e, hooks: hooks})
+	}
+}
+
+// queueCheck queues a threshold check, waiting while the queue is full so
+// that large imports are checked at the pace of the workers
+func (d *webhookDispatcher) queueCheck(check thresholdCheck) {
+	select {
+	case d.checks <- check:
+	case <-d.ctx.Done():
+	}
+}
+
+// checkWork runs the queued threshold checks until the dispatcher stops
+func (d *webhookDispatcher) checkWork() {
+	for {
+		select {
+		case <-d.ctx.Done():
+			return
+		case check := <-d.checks:
+			d.checkThresholds(check.event, check.hooks)
+		}
+	}
+}
+
+// checkThresholds raises a threshold event for every threshold of hooks the
+// record of e crosses, compared with the previous record of its dataset
+func (d *webhookDispatcher) checkThresholds(e RecordEvent, hooks []Webhook) {
+	var record DailyHouseResp
+	if err := json.Unmarshal(e.Data, &record); err != nil {
+		log.Logger.Error().Err(err).Str("day", e.Day).Msg("Failed to unmarshal record for thresholds")
+		return
+	}
+	prev, found, err := previousRecord(ctx, e.Region, e.Day)
+	if err != nil {
+		log.Logger.Error().Err(err).Str("day", e.Day).Str("region", e.Region).Msg("Failed to get previous record for thresholds")
+		return
+	}
+	if !found {
+		return
+	}
+	for _, hook := range hooks {
+		for _, th := range hook.Thresholds {
+			before, _ := prev.DailyData.Metric(th.Metric)
+			after, _ := record.DailyData.Metric(th.Metric)
+			direction, ok := crossThreshold(th, before, after)
+			if !ok {
+				continue
+			}
+			d.dispatch(WebhookEvent{
+				ID:      randomHex(8),
+				Type:    WebhookEventThreshold,
+				Time:    e.Time,
+				Region:  e.Region,
+				Dataset: e.Dataset,
+				Day:     e.Day,
+				Data:    e.Data,
+				Crossing: &ThresholdCrossing{
+					Metric:      th.Metric,
+					Threshold:   th.Value,
+					Direction:   direction,
+					Previous:    before,
+					PreviousDay: prev.Day,
+					Current:     after,
+				},
+			}, []Webhook{hook})
+		}
+	}
+}
+
+// previousRecord returns the latest record of the same granularity as day
+// stored within max_range_days before it
+func previousRecord(ctx context.Context, region, day string) (DailyHouseResp, bool, error) {
+	t, err := parseDay(day)
+	if err != nil {
+		return DailyHouseResp{}, false, err
+	}
+	days, err := GetHouseDaysInRange(ctx, t.AddDate(0, 0, -appConfig.MaxRangeDays), t.Add(-time.Second), region)
+	if err != nil {
+		return DailyHouseResp{}, false, err
+	}
+	for i := len(days) - 1; i >= 0; i-- {
+		if len(days[i]) == len(day) {
+			return GetHouseData(ctx, days[i], region)
+		}
+	}
+	return DailyHouseResp{}, false, nil
+}
+
+// notifyFortune raises the fortune event of a poem stored by this instance
+func notifyFortune(poem Poem) {
+	if webhooks == nil {
+		return
+	}
+	data, err := json.Marshal(poem)
+	if err != nil {
+		log.Logger.Error().Err(err).Str("day", poem.Day).Msg("Failed to marshal fortune event")
+		return
+	}
+	webhooks.dispatch(WebhookEvent{
+		ID:   randomHex(8),
+		Type: WebhookEventFortune,
+		Time: time.Now(),
+		Day:  poem.Day,
+		Data: data,
+	}, webhooks.matching(WebhookEventFortune, "", ""))
+}
+
+// createWebhook creates a subscription:
+// {"url": "...", "events": ["record", "threshold"], "regions": ["beijing"],
+// "datasets": ["old"], "thresholds": [{"metric": "total_count", "value": 300}]}.
+// The secret is generated unless given, and returned only here.
+func createWebhook(c *gin.Context) {
+	var req struct {
+		URL        string             `json:"url" binding:"required"`
+		Secret     string             `json:"secret"`
+		Events     []string           `json:"events" binding:"required,min=1"`
+		Regions    []string           `json:"regions"`
+		Datasets   []string           `json:"datasets"`
+		Thresholds []WebhookThreshold `json:"thresholds"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondValidationError(c, bindingFieldErrors(err))
+		return
+	}
+
+	var errs []FieldError
+	if u, err := url.Parse(req.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		errs = append(errs, FieldError{Field: "url", Rule: "url", Message: "must be an absolute http or https URL"})
+	}
+	eventTypes := []string{WebhookEventRecord, WebhookEventFortune, WebhookEventThreshold}
+	for _, event := range req.Events {
+		if !slices.Contains(eventTypes, event) {
+			errs = append(errs, FieldError{Field: "events", Rule: "oneof", Message: "must be among " + strings.Join(eventTypes, ", ")})
+			break
+		}
+	}
+	for _, code := range req.Regions {
+		if _, ok := lookupRegion(code); !ok {
+			errs = append(errs, FieldError{Field: "regions", Rule: "oneof", Message: "must be among " + strings.Join(regionCodes(), ", ")})
+			break
+		}
+	}
+	datasets := []string{config.DatasetNew, config.DatasetOld, config.DatasetMonthly}
+	for _, name := range req.Datasets {
+		if !slices.Contains(datasets, name) {
+			errs = append(errs, FieldError{Field: "datasets", Rule: "oneof", Message: "must be among " + strings.Join(datasets, ", ")})
+			break
+		}
+	}
+	if slices.Contains(req.Events, WebhookEventThreshold) && len(req.Thresholds) == 0 {
+		errs = append(errs, FieldError{Field: "thresholds", Rule: "required", Message: "must be set for threshold events"})
+	}
+	for i, th := range req.Thresholds {
+		field := fmt.Sprintf("thresholds[%d]", i)
+		if !slices.Contains(houseMetrics, th.Metric) {
+			errs = append(errs, FieldError{Field: field + ".metric", Rule: "oneof", Message: "must be one of " + strings.Join(houseMetrics, ", ")})
+		}
+		switch th.Direction {
+		case "":
+			req.Thresholds[i].Direction = thresholdBoth
+		case thresholdUp, thresholdDown, thresholdBoth:
+		default:
+			errs = append(errs, FieldError{Field: field + ".direction", Rule: "oneof", Message: "must be up, down or both"})
+		}
+	}
+	if len(errs) > 0 {
+		respondValidationError(c, errs)
+		return
+	}
+
+	hook := Webhook{
+		ID:         randomHex(8),
+		URL:        req.URL,
+		Secret:     req.Secret,
+		Events:     req.Events,
+		Regions:    req.Regions,
+		Datasets:   req.Datasets,
+		Thresholds: req.Thresholds,
+		CreatedAt:  time.Now(),
+	}
+	if hook.Secret == "" {
+		hook.Secret = webhookSecretPrefix + randomHex(24)
+	}
+	err := saveWebhook(hook)
+	if err == nil {
+		err = storage.ZAdd(ctx, WebhooksSetKey, float64(hook.CreatedAt.Unix()), hook.ID)
+	}
+	if err != nil {
+		log.Logger.Error().Err(err).Str("url", hook.URL).Msg("Failed to create webhook")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create webhook"})
+		return
+	}
+	if webhooks != nil {
+		webhooks.invalidate()
+	}
+
+	log.Logger.Info().Str("id", hook.ID).Str("url", hook.URL).Strs("events", hook.Events).Msg("Webhook created")
+	c.JSON(http.StatusOK, gin.H{"secret": hook.Secret, "webhook": hook.redacted()})
+}
+
+// listWebhooksHandler lists every webhook, including deleted ones
+func listWebhooksHandler(c *gin.Context) {
+	hooks, err := listWebhooks()
+	if err != nil {
+		log.Logger.Error().Err(err).Msg("Failed to list webhooks")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to list webhooks"})
+		return
+	}
+	for i := range hooks {
+		hooks[i] = hooks[i].redacted()
+	}
+	c.JSON(http.StatusOK, gin.H{"data": hooks})
+}
+
+// deleteWebhook stops the deliveries of the webhook with the given ID; its
+// delivery log is kept
+func deleteWebhook(c *gin.Context) {
+	id := c.Param("id")
+	jsonData, found, err := storage.Get(ctx, formatWebhookKey(id))
+	var hook Webhook
+	if err == nil && found {
+		err = json.Unmarshal([]byte(jsonData), &hook)
+	}
+	if err != nil {
+		log.Logger.Error().Err(err).Str("id", id).Msg("Failed to get webhook")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to delete webhook"})
+		return
+	}
+	if !found || hook.Deleted {
+		c.JSON(http.StatusNotFound, gin.H{"msg": "webhook not found"})
+		return
+	}
+
+	now := time.Now()
+	hook.Deleted = true
+	hook.DeletedAt = &now
+	if err := saveWebhook(hook); err != nil {
+		log.Logger.Error().Err(err).Str("id", id).Msg("Failed to delete webhook")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to delete webhook"})
+		return
+	}
+	if webhooks != nil {
+		webhooks.invalidate()
+	}
+	log.Logger.Info().Str("id", id).Msg("Webhook deleted")
+	c.JSON(http.StatusOK, hook.redacted())
+}
+
+// getWebhookDeliveries lists the latest delivery attempts of a webhook,
+// newest first (limit, default 100)
+func getWebhookDeliveries(c *gin.Context) {
+	id := c.Param("id")
+	limit, ok := webhookLogLimit(c)
+	if !ok {
+		return
+	}
+	values, err := storage.LRange(ctx, formatWebhookDeliveriesKey(id), -limit, -1)
+	if err != nil {
+		log.Logger.Error().Err(err).Str("id", id).Msg("Failed to get webhook deliveries")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get webhook deliveries"})
+		return
+	}
+	deliveries := make([]WebhookDelivery, 0, len(values))
+	for i := len(values) - 1; i >= 0; i-- {
+		var delivery WebhookDelivery
+		if err := json.Unmarshal([]byte(values[i]), &delivery); err != nil {
+			log.Logger.Error().Err(err).Str("id", id).Msg("Failed to unmarshal webhook delivery")
+			continue
+		}
+		deliveries = append(deliveries, delivery)
+	}
+	c.JSON(http.StatusOK, gin.H{"webhook": id, "data": deliveries})
+}
+
+// getDeadLetters lists the latest deliveries given up on, newest first
+// (limit, default 100)
+func getDeadLetters(c *gin.Context) {
+	limit, ok := webhookLogLimit(c)
+	if !ok {
+		return
+	}
+	values, err := storage.LRange(ctx, WebhookDeadLettersKey, -limit, -1)
+	if err != nil {
+		log.Logger.Error().Err(err).Msg("Failed to get webhook dead letters")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get dead letters"})
+		return
+	}
+	letters := make([]DeadLetter, 0, len(values))
+	for i := len(values) - 1; i >= 0; i-- {
+		var letter DeadLetter
+		if err := json.Unmarshal([]byte(values[i]), &letter); err != nil {
+			log.Logger.Error().Err(err).Msg("Failed to unmarshal webhook dead letter")
+			continue
+		}
+		letters = append(letters, letter)
+	}
+	c.JSON(http.StatusOK, gin.H{"data": letters})
+}
+
+func webhookLogLimit(c *gin.Context) (int64, bool) {
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", strconv.Itoa(defaultWebhookLogLimit)))
+	if err != nil || limit < 1 || limit > maxWebhookLogLimit {
+		respondValidationError(c, []FieldError{{Field: "limit", Rule: "range", Message: fmt.Sprintf("must be between 1 and %d", maxWebhookLogLimit)}})
+		return 0, false
+	}
+	return int64(limit), true
+}
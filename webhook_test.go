@@ -0,0 +1,172 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/LIUHUANUCAS/house/config"
+)
+
+func TestCrossThreshold(t *testing.T) {
+	th := WebhookThreshold{Metric: "total_count", Value: 100, Direction: thresholdBoth}
+	cases := []struct {
+		prev, cur float64
+		want      string
+		ok        bool
+	}{
+		{90, 100, thresholdUp, true},
+		{100, 99, thresholdDown, true},
+		{100, 120, "", false},
+		{50, 60, "", false},
+	}
+	for _, tc := range cases {
+		if got, ok := crossThreshold(th, tc.prev, tc.cur); got != tc.want || ok != tc.ok {
+			t.Errorf("crossThreshold(%v, %v) = %q, %v", tc.prev, tc.cur, got, ok)
+		}
+	}
+	th.Direction = thresholdUp
+	if _, ok := crossThreshold(th, 120, 80); ok {
+		t.Error("a downward crossing should not fire an up threshold")
+	}
+}
+
+func TestWebhookWants(t *testing.T) {
+	hook := Webhook{Events: []string{WebhookEventRecord, WebhookEventFortune}, Regions: []string{"beijing"}}
+	if !hook.wants(WebhookEventRecord, "beijing", "old") || hook.wants(WebhookEventRecord, "shanghai", "old") {
+		t.Error("record events should be filtered by region")
+	}
+	if !hook.wants(WebhookEventFortune, "", "") {
+		t.Error("fortune events ignore the region filter")
+	}
+	hook.Deleted = true
+	if hook.wants(WebhookEventRecord, "beijing", "old") {
+		t.Error("deleted webhooks get no events")
+	}
+}
+
+func TestWebhookDelivery(t *testing.T) {
+	db, err := OpenBoltStorage(filepath.Join(t.TempDir(), "house.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	saved := storage
+	storage = db
+	defer func() { storage = saved }()
+
+	// Fails once, then checks the signature
+	var calls atomic.Int32
+	verified := make(chan bool, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		ts, _ := strconv.ParseInt(r.Header.Get("X-House-Timestamp"), 10, 64)
+		verified <- r.Header.Get("X-House-Signature") == signWebhook("s3cret", ts, body)
+	}))
+	defer srv.Close()
+
+	c, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	d := &webhookDispatcher{
+		cfg:    config.WebhookConfig{Workers: 1, MaxAttempts: 3, Timeout: 5, LogSize: 10},
+		ctx:    c,
+		client: srv.Client(),
+		queue:  make(chan webhookJob, 10),
+	}
+	go d.work()
+
+	hook := Webhook{ID: "w1", URL: srv.URL, Secret: "s3cret", Events: []string{WebhookEventFortune}}
+	d.dispatch(WebhookEvent{ID: "e1", Type: WebhookEventFortune, Day: "2025-06-09", Data: []byte(`{}`)}, []Webhook{hook})
+
+	select {
+	case ok := <-verified:
+		if !ok {
+			t.Error("signature mismatch")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("webhook not retried")
+	}
+	// The second attempt is logged once the handler returns
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		deliveries, err := db.LRange(ctx, formatWebhookDeliveriesKey("w1"), 0, -1)
+		if err == nil && len(deliveries) == 2 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("want 2 logged attempts, got %d (%v)", len(deliveries), err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestWebhookLogBounded(t *testing.T) {
+	useTestStorage(t)
+	d := &webhookDispatcher{cfg: config.WebhookConfig{LogSize: 3}}
+	for i := 1; i <= 5; i++ {
+		if err := d.pushLog(WebhookDeadLettersKey, []byte(strconv.Itoa(i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	got, err := storage.LRange(ctx, WebhookDeadLettersKey, 0, -1)
+	if err != nil || !slices.Equal(got, []string{"3", "4", "5"}) {
+		t.Errorf("want the latest 3 entries, got %v (%v)", got, err)
+	}
+}
+
+func TestWebhookDrainOnShutdown(t *testing.T) {
+	useTestStorage(t)
+	c, cancel := context.WithCancel(context.Background())
+	d := &webhookDispatcher{
+		cfg:   config.WebhookConfig{Workers: 1, MaxAttempts: 3, Timeout: 5, LogSize: 10},
+		ctx:   c,
+		queue: make(chan webhookJob, 10),
+	}
+	hook := Webhook{ID: "w1", URL: "http://127.0.0.1:1"}
+	d.enqueue(webhookJob{hook: hook, delivery: "queued", attempt: 1})
+	d.retry(webhookJob{hook: hook, delivery: "retrying", attempt: 2}, time.Hour)
+
+	cancel()
+	d.work()
+	letters, err := storage.LRange(ctx, WebhookDeadLettersKey, 0, -1)
+	if err != nil || len(letters) != 2 {
+		t.Errorf("want both jobs dead-lettered, got %d (%v)", len(letters), err)
+	}
+}
+
+func TestThresholdCheckQueued(t *testing.T) {
+	useTestStorage(t)
+	prev := DailyHouseResp{Day: "2025-06-08", DailyData: DailyData{TotalCount: 90, TotalArea: 8000, HouseCount: 80, HouseArea: 7000}}
+	if err := StoreHouseData(ctx, prev.Day, prev, beijingKey); err != nil {
+		t.Fatal(err)
+	}
+
+	c, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	d := &webhookDispatcher{ctx: c, queue: make(chan webhookJob, 10), checks: make(chan thresholdCheck, 1)}
+	go d.checkWork()
+
+	data := []byte(`{"day":"2025-06-09","daily_data":{"total_count":110}}`)
+	hook := Webhook{ID: "w1", Events: []string{WebhookEventThreshold}, Thresholds: []WebhookThreshold{{Metric: "total_count", Value: 100, Direction: thresholdUp}}}
+	d.queueCheck(thresholdCheck{event: RecordEvent{Region: beijingKey, Dataset: config.DatasetOld, Day: "2025-06-09", Data: data}, hooks: []Webhook{hook}})
+
+	select {
+	case job := <-d.queue:
+		if job.event.Crossing == nil || job.event.Crossing.Direction != thresholdUp || job.event.Crossing.PreviousDay != prev.Day {
+			t.Errorf("unexpected threshold event %+v", job.event)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("threshold event not raised")
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"sync"
+)
+
+// writeBackConcurrency bounds the background writes running at once
+const writeBackConcurrency = 16
+
+// writePool tracks background writes (cache fills from the in-memory stores)
+// so they can be flushed before the process exits
+type writePool struct {
+	mu     sync.Mutex
+	wg     sync.WaitGroup
+	sem    chan struct{} // one slot per running write
+	closed bool
+}
+
+// newWritePool returns a pool running at most limit writes at once
+func newWritePool(limit int) *writePool {
+	return &writePool{sem: make(chan struct{}, limit)}
+}
+
+// writeBacks is the pool used by the read handlers to write data back to storage
+var writeBacks = newWritePool(writeBackConcurrency)
+
+// Go runs fn in the background. A write-back is only a cache fill, so when the
+// pool is at its limit fn is dropped and the in-memory copy is written back on
+// a later read. Once the pool is flushing, fn runs synchronously so it is
+// never lost.
+func (p *writePool) Go(fn func()) {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		fn()
+		return
+	}
+	select {
+	case p.sem <- struct{}{}:
+	default:
+		p.mu.Unlock()
+		return
+	}
+	p.wg.Add(1)
+	p.mu.Unlock()
+
+	go func() {
+		defer p.wg.Done()
+		defer func() { <-p.sem }()
+		fn()
+	}()
+}
+
+// Flush stops accepting background writes and waits for the running ones to
+// finish, or for ctx to be done
+func (p *writePool) Flush(ctx context.Context) error {
+	p.mu.Lock()
+	p.closed = true
+	p.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		p.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
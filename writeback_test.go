@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"runtime"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestWritePoolLimit(t *testing.T) {
+	p := newWritePool(2)
+	release := make(chan struct{})
+	var started atomic.Int32
+	goroutines := runtime.NumGoroutine()
+	for range 100 {
+		p.Go(func() {
+			started.Add(1)
+			<-release
+		})
+	}
+
+	// Writes past the limit are dropped without starting a goroutine
+	if n := runtime.NumGoroutine() - goroutines; n > 2 {
+		t.Errorf("want at most 2 goroutines, got %d", n)
+	}
+	close(release)
+	if err := p.Flush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if n := started.Load(); n != 2 {
+		t.Errorf("want 2 writes run, got %d", n)
+	}
+
+	// Once flushing, writes run synchronously
+	ran := false
+	p.Go(func() { ran = true })
+	if !ran {
+		t.Error("a write after Flush should run before Go returns")
+	}
+}
+
+func TestWritePoolFlushTimeout(t *testing.T) {
+	p := newWritePool(1)
+	release := make(chan struct{})
+	defer close(release)
+	p.Go(func() { <-release })
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if err := p.Flush(ctx); err != context.DeadlineExceeded {
+		t.Errorf("want %v, got %v", context.DeadlineExceeded, err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Flush waited %v past its timeout", elapsed)
+	}
+}